@@ -1,4 +1,6 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  await handleEmailLinks();
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -21,6 +23,71 @@ document.getElementById('login-form').addEventListener('submit', async (event) =
   await login();
 });
 
+async function handleEmailLinks() {
+  const params = new URLSearchParams(window.location.search);
+  const verifyToken = params.get('verify_email');
+  const resetToken = params.get('reset_password');
+  if (!verifyToken && !resetToken) return;
+
+  // Drop the single-use token from the address bar.
+  window.history.replaceState({}, '', window.location.pathname);
+
+  try {
+    if (verifyToken) {
+      const res = await fetch('/api/users/verify_email', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: verifyToken }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to verify email: ${data.error}`);
+      }
+      alert('Email verified!');
+    }
+
+    if (resetToken) {
+      const password = prompt('Choose a new password');
+      if (!password) return;
+      const res = await fetch('/api/password_reset/confirm', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: resetToken, password }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to reset password: ${data.error}`);
+      }
+      alert('Password changed, please log in.');
+    }
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function forgotPassword() {
+  const email = document.getElementById('email').value;
+  if (!email) {
+    alert('Enter your email first.');
+    return;
+  }
+
+  try {
+    const res = await fetch('/api/password_reset', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to request password reset: ${data.error}`);
+    }
+    alert('If that account exists, a reset link is on its way.');
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function createVideoDraft() {
   const title = document.getElementById('video-title').value;
   const description = document.getElementById('video-description').value;
@@ -61,11 +128,16 @@ async function login() {
       },
       body: JSON.stringify({ email, password }),
     });
-    const data = await res.json();
+    let data = await res.json();
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
 
+    if (data.mfa_required) {
+      data = await completeTOTPLogin(data.challenge_token);
+      if (!data) return;
+    }
+
     if (data.token) {
       localStorage.setItem('token', data.token);
       document.getElementById('auth-section').style.display = 'none';
@@ -79,6 +151,31 @@ async function login() {
   }
 }
 
+async function completeTOTPLogin(challengeToken) {
+  const input = prompt('Enter the code from your authenticator app, or a recovery code');
+  if (!input) return null;
+
+  const body = { challenge_token: challengeToken };
+  if (input.includes('-')) {
+    body.recovery_code = input;
+  } else {
+    body.code = input;
+  }
+
+  const res = await fetch('/api/login/totp', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    throw new Error(`Failed to login: ${data.error}`);
+  }
+  return data;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -205,6 +302,13 @@ async function getVideos() {
     for (const video of videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
+      if (video.preview_url) {
+        const preview = document.createElement('img');
+        preview.className = 'video-preview';
+        preview.src = video.preview_url;
+        preview.loading = 'lazy';
+        listItem.appendChild(preview);
+      }
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
@@ -242,7 +346,7 @@ async function getVideo(videoID) {
     }
 
     const video = await res.json();
-    viewVideo(video);
+    await viewVideo(video);
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -250,7 +354,21 @@ async function getVideo(videoID) {
 
 let currentVideo = null;
 
-function viewVideo(video) {
+async function getStreamURL(videoID) {
+  const res = await fetch(`/api/videos/${videoID}/stream_url`, {
+    method: 'GET',
+    headers: {
+      Authorization: `Bearer ${localStorage.getItem('token')}`,
+    },
+  });
+  if (!res.ok) {
+    throw new Error('Failed to get stream URL.');
+  }
+  const data = await res.json();
+  return data.url;
+}
+
+async function viewVideo(video) {
   currentVideo = video;
   document.getElementById('video-display').style.display = 'block';
   document.getElementById('video-title-display').textContent = video.title;
@@ -264,16 +382,28 @@ function viewVideo(video) {
 	thumbnailImg.src = video.thumbnail_url;
   }
 
+  const streamURL = video.video_url ? await getStreamURL(video.id) : '';
+
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
     if (!video.video_url) {
       videoPlayer.style.display = 'none';
     } else {
       videoPlayer.style.display = 'block';
-      videoPlayer.src = video.video_url;
+      const rendition = video.normalized_video_url ? '&rendition=normalized' : '';
+      videoPlayer.src = `${streamURL}${rendition}`;
       videoPlayer.load();
     }
   }
+
+  const audioPlayer = document.getElementById('audio-player');
+  if (!video.audio_aac_url) {
+    audioPlayer.style.display = 'none';
+    audioPlayer.removeAttribute('src');
+  } else {
+    audioPlayer.style.display = 'block';
+    audioPlayer.src = `${streamURL}&rendition=audio_aac`;
+  }
 }
 
 async function deleteVideo() {
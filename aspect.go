@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// aspectOther is the class of videos that match none of aspectRatios.
+const aspectOther = "other"
+
+// aspectRatios are the ratios videos are classified by. A video gets the
+// closest one within aspectTolerance.
+var aspectRatios = []struct {
+	class         string
+	width, height int
+}{
+	{"16:9", 16, 9},
+	{"9:16", 9, 16},
+	{"4:3", 4, 3},
+	{"3:4", 3, 4},
+	{"1:1", 1, 1},
+	{"21:9", 21, 9},
+	{"4:5", 4, 5},
+	{"3:2", 3, 2},
+	{"2:3", 2, 3},
+	{"16:10", 16, 10},
+}
+
+// aspectTolerance is relative, so it covers encoder rounding (1366x768) and
+// the spread of cinema ratios (2.33 to 2.39) sold as 21:9.
+const aspectTolerance = 0.03
+
+func classifyAspectRatio(ratio float64) string {
+	class := aspectOther
+	closest := aspectTolerance
+	for _, candidate := range aspectRatios {
+		deviation := math.Abs(ratio/(float64(candidate.width)/float64(candidate.height)) - 1)
+		if deviation < closest {
+			class = candidate.class
+			closest = deviation
+		}
+	}
+	return class
+}
+
+// aspectKeyPrefix is the bucket folder for videos of the class. 16:9 and
+// 9:16 keep the folder names they were stored under before there were more
+// classes.
+func aspectKeyPrefix(class string) string {
+	switch class {
+	case "16:9":
+		return "landscape"
+	case "9:16":
+		return "portrait"
+	case aspectOther:
+		return aspectOther
+	}
+	return strings.ReplaceAll(class, ":", "x")
+}
+
+// videoDimensions measures the probed video as it is displayed, with any
+// rotation applied.
+func videoDimensions(probe media.ProbeResult) (database.VideoDimensions, error) {
+	stream, ok := probe.VideoStream()
+	if !ok || stream.Width <= 0 || stream.Height <= 0 {
+		return database.VideoDimensions{}, errors.New("video has no dimensions")
+	}
+	width, height := stream.DisplaySize()
+	ratio := float64(width) / float64(height)
+	return database.VideoDimensions{
+		Width:       width,
+		Height:      height,
+		AspectRatio: math.Round(ratio*10000) / 10000,
+		AspectClass: classifyAspectRatio(ratio),
+	}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestClassifyAspectRatio(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          string
+	}{
+		{1920, 1080, "16:9"},
+		{1366, 768, "16:9"},
+		{1080, 1920, "9:16"},
+		{1440, 1080, "4:3"},
+		{640, 480, "4:3"},
+		{1080, 1440, "3:4"},
+		{1080, 1080, "1:1"},
+		{2560, 1080, "21:9"},
+		{3440, 1440, "21:9"},
+		{239, 100, "21:9"},
+		{233, 100, "21:9"},
+		{245, 100, "other"},
+		{1080, 1350, "4:5"},
+		{3000, 2000, "3:2"},
+		{2000, 3000, "2:3"},
+		{1920, 1200, "16:10"},
+		{155, 100, "other"},
+		{185, 100, "other"},
+		{1280, 1024, "other"},
+		{300, 100, "other"},
+	}
+	for _, tt := range tests {
+		if got := classifyAspectRatio(float64(tt.width) / float64(tt.height)); got != tt.want {
+			t.Errorf("classifyAspectRatio(%dx%d) = %s, want %s", tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestAspectKeyPrefix(t *testing.T) {
+	for class, want := range map[string]string{
+		"16:9":      "landscape",
+		"9:16":      "portrait",
+		"21:9":      "21x9",
+		"1:1":       "1x1",
+		aspectOther: "other",
+	} {
+		if got := aspectKeyPrefix(class); got != want {
+			t.Errorf("aspectKeyPrefix(%s) = %s, want %s", class, got, want)
+		}
+	}
+}
+
+// rotated returns a 1920x1080 video stream displayed with the rotation,
+// stored the way newer or older phones store it.
+func rotated(displayMatrix float64, rotateTag string) media.ProbeResult {
+	probe := media.FakeVideo(1920, 1080, 10)
+	stream := &probe.Streams[0]
+	if displayMatrix != 0 {
+		stream.SideData = append(stream.SideData, struct {
+			Rotation float64 `json:"rotation"`
+		}{displayMatrix})
+	}
+	stream.Tags.Rotate = rotateTag
+	return probe
+}
+
+func TestVideoDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		probe         media.ProbeResult
+		width, height int
+		class         string
+	}{
+		{"landscape", media.FakeVideo(1920, 1080, 10), 1920, 1080, "16:9"},
+		{"display matrix", rotated(-90, ""), 1080, 1920, "9:16"},
+		{"rotate tag", rotated(0, "90"), 1080, 1920, "9:16"},
+		{"upside down", rotated(180, ""), 1920, 1080, "16:9"},
+		{"display matrix wins", rotated(270, "0"), 1080, 1920, "9:16"},
+		{"cinema", media.FakeVideo(1920, 804, 10), 1920, 804, "21:9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dimensions, err := videoDimensions(tt.probe)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dimensions.Width != tt.width || dimensions.Height != tt.height || dimensions.AspectClass != tt.class {
+				t.Errorf("dimensions = %+v, want %dx%d %s", dimensions, tt.width, tt.height, tt.class)
+			}
+		})
+	}
+
+	if dimensions, _ := videoDimensions(media.FakeVideo(1366, 768, 10)); dimensions.AspectRatio != 1.7786 {
+		t.Errorf("aspect ratio = %v, want it rounded to 1.7786", dimensions.AspectRatio)
+	}
+	audioOnly := media.FakeVideo(1920, 1080, 10)
+	audioOnly.Streams = audioOnly.Streams[1:]
+	for _, probe := range []media.ProbeResult{audioOnly, media.FakeVideo(0, 0, 10)} {
+		if _, err := videoDimensions(probe); err == nil {
+			t.Errorf("videoDimensions(%+v) succeeded", probe.Streams)
+		}
+	}
+}
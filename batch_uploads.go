@@ -0,0 +1,288 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxBatchItems is the most videos one batch upload may create.
+const maxBatchItems = 100
+
+// batchPollInterval is how often idle batch workers look for queued items
+// they weren't woken for, such as items left after a failed claim.
+const batchPollInterval = time.Minute
+
+// batchManifestItem describes a video to create and the file to upload for
+// it.
+type batchManifestItem struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Filename    string `json:"filename"`
+}
+
+// parseBatchManifest reads a JSON array of items, or CSV with a header row
+// naming the title, description and filename columns. The description
+// column is optional.
+func parseBatchManifest(data []byte) ([]batchManifestItem, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		items := []batchManifestItem{}
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON manifest: %w", err)
+		}
+		return items, nil
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(trimmed)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV manifest: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("manifest is empty")
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"title", "filename"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV manifest has no %s column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	items := []batchManifestItem{}
+	for _, record := range records[1:] {
+		items = append(items, batchManifestItem{
+			Title:       field(record, "title"),
+			Description: field(record, "description"),
+			Filename:    field(record, "filename"),
+		})
+	}
+	return items, nil
+}
+
+// batchFile is a file received for a batch upload, waiting on disk until
+// it is processed. Files of queued items are kept in the batch uploads
+// directory, so they survive a restart.
+type batchFile struct {
+	path   string
+	hasher *contentHasher
+	// tooLarge files were discarded after passing the upload limit.
+	tooLarge bool
+}
+
+// batchFiles are the files of a batch upload by name. Files of queued
+// items are removed from it; remove deletes the rest.
+type batchFiles map[string]*batchFile
+
+// receive copies a file into dir, stopping once it is larger than limit.
+func (files batchFiles) receive(dir, name string, r io.Reader, limit int64) error {
+	if _, ok := files[name]; ok {
+		return fmt.Errorf("more than one file is named %q", name)
+	}
+	tempFile, err := os.CreateTemp(dir, "tubely-batch-*")
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	file := &batchFile{path: tempFile.Name(), hasher: newContentHasher()}
+	files[name] = file
+
+	if _, err := io.Copy(tempFile, io.LimitReader(file.hasher.reader(r), limit+1)); err != nil {
+		return err
+	}
+	if file.hasher.size > limit {
+		file.tooLarge = true
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+		if err := tempFile.Truncate(0); err != nil {
+			return err
+		}
+	}
+	return tempFile.Close()
+}
+
+// extract receives the files of the zip archive that are in names, so
+// entries no item refers to are never unpacked. Together with the files
+// already received they may take up at most total bytes; unpacking stops
+// with a quotaError past that, so a small archive can't expand without
+// bound.
+func (files batchFiles) extract(dir, archivePath string, names map[string]bool, limit, total int64) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	remaining := total - files.size()
+	for _, entry := range archive.File {
+		name := path.Clean(entry.Name)
+		if entry.FileInfo().IsDir() || !names[name] {
+			continue
+		}
+		entryReader, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("invalid zip archive: %w", err)
+		}
+		// Entries past the upload limit are only read one byte beyond it,
+		// rather than drained.
+		err = files.receive(dir, name, io.LimitReader(entryReader, min(limit, remaining)+1), limit)
+		entryReader.Close()
+		if err != nil {
+			return err
+		}
+		if !files[name].tooLarge {
+			remaining -= files[name].hasher.size
+		}
+		if remaining < 0 {
+			return &quotaError{fmt.Sprintf("Archive expands to more than the %d bytes the batch may store", total)}
+		}
+	}
+	return nil
+}
+
+// size returns how many bytes the files that weren't too large take up.
+func (files batchFiles) size() int64 {
+	var size int64
+	for _, file := range files {
+		if !file.tooLarge {
+			size += file.hasher.size
+		}
+	}
+	return size
+}
+
+// archiveManifest returns the manifest.json or manifest.csv at the root of
+// the zip archive, or nil if there is none.
+func archiveManifest(archivePath string) ([]byte, error) {
+	const maxManifestSize = 1 << 20
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		if entry.Name != "manifest.json" && entry.Name != "manifest.csv" {
+			continue
+		}
+		entryReader, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		defer entryReader.Close()
+		data, err := io.ReadAll(io.LimitReader(entryReader, maxManifestSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		if len(data) > maxManifestSize {
+			return nil, errors.New("manifest is too large")
+		}
+		return data, nil
+	}
+	return nil, nil
+}
+
+func (files batchFiles) remove() {
+	for name, file := range files {
+		os.Remove(file.path)
+		delete(files, name)
+	}
+}
+
+// notifyBatchWorkers wakes an idle batch worker to look for queued items.
+func (cfg *apiConfig) notifyBatchWorkers() {
+	select {
+	case cfg.batchQueued <- struct{}{}:
+	default:
+	}
+}
+
+// runBatchWorker processes queued batch items one at a time, waiting for
+// more to be queued once there are none, until ctx is done. Items are
+// claimed from the database, so several workers can run side by side.
+func (cfg *apiConfig) runBatchWorker(ctx context.Context) {
+	for {
+		item, found, err := cfg.db.ClaimBatchItem()
+		if err != nil {
+			log.Printf("Couldn't claim batch item: %v", err)
+		}
+		if found {
+			// Another worker may take the next item meanwhile.
+			cfg.notifyBatchWorkers()
+			cfg.processBatchItem(item)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-cfg.batchQueued:
+		case <-time.After(batchPollInterval):
+		}
+	}
+}
+
+func (cfg *apiConfig) processBatchItem(item database.ClaimedBatchItem) {
+	status := database.BatchItemSucceeded
+	var errMessage *string
+	err := cfg.ingestBatchFile(item)
+	os.Remove(item.FilePath)
+	if err != nil {
+		log.Printf("Couldn't process item %d of batch %s: %v", item.Position, item.BatchID, err)
+		status = database.BatchItemFailed
+		message := "Error processing video"
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			message = ingestErr.message
+		}
+		errMessage = &message
+	}
+	if err := cfg.db.SetBatchItemStatus(item.BatchID, item.Position, status, errMessage); err != nil {
+		log.Printf("Couldn't update item %d of batch %s: %v", item.Position, item.BatchID, err)
+	}
+}
+
+func (cfg *apiConfig) ingestBatchFile(item database.ClaimedBatchItem) error {
+	video, err := cfg.db.GetVideo(item.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return &ingestError{message: "Video was deleted before its file was processed"}
+	}
+	hasher, err := hashFile(item.FilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ingestError{message: "File was lost before it was processed"}
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(item.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = cfg.ingestVideo(context.Background(), video, item.UserID, file, hasher)
+	return err
+}
@@ -1,10 +1,64 @@
 package main
 
-import "net/http"
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
 
-func noCacheMiddleware(next http.Handler) http.Handler {
+// assetCachePolicy maps an asset type (the first part of its media type,
+// e.g. "image") to the Cache-Control header it is served with. The "default"
+// entry applies to every other type.
+type assetCachePolicy map[string]string
+
+const (
+	cacheControlImmutable   = "public, max-age=31536000, immutable"
+	cacheControlRevalidate  = "public, no-cache"
+	cacheControlNotFound    = "no-store"
+	assetCacheDefaultPolicy = "default"
+)
+
+// Asset keys are digests of their content, so the file behind a key never
+// changes and media can be cached forever.
+func defaultAssetCachePolicy() assetCachePolicy {
+	return assetCachePolicy{
+		"image":                 cacheControlImmutable,
+		"video":                 cacheControlImmutable,
+		"audio":                 cacheControlImmutable,
+		assetCacheDefaultPolicy: cacheControlRevalidate,
+	}
+}
+
+func (p assetCachePolicy) cacheControl(name string) string {
+	mediaType := mime.TypeByExtension(filepath.Ext(name))
+	assetType, _, _ := strings.Cut(mediaType, "/")
+	if cacheControl, ok := p[assetType]; ok {
+		return cacheControl
+	}
+	return p[assetCacheDefaultPolicy]
+}
+
+// assetCacheMiddleware sets Cache-Control for files under root according to
+// the policy. It also derives a strong ETag from the file name before the
+// file server runs, so conditional requests can be answered with 304.
+// Missing files are answered here with a 404 that is never cached, since
+// the file server drops Cache-Control from its error responses.
+func assetCacheMiddleware(root string, policy assetCachePolicy, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
+		name := path.Clean("/" + r.URL.Path)
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil || !info.Mode().IsRegular() {
+			w.Header().Set("Cache-Control", cacheControlNotFound)
+			http.NotFound(w, r)
+			return
+		}
+
+		base := path.Base(name)
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(base, path.Ext(base))+`"`)
+		w.Header().Set("Cache-Control", policy.cacheControl(base))
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetCacheMiddleware(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"thumb.png", "clip.mp4", "track.aac", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := assetCacheMiddleware(root, defaultAssetCachePolicy(), http.FileServer(http.Dir(root)))
+	get := func(name string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		status       int
+		cacheControl string
+		etag         string
+	}{
+		{"thumb.png", http.StatusOK, cacheControlImmutable, `"thumb"`},
+		{"clip.mp4", http.StatusOK, cacheControlImmutable, `"clip"`},
+		{"track.aac", http.StatusOK, cacheControlImmutable, `"track"`},
+		{"notes.txt", http.StatusOK, cacheControlRevalidate, `"notes"`},
+		{"missing.png", http.StatusNotFound, cacheControlNotFound, ""},
+		{"../outside.png", http.StatusNotFound, cacheControlNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.name, nil)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("ETag = %q, want %q", got, tt.etag)
+			}
+		})
+	}
+
+	rec := get("thumb.png", http.Header{"If-None-Match": {`"thumb"`}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional request = %d with %d bytes, want 304", rec.Code, rec.Body.Len())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != cacheControlImmutable {
+		t.Errorf("Cache-Control of 304 = %q", got)
+	}
+	if rec := get("thumb.png", http.Header{"If-None-Match": {`"other"`}}); rec.Code != http.StatusOK {
+		t.Errorf("request for a changed ETag = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// chapterOptions control the chapters suggested for uploaded videos.
+type chapterOptions struct {
+	// SceneThreshold is the scene score a frame needs to start a chapter;
+	// zero turns suggestions off.
+	SceneThreshold float64
+	// MinLength is the shortest chapter suggested.
+	MinLength   time.Duration
+	MaxChapters int
+}
+
+func defaultChapterOptions() chapterOptions {
+	return chapterOptions{
+		SceneThreshold: 0.4,
+		MinLength:      10 * time.Second,
+		MaxChapters:    20,
+	}
+}
+
+// chapterStarts picks where chapters start: the start of the video and the
+// strongest scene changes that leave every chapter at least MinLength long.
+func (o chapterOptions) chapterStarts(changes []media.SceneChange, duration time.Duration) []media.SceneChange {
+	starts := []media.SceneChange{{At: 0, Score: 1}}
+	byScore := slices.Clone(changes)
+	slices.SortStableFunc(byScore, func(a, b media.SceneChange) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	for _, change := range byScore {
+		if len(starts) >= o.MaxChapters {
+			break
+		}
+		if duration-change.At < o.MinLength {
+			continue
+		}
+		tooClose := slices.ContainsFunc(starts, func(start media.SceneChange) bool {
+			return (change.At - start.At).Abs() < o.MinLength
+		})
+		if !tooClose {
+			starts = append(starts, change)
+		}
+	}
+	slices.SortFunc(starts, func(a, b media.SceneChange) int {
+		return cmp.Compare(a.At, b.At)
+	})
+	return starts
+}
+
+// generateChapterSuggestions finds chapter starts in the video at
+// sourcePath and extracts a thumbnail for each. Suggestions are optional,
+// so failures are logged and nothing, or no thumbnail, is suggested.
+func (cfg *apiConfig) generateChapterSuggestions(ctx context.Context, ownerID uuid.UUID, sourcePath string, duration float64) []database.ChapterSuggestion {
+	if cfg.chapters.SceneThreshold <= 0 {
+		return nil
+	}
+	changes, err := cfg.media.DetectScenes(ctx, sourcePath, cfg.chapters.SceneThreshold)
+	if err != nil {
+		log.Printf("Couldn't detect scenes: %v", err)
+		return nil
+	}
+
+	suggestions := []database.ChapterSuggestion{}
+	for _, start := range cfg.chapters.chapterStarts(changes, time.Duration(duration*float64(time.Second))) {
+		suggestions = append(suggestions, database.ChapterSuggestion{
+			Start:        start.At.Seconds(),
+			Score:        start.Score,
+			ThumbnailURL: cfg.generateChapterThumbnail(ctx, ownerID, sourcePath, start.At),
+		})
+	}
+	return suggestions
+}
+
+func (cfg *apiConfig) generateChapterThumbnail(ctx context.Context, ownerID uuid.UUID, sourcePath string, at time.Duration) *string {
+	tempFile, err := os.CreateTemp(cfg.assetsRoot, ".chapter-*.jpg")
+	if err != nil {
+		log.Printf("Couldn't create chapter thumbnail: %v", err)
+		return nil
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	if err := cfg.media.ExtractFrame(ctx, sourcePath, tempFile.Name(), at); err != nil {
+		log.Printf("Couldn't create chapter thumbnail: %v", err)
+		return nil
+	}
+	hasher, err := hashFile(tempFile.Name())
+	if err != nil {
+		log.Printf("Couldn't create chapter thumbnail: %v", err)
+		return nil
+	}
+	if err := cfg.checkStorageQuota(ownerID, hasher.size); err != nil {
+		log.Printf("Couldn't store chapter thumbnail: %v", err)
+		return nil
+	}
+	assetPath, err := cfg.storeLocalAsset(ownerID, tempFile.Name(), hasher, "image/jpeg")
+	if err != nil {
+		log.Printf("Couldn't store chapter thumbnail: %v", err)
+		return nil
+	}
+	thumbnailURL := cfg.getAssetURL(assetPath)
+	return &thumbnailURL
+}
+
+// replaceChapterSuggestions stores new suggestions for the video and
+// releases the thumbnails of the ones they replace. The video's chapters
+// are left alone, even if they no longer fit the new file.
+func (cfg *apiConfig) replaceChapterSuggestions(videoID, ownerID uuid.UUID, suggestions []database.ChapterSuggestion) {
+	previous, err := cfg.db.GetChapterSuggestions(videoID)
+	if err == nil {
+		err = cfg.db.ReplaceChapterSuggestions(videoID, suggestions)
+	}
+	if err != nil {
+		log.Printf("Couldn't store chapter suggestions for video %s: %v", videoID, err)
+		cfg.releaseSuggestionThumbnails(ownerID, suggestions)
+		return
+	}
+	cfg.releaseSuggestionThumbnails(ownerID, previous)
+}
+
+func (cfg *apiConfig) releaseSuggestionThumbnails(ownerID uuid.UUID, suggestions []database.ChapterSuggestion) {
+	for _, suggestion := range suggestions {
+		cfg.releaseChapterThumbnail(ownerID, suggestion.ThumbnailURL)
+	}
+}
+
+// releaseChapterThumbnail drops a reference to a suggestion or chapter
+// thumbnail, logging failures.
+func (cfg *apiConfig) releaseChapterThumbnail(ownerID uuid.UUID, thumbnailURL *string) {
+	if thumbnailURL == nil {
+		return
+	}
+	if err := cfg.releaseLocalAsset(ownerID, *thumbnailURL); err != nil {
+		log.Printf("Couldn't release chapter thumbnail %s: %v", *thumbnailURL, err)
+	}
+}
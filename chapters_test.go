@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestChapterStarts(t *testing.T) {
+	options := chapterOptions{SceneThreshold: 0.4, MinLength: 10 * time.Second, MaxChapters: 3}
+	at := func(seconds ...int) []time.Duration {
+		starts := []time.Duration{}
+		for _, s := range seconds {
+			starts = append(starts, time.Duration(s)*time.Second)
+		}
+		return starts
+	}
+
+	tests := []struct {
+		name     string
+		changes  []media.SceneChange
+		duration time.Duration
+		want     []time.Duration
+	}{
+		{"no changes", nil, time.Minute, at(0)},
+		{
+			"strongest changes first",
+			[]media.SceneChange{{At: 20 * time.Second, Score: 0.5}, {At: 25 * time.Second, Score: 0.9}, {At: 40 * time.Second, Score: 0.6}},
+			time.Minute,
+			at(0, 25, 40),
+		},
+		{
+			"too close to the start or end",
+			[]media.SceneChange{{At: 5 * time.Second, Score: 0.9}, {At: 55 * time.Second, Score: 0.9}},
+			time.Minute,
+			at(0),
+		},
+		{
+			"at most MaxChapters",
+			[]media.SceneChange{{At: 15 * time.Second, Score: 0.5}, {At: 30 * time.Second, Score: 0.6}, {At: 45 * time.Second, Score: 0.7}},
+			time.Minute,
+			at(0, 30, 45),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []time.Duration{}
+			for _, start := range options.chapterStarts(tt.changes, tt.duration) {
+				got = append(got, start.At)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("chapterStarts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	emailVerificationTTL = time.Hour * 48
+	passwordResetTTL     = time.Hour
+)
+
+// createUserToken stores the digest of a new single-use token and returns
+// the token itself, which only ever leaves the server by email.
+func (cfg *apiConfig) createUserToken(userID uuid.UUID, purpose database.UserTokenPurpose, ttl time.Duration) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.CreateUserToken(database.CreateUserTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (cfg *apiConfig) appLink(query url.Values) string {
+	return fmt.Sprintf("%s/app/?%s", cfg.appURL, query.Encode())
+}
+
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.createUserToken(user.ID, database.UserTokenPurposeVerifyEmail, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Tubely email address",
+		Body: fmt.Sprintf(
+			"Welcome to Tubely!\n\nConfirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			cfg.appLink(url.Values{"verify_email": {token}}),
+			emailVerificationTTL,
+		),
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, user database.User) error {
+	token, err := cfg.createUserToken(user.ID, database.UserTokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Tubely password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Tubely account.\n\nChoose a new password here:\n\n%s\n\nThe link expires in %s. If this wasn't you, you can ignore this email.\n",
+			cfg.appLink(url.Values{"reset_password": {token}}),
+			passwordResetTTL,
+		),
+	})
+}
+
+func (cfg *apiConfig) sendOrganizationInvitationEmail(ctx context.Context, invitation database.OrganizationInvitation, org database.Organization) error {
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You've been invited to %s on Tubely", org.Name),
+		Body: fmt.Sprintf(
+			"You've been invited to join %s on Tubely as %s.\n\nAccept the invitation here:\n\n%s\n\nThe invitation expires on %s.\n",
+			org.Name,
+			invitation.Role,
+			cfg.appLink(url.Values{"invitation": {invitation.Token}}),
+			invitation.ExpiresAt.Format(time.RFC1123),
+		),
+	})
+}
+
+// sendEmailInBackground sends mail without holding up the response. Failures
+// are logged; the user can always ask for another email.
+func sendEmailInBackground(description string, send func(ctx context.Context) error) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := send(ctx); err != nil {
+			log.Printf("Couldn't send %s email: %v", description, err)
+		}
+	}()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+const testBucket = "tubely-test"
+
+// fakeS3 is an in-memory bucket serving the parts of the S3 API the
+// handlers use, with path-style URLs. Signatures aren't checked.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		http.Error(w, "unknown bucket", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			s.copyObject(w, key, source)
+			return
+		}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = content
+		w.Header().Set("ETag", etag(content))
+	case http.MethodGet, http.MethodHead:
+		content, ok := s.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			}
+			return
+		}
+		// ServeContent answers Range and If-Match against the ETag.
+		w.Header().Set("ETag", etag(content))
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(content))
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+// copyObject answers CopyObject, whose source is "bucket/key".
+func (s *fakeS3) copyObject(w http.ResponseWriter, key, source string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	content, ok := s.objects[strings.TrimPrefix(source, testBucket+"/")]
+	if !ok {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		return
+	}
+	s.objects[key] = content
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", html.EscapeString(etag(content)))
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// object returns the content stored under key and whether there is any.
+func (s *fakeS3) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.objects[key]
+	return content, ok
+}
+
+func (s *fakeS3) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+// newMediaTestConfig returns a test config that stores video files in a
+// fake bucket and processes them with media.Fake, which reports every file
+// as a ten second 1080p MP4.
+func newMediaTestConfig(t *testing.T) (*apiConfig, *fakeS3, *media.Fake) {
+	t.Helper()
+	cfg := newTestConfig(t)
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(bucket)
+	t.Cleanup(server.Close)
+	cfg.s3Bucket = testBucket
+	cfg.s3Region = "us-east-1"
+	cfg.s3CfDistribution = "https://cdn.example.com"
+	cfg.s3Client = s3.New(s3.Options{
+		Region:       cfg.s3Region,
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+
+	fake := &media.Fake{Result: media.FakeVideo(1920, 1080, 10)}
+	cfg.media = fake
+	cfg.previews = defaultPreviewOptions()
+	cfg.audioRenditions = defaultAudioRenditions
+	cfg.chapters = defaultChapterOptions()
+	return cfg, bucket, fake
+}
+
+// testVideo returns a file that is sniffed as an MP4. Different bodies give
+// files with different digests.
+func testVideo(body string) []byte {
+	header := []byte{0, 0, 0, 16, 'f', 't', 'y', 'p', 'i', 's', 'o', 'm', 0, 0, 2, 0}
+	return append(header, body...)
+}
+
+// called reports whether the fake was asked to run op.
+func called(fake *media.Fake, op string) bool {
+	for _, call := range fake.Calls {
+		if call.Op == op {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// soleOwnedOrganization returns an organization the user is the only owner of
+// while other members remain, since deleting the account would orphan it.
+func (cfg *apiConfig) soleOwnedOrganization(userID uuid.UUID) (*database.Organization, error) {
+	orgs, err := cfg.db.GetUserOrganizations(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		if org.Role != database.OrgRoleOwner {
+			continue
+		}
+		owners, err := cfg.db.CountOrganizationOwners(org.ID)
+		if err != nil {
+			return nil, err
+		}
+		if owners > 1 {
+			continue
+		}
+		members, err := cfg.db.GetOrganizationMembers(org.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) > 1 {
+			return &org.Organization, nil
+		}
+	}
+	return nil, nil
+}
+
+func (cfg *apiConfig) handlerUsersDelete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	err = auth.CheckPasswordHash(params.Password, user.Password)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect password", err)
+		return
+	}
+
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.EnabledAt != nil {
+		ok, err := cfg.verifySecondFactor(totp, params.Code, params.RecoveryCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't verify code", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, "Invalid code", nil)
+			return
+		}
+	}
+
+	org, err := cfg.soleOwnedOrganization(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organizations", err)
+		return
+	}
+	if org != nil {
+		msg := fmt.Sprintf("Transfer ownership of %q or remove its other members before deleting your account", org.Name)
+		respondWithError(w, http.StatusConflict, msg, nil)
+		return
+	}
+
+	// Organization videos are handed to another owner rather than deleted,
+	// so only the videos DeleteUser doesn't return have their files released.
+	videos, err := cfg.db.GetVideosUploadedBy(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	records := make([]videoRecords, len(videos))
+	for i, video := range videos {
+		records[i], err = cfg.getVideoRecords(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video records", err)
+			return
+		}
+	}
+	watermark, err := cfg.db.GetWatermark(database.WatermarkScopeUser, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	reassigned, err := cfg.db.DeleteUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete user", err)
+		return
+	}
+
+	newOwners := make(map[uuid.UUID]uuid.UUID, len(reassigned))
+	for _, video := range reassigned {
+		newOwners[video.ID] = video.UserID
+	}
+	for i, video := range videos {
+		if ownerID, ok := newOwners[video.ID]; ok {
+			size, err := cfg.videoStorageSize(video, records[i])
+			if err != nil {
+				log.Printf("Couldn't charge new owner of video %s: %v", video.ID, err)
+				continue
+			}
+			cfg.chargeStorage(ownerID, size)
+			continue
+		}
+		if err := cfg.releaseVideoAssets(r.Context(), video, records[i]); err != nil {
+			log.Printf("Couldn't release files of video %s: %v", video.ID, err)
+		}
+	}
+	if watermark.ImageURL != "" {
+		cfg.releaseWatermarkImage(watermark)
+	}
+
+	err = cfg.db.ClearLoginFailures(database.LoginFailureScopeAccount, loginAccountKey(user.Email))
+	if err != nil {
+		log.Printf("Couldn't clear failed logins: %v", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type exportUser struct {
+	ID               uuid.UUID               `json:"id"`
+	Email            string                  `json:"email"`
+	CreatedAt        time.Time               `json:"created_at"`
+	UpdatedAt        time.Time               `json:"updated_at"`
+	EmailVerifiedAt  *time.Time              `json:"email_verified_at"`
+	TwoFactorEnabled bool                    `json:"two_factor_enabled"`
+	Identities       []database.UserIdentity `json:"identities"`
+}
+
+type exportAsset struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Kind    string    `json:"kind"`
+	URL     string    `json:"url"`
+}
+
+type exportManifest struct {
+	GeneratedAt time.Time     `json:"generated_at"`
+	Files       []string      `json:"files"`
+	Assets      []exportAsset `json:"assets"`
+}
+
+// handlerUsersExport streams a zip archive with everything stored about the
+// user. Video files are not copied; the manifest lists where to fetch them.
+func (cfg *apiConfig) handlerUsersExport(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	identities, err := cfg.db.GetUserIdentities(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get linked identities", err)
+		return
+	}
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	videos, err := cfg.db.GetVideosUploadedBy(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	orgs, err := cfg.db.GetUserOrganizations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organizations", err)
+		return
+	}
+
+	manifest := exportManifest{
+		GeneratedAt: time.Now().UTC(),
+		Files:       []string{"user.json", "videos.json", "organizations.json", "chapters.json"},
+		Assets:      []exportAsset{},
+	}
+	exportedChapters := map[uuid.UUID][]database.VideoChapter{}
+	for _, video := range videos {
+		if video.ThumbnailURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "thumbnail", URL: *video.ThumbnailURL})
+		}
+		if video.VideoURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "video", URL: *video.VideoURL})
+		}
+		if video.OriginalVideoURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "original_video", URL: *video.OriginalVideoURL})
+		}
+		if video.PreviewURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "preview", URL: *video.PreviewURL})
+		}
+		if video.PreviewVideoURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "preview_video", URL: *video.PreviewVideoURL})
+		}
+		if video.NormalizedVideoURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "normalized_video", URL: *video.NormalizedVideoURL})
+		}
+		if video.AudioAACURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "audio_aac", URL: *video.AudioAACURL})
+		}
+		if video.AudioOpusURL != nil {
+			manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "audio_opus", URL: *video.AudioOpusURL})
+		}
+
+		versions, err := cfg.db.GetVideoVersions(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
+			return
+		}
+		for _, version := range versions {
+			if version.Version != video.Version {
+				manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "video_version", URL: version.VideoURL})
+			}
+		}
+
+		chapters, err := cfg.db.GetVideoChapters(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+			return
+		}
+		for _, chapter := range chapters {
+			if chapter.ThumbnailURL != nil {
+				manifest.Assets = append(manifest.Assets, exportAsset{VideoID: video.ID, Kind: "chapter_thumbnail", URL: *chapter.ThumbnailURL})
+			}
+		}
+		exportedChapters[video.ID] = chapters
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"user.json", exportUser{
+			ID:               user.ID,
+			Email:            user.Email,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+			EmailVerifiedAt:  user.EmailVerifiedAt,
+			TwoFactorEnabled: totp.EnabledAt != nil,
+			Identities:       identities,
+		}},
+		{"videos.json", videos},
+		{"organizations.json", orgs},
+		{"chapters.json", exportedChapters},
+		{"manifest.json", manifest},
+	}
+
+	filename := fmt.Sprintf("tubely-export-%s.zip", manifest.GeneratedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so failures past this point can only be logged.
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Deflate,
+			Modified: manifest.GeneratedAt,
+		})
+		if err != nil {
+			log.Printf("Couldn't write %s to export: %v", file.name, err)
+			return
+		}
+		encoder := json.NewEncoder(entry)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			log.Printf("Couldn't write %s to export: %v", file.name, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Couldn't finish export: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestDeleteUserKeepsOrganizationVideos(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, _ := createTestUser(t, cfg, "owner@example.com")
+	member, memberToken := createTestUser(t, cfg, "member@example.com")
+
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.AddOrganizationMember(org.ID, member.ID, database.OrgRoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	orgVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: member.ID, OrgID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, err := cfg.db.AddAssetReference(database.CreateAssetParams{
+		Storage:   database.AssetStorageLocal,
+		Key:       "shared.png",
+		Digest:    "shared",
+		MediaType: "image/png",
+		Size:      100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnailURL := cfg.getAssetURL(thumbnail.Key)
+	orgVideo.ThumbnailURL = &thumbnailURL
+	if err := cfg.db.UpdateVideo(orgVideo); err != nil {
+		t.Fatal(err)
+	}
+	personalVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "mine", UserID: member.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUsersDelete(rec, newJSONRequest(t, http.MethodDelete, "/api/users/me", memberToken, map[string]string{
+		"password": "password",
+	}))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+
+	kept, err := cfg.db.GetVideo(orgVideo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.ID == uuid.Nil {
+		t.Fatal("organization video was deleted with its uploader")
+	}
+	if kept.UserID != owner.ID || kept.OrgID == nil || *kept.OrgID != org.ID {
+		t.Errorf("organization video belongs to user %s in org %v, want the owner in %s", kept.UserID, kept.OrgID, org.ID)
+	}
+	if size, err := cfg.db.GetAssetSize(database.AssetStorageLocal, thumbnail.Key); err != nil || size != thumbnail.Size {
+		t.Errorf("thumbnail asset size = %d, %v; want it kept", size, err)
+	}
+	if used, err := cfg.db.GetUserStorage(owner.ID); err != nil || used != thumbnail.Size {
+		t.Errorf("owner storage = %d, %v; want %d", used, err, thumbnail.Size)
+	}
+
+	deleted, err := cfg.db.GetVideo(personalVideo.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.ID != uuid.Nil {
+		t.Error("personal video was not deleted")
+	}
+}
+
+func TestExportIncludesOrganizationUploads(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, _ := createTestUser(t, cfg, "owner@example.com")
+	member, memberToken := createTestUser(t, cfg, "member@example.com")
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.AddOrganizationMember(org.ID, member.ID, database.OrgRoleMember); err != nil {
+		t.Fatal(err)
+	}
+	orgVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: member.ID, OrgID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := "https://cdn.example.com/landscape/shared.mp4"
+	orgVideo.VideoURL = &videoURL
+	if err := cfg.db.UpdateVideo(orgVideo); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUsersExport(rec, newJSONRequest(t, http.MethodGet, "/api/users/me/export", memberToken, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(name string, v any) {
+		t.Helper()
+		file, err := archive.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := json.NewDecoder(file).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var videos []database.Video
+	readFile("videos.json", &videos)
+	if len(videos) != 1 || videos[0].ID != orgVideo.ID {
+		t.Errorf("exported videos = %v, want the organization upload", videos)
+	}
+	var manifest exportManifest
+	readFile("manifest.json", &manifest)
+	want := exportAsset{VideoID: orgVideo.ID, Kind: "video", URL: videoURL}
+	if len(manifest.Assets) != 1 || manifest.Assets[0] != want {
+		t.Errorf("manifest assets = %v, want %v", manifest.Assets, want)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// requireAdmin checks the ApiKey authorization header against ADMIN_API_KEY.
+// It writes the error response and returns false if the caller is not an
+// admin.
+func (cfg *apiConfig) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if cfg.adminAPIKey == "" {
+		respondWithError(w, http.StatusForbidden, "Admin API is disabled", nil)
+		return false
+	}
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find API key", err)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+		respondWithError(w, http.StatusUnauthorized, "Invalid API key", nil)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerAdminUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" && params.IP == "" {
+		respondWithError(w, http.StatusBadRequest, "Email or IP is required", nil)
+		return
+	}
+
+	if params.Email != "" {
+		err = cfg.db.ClearLoginFailures(database.LoginFailureScopeAccount, loginAccountKey(params.Email))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unlock account", err)
+			return
+		}
+	}
+	if params.IP != "" {
+		err = cfg.db.ClearLoginFailures(database.LoginFailureScopeIP, params.IP)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unlock IP", err)
+			return
+		}
+	}
+
+	err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+		EventType: database.AuditEventLoginUnlock,
+		IP:        clientIP(r),
+		Details:   fmt.Sprintf("admin unlocked email %q, ip %q", params.Email, params.IP),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record audit event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	events, err := cfg.db.GetAuditEvents(100)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve audit events", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func (cfg *apiConfig) handlerAdminMediaStats(w http.ResponseWriter, r *http.Request) {
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.mediaExecutor.Stats())
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerBatchUploadCreate takes a multipart form with a manifest and the
+// files it names, either as "files" parts or in a zip "archive" part that
+// may also hold the manifest. A draft is created for every valid item and
+// its file queued for processing; the batch reports how each item fares.
+func (cfg *apiConfig) handlerBatchUploadCreate(w http.ResponseWriter, r *http.Request) {
+	const maxManifestSize = 1 << 20
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Batch videos are owned, and charged to, the user uploading them.
+	policy, used, err := cfg.userQuota(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+	batchLimit := policy.uploadLimit() * maxBatchItems
+	if policy.MaxStorageBytes > 0 {
+		batchLimit = min(batchLimit, max(policy.MaxStorageBytes-used, 0))
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, batchLimit+multipartOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart form", err)
+		return
+	}
+	files := batchFiles{}
+	defer files.remove()
+	var manifest []byte
+	var archivePath string
+	var orgID *uuid.UUID
+	defer func() {
+		if archivePath != "" {
+			os.Remove(archivePath)
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			switch part.FormName() {
+			case "manifest":
+				manifest, err = io.ReadAll(io.LimitReader(part, maxManifestSize+1))
+				if err == nil && len(manifest) > maxManifestSize {
+					err = errors.New("manifest is too large")
+				}
+			case "org_id":
+				// Membership is checked before any file is stored, so the
+				// field has to come first.
+				if len(files) > 0 || archivePath != "" {
+					err = errors.New("org_id must come before the files")
+					break
+				}
+				var value []byte
+				value, err = io.ReadAll(io.LimitReader(part, 64))
+				if err != nil {
+					break
+				}
+				orgIDString := strings.TrimSpace(string(value))
+				if orgIDString == "" {
+					break
+				}
+				parsed, err := uuid.Parse(orgIDString)
+				if err != nil {
+					respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+					return
+				}
+				role, err := cfg.db.GetOrganizationRole(parsed, userID)
+				if err != nil {
+					respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+					return
+				}
+				if !role.CanEditVideos() {
+					respondWithError(w, http.StatusForbidden, "You can't create videos in this organization", nil)
+					return
+				}
+				orgID = &parsed
+			case "files":
+				err = files.receive(cfg.batchUploadsDir, part.FileName(), part, policy.uploadLimit())
+			case "archive":
+				if archivePath != "" {
+					err = errors.New("only one archive can be uploaded")
+					break
+				}
+				archivePath, err = receiveArchive(part)
+			}
+			part.Close()
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Couldn't read form", err)
+			return
+		}
+	}
+
+	if manifest == nil && archivePath != "" {
+		manifest, err = archiveManifest(archivePath)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if manifest == nil {
+		respondWithError(w, http.StatusBadRequest, "Manifest is required", nil)
+		return
+	}
+	manifestItems, err := parseBatchManifest(manifest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if len(manifestItems) == 0 || len(manifestItems) > maxBatchItems {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Manifest must list 1 to %d videos", maxBatchItems), nil)
+		return
+	}
+	if archivePath != "" {
+		names := map[string]bool{}
+		for _, item := range manifestItems {
+			names[path.Clean(item.Filename)] = true
+		}
+		if err := files.extract(cfg.batchUploadsDir, archivePath, names, policy.uploadLimit(), batchLimit); err != nil {
+			if isQuotaError(err) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	items := make([]database.BatchUploadItem, len(manifestItems))
+	drafts := []uuid.UUID{}
+	claimed := map[string]bool{}
+	for i, manifestItem := range manifestItems {
+		filename := manifestItem.Filename
+		if archivePath != "" {
+			filename = path.Clean(filename)
+		}
+		title := strings.TrimSpace(manifestItem.Title)
+		if title == "" {
+			title = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+		}
+		items[i] = database.BatchUploadItem{
+			Position: i,
+			Filename: manifestItem.Filename,
+			Title:    title,
+			Status:   database.BatchItemFailed,
+		}
+		file := files[filename]
+		var failure string
+		switch {
+		case manifestItem.Filename == "":
+			failure = "Filename is required"
+		case claimed[filename]:
+			failure = "File is already used by another item"
+		case file == nil:
+			failure = "File is missing"
+		case file.tooLarge:
+			failure = fmt.Sprintf("Upload is larger than the %d byte limit", policy.uploadLimit())
+		}
+		if failure != "" {
+			items[i].Error = &failure
+			continue
+		}
+
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+			Title:       title,
+			Description: manifestItem.Description,
+			UserID:      userID,
+			OrgID:       orgID,
+		})
+		if err != nil {
+			failure = "Couldn't create video"
+			items[i].Error = &failure
+			continue
+		}
+		claimed[filename] = true
+		drafts = append(drafts, video.ID)
+		items[i].VideoID = &video.ID
+		items[i].Status = database.BatchItemQueued
+		items[i].FilePath = &file.path
+	}
+
+	batch, err := cfg.db.CreateBatchUpload(userID, orgID, items)
+	if err != nil {
+		for _, videoID := range drafts {
+			if err := cfg.db.DeleteVideo(videoID); err != nil {
+				log.Printf("Couldn't delete draft %s of failed batch: %v", videoID, err)
+			}
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create batch", err)
+		return
+	}
+	// The files of queued items now belong to the batch workers.
+	for filename := range claimed {
+		delete(files, filename)
+	}
+	cfg.notifyBatchWorkers()
+
+	respondWithJSON(w, http.StatusAccepted, batch)
+}
+
+// receiveArchive copies the zip archive of a batch upload to disk.
+func receiveArchive(r io.Reader) (string, error) {
+	tempFile, err := os.CreateTemp("", "tubely-batch-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, r); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+func (cfg *apiConfig) handlerBatchUploadGet(w http.ResponseWriter, r *http.Request) {
+	batchID, err := uuid.Parse(r.PathValue("batchID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	batch, err := cfg.db.GetBatchUpload(batchID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get batch", err)
+		return
+	}
+	if batch.ID == uuid.Nil || batch.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Batch not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, batch)
+}
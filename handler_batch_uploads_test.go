@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// batchPart is one part of a batch upload form. Parts with a filename are
+// sent as files.
+type batchPart struct {
+	name, filename string
+	content        []byte
+}
+
+func newBatchRequest(t *testing.T, token string, parts ...batchPart) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, part := range parts {
+		if part.filename == "" {
+			if err := form.WriteField(part.name, string(part.content)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		w, err := form.CreateFormFile(part.name, part.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(part.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/batches", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// zipArchive returns a zip archive holding the given files.
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBatchUploadChecksOrganizationFirst(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, _ := createTestUser(t, cfg, "owner@example.com")
+	_, outsiderToken := createTestUser(t, cfg, "outsider@example.com")
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := batchPart{name: "manifest", content: []byte(`[{"title": "a", "filename": "a.mp4"}]`)}
+	file := batchPart{name: "files", filename: "a.mp4", content: []byte("video")}
+	orgField := batchPart{name: "org_id", content: []byte(org.ID.String())}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerBatchUploadCreate(rec, newBatchRequest(t, outsiderToken, manifest, orgField, file))
+	decodeResponse(t, rec, http.StatusForbidden, nil)
+
+	rec = httptest.NewRecorder()
+	cfg.handlerBatchUploadCreate(rec, newBatchRequest(t, outsiderToken, manifest, file, orgField))
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+}
+
+func TestBatchUploadLimitsArchiveExpansion(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	cfg.quotas = quotaPolicies{defaultUserRole: {MaxStorageBytes: 1000, MaxUploadBytes: 800}}
+
+	// Each entry is within the upload limit and compresses to a few bytes,
+	// but together they expand past the remaining quota.
+	archive := zipArchive(t, map[string][]byte{
+		"manifest.json": []byte(`[{"filename": "a.mp4"}, {"filename": "b.mp4"}]`),
+		"a.mp4":         make([]byte, 600),
+		"b.mp4":         make([]byte, 600),
+	})
+	rec := httptest.NewRecorder()
+	cfg.handlerBatchUploadCreate(rec, newBatchRequest(t, token, batchPart{name: "archive", filename: "batch.zip", content: archive}))
+	decodeResponse(t, rec, http.StatusRequestEntityTooLarge, nil)
+
+	videos, err := cfg.db.GetVideos(user.ID)
+	if err != nil || len(videos) > 0 {
+		t.Errorf("videos = %v, %v; want none", videos, err)
+	}
+}
+
+// startBatchWorkers runs batch workers until the test ends.
+func startBatchWorkers(t *testing.T, cfg *apiConfig, workers int) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for range workers {
+		go cfg.runBatchWorker(ctx)
+	}
+}
+
+// waitForBatch returns the batch once none of its items are queued or
+// being processed.
+func waitForBatch(t *testing.T, cfg *apiConfig, batch database.BatchUpload) database.BatchUpload {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !batchFinished(batch) {
+		if time.Now().After(deadline) {
+			t.Fatalf("batch items = %+v, want them processed", batch.Items)
+		}
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if batch, err = cfg.db.GetBatchUpload(batch.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return batch
+}
+
+func newBatchTestConfig(t *testing.T) (*apiConfig, *fakeS3) {
+	t.Helper()
+	cfg, bucket, _ := newMediaTestConfig(t)
+	cfg.batchUploadsDir = t.TempDir()
+	cfg.batchQueued = make(chan struct{}, 1)
+	return cfg, bucket
+}
+
+func TestBatchUploadProcessesFiles(t *testing.T) {
+	cfg, bucket := newBatchTestConfig(t)
+	_, token := createTestUser(t, cfg, "uploader@example.com")
+	startBatchWorkers(t, cfg, 2)
+
+	manifest := batchPart{name: "manifest", content: []byte(`[
+		{"title": "first", "filename": "a.mp4"},
+		{"title": "second", "filename": "b.mp4"},
+		{"title": "broken", "filename": "c.mp4"}
+	]`)}
+	var batch database.BatchUpload
+	rec := httptest.NewRecorder()
+	cfg.handlerBatchUploadCreate(rec, newBatchRequest(t, token, manifest,
+		batchPart{name: "files", filename: "a.mp4", content: testVideo("first")},
+		batchPart{name: "files", filename: "b.mp4", content: testVideo("second")},
+		batchPart{name: "files", filename: "c.mp4", content: []byte("not a video")},
+	))
+	decodeResponse(t, rec, http.StatusAccepted, &batch)
+	batch = waitForBatch(t, cfg, batch)
+
+	for i, content := range [][]byte{testVideo("first"), testVideo("second")} {
+		item := batch.Items[i]
+		if item.Status != database.BatchItemSucceeded {
+			t.Fatalf("item %d status = %s, %v", i, item.Status, item.Error)
+		}
+		video, err := cfg.db.GetVideo(*item.VideoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each item is processed by one worker only.
+		if video.Version != 1 {
+			t.Errorf("item %d is at version %d, want 1", i, video.Version)
+		}
+		checkStoredVideo(t, cfg, bucket, video, content)
+	}
+	if broken := batch.Items[2]; broken.Status != database.BatchItemFailed || broken.Error == nil {
+		t.Errorf("item 2 status = %s, %v; want it failed", broken.Status, broken.Error)
+	}
+	checkBatchFilesRemoved(t, cfg)
+}
+
+func TestBatchUploadResumesAfterRestart(t *testing.T) {
+	cfg, bucket := newBatchTestConfig(t)
+	_, token := createTestUser(t, cfg, "uploader@example.com")
+
+	// No worker runs, as if the server stopped right after the upload.
+	manifest := batchPart{name: "manifest", content: []byte(`[
+		{"title": "first", "filename": "a.mp4"},
+		{"title": "second", "filename": "b.mp4"}
+	]`)}
+	var batch database.BatchUpload
+	rec := httptest.NewRecorder()
+	cfg.handlerBatchUploadCreate(rec, newBatchRequest(t, token, manifest,
+		batchPart{name: "files", filename: "a.mp4", content: testVideo("first")},
+		batchPart{name: "files", filename: "b.mp4", content: testVideo("second")},
+	))
+	decodeResponse(t, rec, http.StatusAccepted, &batch)
+	// A worker stopped while processing the first item.
+	if _, found, err := cfg.db.ClaimBatchItem(); err != nil || !found {
+		t.Fatalf("ClaimBatchItem = %v, %v; want an item", found, err)
+	}
+
+	db, err := database.NewClient(filepath.Join(filepath.Dir(cfg.assetsRoot), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.db = db
+	if requeued, err := cfg.db.RequeueProcessingBatchItems(); err != nil || requeued != 1 {
+		t.Fatalf("RequeueProcessingBatchItems = %d, %v; want 1", requeued, err)
+	}
+	startBatchWorkers(t, cfg, 1)
+	batch = waitForBatch(t, cfg, batch)
+
+	for i, content := range [][]byte{testVideo("first"), testVideo("second")} {
+		item := batch.Items[i]
+		if item.Status != database.BatchItemSucceeded {
+			t.Fatalf("item %d status = %s, %v", i, item.Status, item.Error)
+		}
+		video, err := cfg.db.GetVideo(*item.VideoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkStoredVideo(t, cfg, bucket, video, content)
+	}
+	checkBatchFilesRemoved(t, cfg)
+}
+
+// checkBatchFilesRemoved checks that no batch file is left waiting.
+func checkBatchFilesRemoved(t *testing.T, cfg *apiConfig) {
+	t.Helper()
+	entries, err := os.ReadDir(cfg.batchUploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("batch uploads directory holds %d files, want none", len(entries))
+	}
+}
+
+func batchFinished(batch database.BatchUpload) bool {
+	for _, item := range batch.Items {
+		if item.Status == database.BatchItemQueued || item.Status == database.BatchItemProcessing {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// directUploadExpiry is how long a client has to start a direct upload.
+	directUploadExpiry = 15 * time.Minute
+	// directUploadRetention is how long after expiring an upload can still
+	// be completed before its object is deleted.
+	directUploadRetention = 24 * time.Hour
+	// maxPendingUploads is how many direct uploads a user may have started
+	// and not completed, since their objects sit in the bucket until then.
+	maxPendingUploads = 10
+)
+
+// handlerDirectUploadCreate lets the client upload a video file straight to
+// the bucket, with a presigned PUT of a declared size or a presigned POST
+// form that S3 limits to the owner's upload limit. Either way the content
+// type is fixed. The file is processed when the client calls
+// handlerDirectUploadComplete.
+func (cfg *apiConfig) handlerDirectUploadCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Method      string `json:"method"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	type response struct {
+		UploadID  uuid.UUID         `json:"upload_id"`
+		Method    string            `json:"method"`
+		URL       string            `json:"url"`
+		Headers   map[string]string `json:"headers,omitempty"`
+		Fields    map[string]string `json:"fields,omitempty"`
+		MaxSize   int64             `json:"max_size"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{Method: http.MethodPut}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Method != http.MethodPut && params.Method != http.MethodPost {
+		respondWithError(w, http.StatusBadRequest, "Method must be PUT or POST", nil)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(params.ContentType)
+	if err != nil || !acceptedUploadTypes[mediaType] {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid Content-Type, only MP4, MOV, MKV and WebM are allowed", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	pending, err := cfg.db.CountPendingUploads(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count pending uploads", err)
+		return
+	}
+	if pending >= maxPendingUploads {
+		respondWithError(w, http.StatusTooManyRequests, "Too many uploads in progress, complete them first", nil)
+		return
+	}
+
+	policy, used, err := cfg.userQuota(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+	// Files still being uploaded count as stored, up to what may be
+	// uploaded for them.
+	reserved, err := cfg.db.GetPendingUploadBytes(video.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+	used += reserved
+	maxSize := policy.uploadLimit()
+	if params.Method == http.MethodPut {
+		if params.Size <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Size is required for PUT uploads", nil)
+			return
+		}
+		maxSize = params.Size
+	} else if policy.MaxStorageBytes > 0 {
+		maxSize = min(maxSize, policy.MaxStorageBytes-used)
+	}
+	if err := policy.checkUpload(used, max(maxSize, 1)); err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+		return
+	}
+
+	uploadID := uuid.New()
+	key := "uploads/" + uploadID.String()
+	presigner := s3.NewPresignClient(cfg.s3Client)
+	resp := response{
+		UploadID:  uploadID,
+		Method:    params.Method,
+		MaxSize:   maxSize,
+		ExpiresAt: time.Now().UTC().Add(directUploadExpiry),
+	}
+	if params.Method == http.MethodPut {
+		presigned, err := presigner.PresignPutObject(r.Context(), &s3.PutObjectInput{
+			Bucket:        &cfg.s3Bucket,
+			Key:           &key,
+			ContentType:   &mediaType,
+			ContentLength: &maxSize,
+		}, s3.WithPresignExpires(directUploadExpiry))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+		resp.URL = presigned.URL
+		resp.Headers = map[string]string{}
+		for name := range presigned.SignedHeader {
+			if name != "Host" {
+				resp.Headers[name] = presigned.SignedHeader.Get(name)
+			}
+		}
+	} else {
+		presigned, err := presigner.PresignPostObject(r.Context(), &s3.PutObjectInput{
+			Bucket: &cfg.s3Bucket,
+			Key:    &key,
+		}, func(opts *s3.PresignPostOptions) {
+			opts.Expires = directUploadExpiry
+			opts.Conditions = []interface{}{
+				[]interface{}{"content-length-range", 1, maxSize},
+				[]interface{}{"eq", "$Content-Type", mediaType},
+			}
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+			return
+		}
+		resp.URL = presigned.URL
+		resp.Fields = presigned.Values
+		resp.Fields["Content-Type"] = mediaType
+	}
+
+	_, err = cfg.db.CreatePendingUpload(database.PendingUpload{
+		ID:          uploadID,
+		VideoID:     videoID,
+		UserID:      userID,
+		ObjectKey:   key,
+		ContentType: mediaType,
+		MaxSize:     maxSize,
+		ExpiresAt:   resp.ExpiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+// handlerDirectUploadComplete processes a file the client uploaded to the
+// bucket like any other upload. The upload is claimed first, so concurrent
+// calls can't both process it. The uploaded object is deleted afterwards,
+// unless processing failed in a way that may go away on a retry; then the
+// upload is restored so it can be completed again.
+func (cfg *apiConfig) handlerDirectUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UploadID uuid.UUID `json:"upload_id"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	upload, err := cfg.db.GetPendingUpload(params.UploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil || upload.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+
+	head, err := cfg.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &upload.ObjectKey,
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			respondWithError(w, http.StatusConflict, "The file hasn't been uploaded", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded file", err)
+		return
+	}
+	if head.ContentLength == nil || *head.ContentLength > upload.MaxSize {
+		cfg.discardPendingUpload(r.Context(), upload)
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is larger than allowed", nil)
+		return
+	}
+
+	claimed, err := cfg.db.ClaimPendingUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't claim upload", err)
+		return
+	}
+	if claimed.ID == uuid.Nil {
+		respondWithError(w, http.StatusConflict, "Upload is already being completed", nil)
+		return
+	}
+
+	video, err = cfg.completeDirectUpload(r.Context(), video, userID, claimed)
+	if err != nil {
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) && ingestErr.status < http.StatusInternalServerError {
+			cfg.discardClaimedUpload(r.Context(), claimed)
+		} else if _, restoreErr := cfg.db.CreatePendingUpload(claimed); restoreErr != nil {
+			log.Printf("Couldn't restore pending upload %s: %v", claimed.ID, restoreErr)
+		}
+		respondWithIngestError(w, err)
+		return
+	}
+	cfg.discardClaimedUpload(r.Context(), claimed)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// completeDirectUpload downloads the uploaded object and ingests it.
+func (cfg *apiConfig) completeDirectUpload(ctx context.Context, video database.Video, userID uuid.UUID, upload database.PendingUpload) (database.Video, error) {
+	uploadPath, err := cfg.downloadS3Object(ctx, upload.ObjectKey)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't read uploaded file", err}
+	}
+	defer os.Remove(uploadPath)
+	uploadFile, err := os.Open(uploadPath)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't read uploaded file", err}
+	}
+	defer uploadFile.Close()
+	hasher, err := hashFile(uploadPath)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't read uploaded file", err}
+	}
+	return cfg.ingestVideo(ctx, video, userID, uploadFile, hasher)
+}
+
+// discardClaimedUpload deletes the object of an upload taken with
+// ClaimPendingUpload. If that fails the upload is restored, so that
+// runPendingUploadCleanup retries once it expires.
+func (cfg *apiConfig) discardClaimedUpload(ctx context.Context, upload database.PendingUpload) {
+	_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &upload.ObjectKey,
+	})
+	if err == nil {
+		return
+	}
+	log.Printf("Couldn't delete uploaded file %s: %v", upload.ObjectKey, err)
+	if _, err := cfg.db.CreatePendingUpload(upload); err != nil {
+		log.Printf("Couldn't restore pending upload %s: %v", upload.ID, err)
+	}
+}
+
+// discardPendingUpload deletes the upload and its object, logging
+// failures. Expired uploads that fail to be deleted are retried by
+// runPendingUploadCleanup.
+func (cfg *apiConfig) discardPendingUpload(ctx context.Context, upload database.PendingUpload) {
+	_, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &upload.ObjectKey,
+	})
+	if err != nil {
+		log.Printf("Couldn't delete uploaded file %s: %v", upload.ObjectKey, err)
+		return
+	}
+	if err := cfg.db.DeletePendingUpload(upload.ID); err != nil {
+		log.Printf("Couldn't delete pending upload %s: %v", upload.ID, err)
+	}
+}
+
+// runPendingUploadCleanup regularly deletes uploads that were never
+// completed, along with whatever was uploaded for them.
+func (cfg *apiConfig) runPendingUploadCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			uploads, err := cfg.db.GetPendingUploadsExpiredBefore(time.Now().Add(-directUploadRetention))
+			if err != nil {
+				log.Printf("Couldn't get expired uploads: %v", err)
+				continue
+			}
+			for _, upload := range uploads {
+				cfg.discardPendingUpload(ctx, upload)
+			}
+		}
+	}
+}
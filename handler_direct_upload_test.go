@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type directUpload struct {
+	UploadID uuid.UUID         `json:"upload_id"`
+	URL      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	MaxSize  int64             `json:"max_size"`
+}
+
+func createDirectUpload(t *testing.T, cfg *apiConfig, video database.Video, token string, size int64) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodPost, "/api/videos/"+video.ID.String()+"/upload_url", token, map[string]any{
+		"method":       http.MethodPut,
+		"content_type": "video/mp4",
+		"size":         size,
+	})
+	req.SetPathValue("videoID", video.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerDirectUploadCreate(rec, req)
+	return rec
+}
+
+func completeDirectUpload(t *testing.T, cfg *apiConfig, video database.Video, token string, uploadID uuid.UUID) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodPost, "/api/videos/"+video.ID.String()+"/upload_complete", token, map[string]any{
+		"upload_id": uploadID,
+	})
+	req.SetPathValue("videoID", video.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerDirectUploadComplete(rec, req)
+	return rec
+}
+
+// putPresigned uploads content the way a browser would with the presigned
+// URL and headers.
+func putPresigned(t *testing.T, upload directUpload, content []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, upload.URL, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range upload.Headers {
+		if name != "Content-Length" {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("presigned PUT status = %d", resp.StatusCode)
+	}
+}
+
+func TestDirectUpload(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := testVideo("direct")
+
+	var upload directUpload
+	decodeResponse(t, createDirectUpload(t, cfg, video, token, int64(len(content))), http.StatusCreated, &upload)
+	if upload.MaxSize != int64(len(content)) || upload.Headers["Content-Type"] != "video/mp4" {
+		t.Errorf("upload allows %d bytes with headers %v", upload.MaxSize, upload.Headers)
+	}
+
+	rec := completeDirectUpload(t, cfg, video, token, upload.UploadID)
+	decodeResponse(t, rec, http.StatusConflict, nil)
+
+	putPresigned(t, upload, content)
+	decodeResponse(t, completeDirectUpload(t, cfg, video, token, upload.UploadID), http.StatusOK, &video)
+	checkStoredVideo(t, cfg, bucket, video, content)
+	if _, ok := bucket.object("uploads/" + upload.UploadID.String()); ok {
+		t.Error("uploaded object was kept after processing")
+	}
+
+	decodeResponse(t, completeDirectUpload(t, cfg, video, token, upload.UploadID), http.StatusNotFound, nil)
+}
+
+func TestDirectUploadRejectedFileIsDiscarded(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("not a video")
+
+	var upload directUpload
+	decodeResponse(t, createDirectUpload(t, cfg, video, token, int64(len(content))), http.StatusCreated, &upload)
+	putPresigned(t, upload, content)
+	decodeResponse(t, completeDirectUpload(t, cfg, video, token, upload.UploadID), http.StatusUnsupportedMediaType, nil)
+
+	if bucket.len() != 0 {
+		t.Errorf("bucket has %d objects, want none", bucket.len())
+	}
+	if pending, err := cfg.db.CountPendingUploads(user.ID); err != nil || pending != 0 {
+		t.Errorf("pending uploads = %d, %v; want 0", pending, err)
+	}
+}
+
+func TestDirectUploadLimits(t *testing.T) {
+	cfg, _, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("pending uploads reserve storage", func(t *testing.T) {
+		cfg.quotas = quotaPolicies{defaultUserRole: {MaxStorageBytes: 1000}}
+		defer func() { cfg.quotas = defaultQuotaPolicies() }()
+		var upload directUpload
+		decodeResponse(t, createDirectUpload(t, cfg, video, token, 600), http.StatusCreated, &upload)
+		decodeResponse(t, createDirectUpload(t, cfg, video, token, 600), http.StatusRequestEntityTooLarge, nil)
+		if err := cfg.db.DeletePendingUpload(upload.UploadID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("pending uploads are capped", func(t *testing.T) {
+		for range maxPendingUploads {
+			decodeResponse(t, createDirectUpload(t, cfg, video, token, 100), http.StatusCreated, nil)
+		}
+		decodeResponse(t, createDirectUpload(t, cfg, video, token, 100), http.StatusTooManyRequests, nil)
+	})
+}
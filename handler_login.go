@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -14,11 +17,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -28,6 +26,17 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	wait, err := cfg.checkLoginThrottle(params.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts", err)
+		return
+	}
+	if wait > 0 {
+		respondWithRetryAfter(w, wait, "Too many failed login attempts, try again later")
+		return
+	}
+
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
@@ -36,39 +45,111 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
+		var userID *uuid.UUID
+		if user.ID != uuid.Nil {
+			userID = &user.ID
+		}
+		if err := cfg.recordLoginFailure(params.Email, ip, userID); err != nil {
+			log.Printf("Couldn't record failed login: %v", err)
+		}
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
 		return
 	}
 
+	// Failures are only cleared once the whole login succeeded, so knowing
+	// the password doesn't reset the throttle on guessing the second factor.
+	challenge, err := cfg.mfaChallenge(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if challenge != nil {
+		respondWithJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	err = cfg.db.ClearLoginFailures(database.LoginFailureScopeAccount, loginAccountKey(params.Email))
+	if err != nil {
+		log.Printf("Couldn't clear failed logins: %v", err)
+	}
+
+	session, err := cfg.createSession(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+const (
+	accessTokenTTL  = time.Hour * 24 * 30
+	refreshTokenTTL = time.Hour * 24 * 60
+	mfaChallengeTTL = time.Minute * 5
+)
+
+// mfaChallengeResponse is returned instead of a session when the password was
+// correct but the account also requires a TOTP code.
+type mfaChallengeResponse struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+// mfaChallenge returns the challenge the user has to answer with their
+// second factor before getting a session, or nil if they have none enabled.
+func (cfg *apiConfig) mfaChallenge(userID uuid.UUID) (*mfaChallengeResponse, error) {
+	totp, err := cfg.db.GetUserTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp.EnabledAt == nil {
+		return nil, nil
+	}
+	challengeToken, err := auth.MakeChallengeJWT(userID, cfg.jwtKeys, mfaChallengeTTL)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create challenge token: %w", err)
+	}
+	return &mfaChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: challengeToken,
+	}, nil
+}
+
+type loginResponse struct {
+	database.User
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// createSession issues a new access token and a persisted refresh token for
+// the user.
+func (cfg *apiConfig) createSession(user database.User) (loginResponse, error) {
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
+		cfg.jwtKeys,
+		accessTokenTTL,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
-		return
+		return loginResponse{}, fmt.Errorf("couldn't create access JWT: %w", err)
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
-		return
+		return loginResponse{}, fmt.Errorf("couldn't create refresh token: %w", err)
 	}
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
 		UserID:    user.ID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
-		return
+		return loginResponse{}, fmt.Errorf("couldn't save refresh token: %w", err)
 	}
 
-	respondWithJSON(w, http.StatusOK, response{
+	return loginResponse{
 		User:         user,
 		Token:        accessToken,
 		RefreshToken: refreshToken,
-	})
+	}, nil
 }
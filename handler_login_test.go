@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// enableTestTOTP turns on two-factor authentication for the user and returns
+// its secret.
+func enableTestTOTP(t *testing.T, cfg *apiConfig, userID uuid.UUID) string {
+	t.Helper()
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.SetPendingTOTP(userID, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.EnableTOTP(userID); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// answerTestChallenge completes a two-factor login with the current code.
+func answerTestChallenge(t *testing.T, cfg *apiConfig, challengeToken, secret string) *httptest.ResponseRecorder {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerLoginTOTP(rec, newJSONRequest(t, http.MethodPost, "/api/login/totp", "", map[string]string{
+		"challenge_token": challengeToken,
+		"code":            code,
+	}))
+	return rec
+}
+
+func TestLoginKeepsFailuresUntilSecondFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	secret := enableTestTOTP(t, cfg, user.ID)
+	for range 2 {
+		if err := cfg.recordLoginFailure(user.Email, "192.0.2.1", &user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failures := func() int {
+		t.Helper()
+		failure, err := cfg.db.GetLoginFailure(database.LoginFailureScopeAccount, loginAccountKey(user.Email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return failure.Failures
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerLogin(rec, newJSONRequest(t, http.MethodPost, "/api/login", "", map[string]string{
+		"email":    user.Email,
+		"password": "password",
+	}))
+	var challenge mfaChallengeResponse
+	decodeResponse(t, rec, http.StatusOK, &challenge)
+	if !challenge.MFARequired || challenge.ChallengeToken == "" {
+		t.Fatalf("login response = %+v, want a challenge", challenge)
+	}
+	if got := failures(); got != 2 {
+		t.Errorf("failures after the password step = %d, want 2", got)
+	}
+
+	var session loginResponse
+	decodeResponse(t, answerTestChallenge(t, cfg, challenge.ChallengeToken, secret), http.StatusOK, &session)
+	if session.Token == "" {
+		t.Fatal("no access token after the second factor")
+	}
+	if got := failures(); got != 0 {
+		t.Errorf("failures after the whole login = %d, want 0", got)
+	}
+}
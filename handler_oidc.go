@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+// oidcStateCookie holds the state of the login started in this browser, so a
+// callback carrying another client's state is refused.
+const oidcStateCookie = "tubely_oidc_state"
+
+func (cfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(cfg.appURL, "https://"),
+		// Lax still sends the cookie on the identity provider's redirect back.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create state", err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create nonce", err)
+		return
+	}
+	codeVerifier, err := oidc.RandomString()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create code verifier", err)
+		return
+	}
+
+	err = cfg.db.CreateOIDCLoginState(database.OIDCLoginState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginStateTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login state", err)
+		return
+	}
+
+	authURL, err := cfg.oidcProvider.AuthCodeURL(r.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach identity provider", err)
+		return
+	}
+
+	cfg.setOIDCStateCookie(w, r, state, int(oidcLoginStateTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider returned an error: "+errCode, nil)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing authorization code", nil)
+		return
+	}
+
+	state := query.Get("state")
+	stateCookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login was not started in this browser", err)
+		return
+	}
+	cfg.setOIDCStateCookie(w, r, "", -1)
+
+	loginState, err := cfg.db.ConsumeOIDCLoginState(state)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load login state", err)
+		return
+	}
+	if loginState.State == "" || time.Now().UTC().After(loginState.ExpiresAt) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired login state", nil)
+		return
+	}
+
+	rawIDToken, err := cfg.oidcProvider.Exchange(r.Context(), code, loginState.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't exchange authorization code", err)
+		return
+	}
+
+	claims, err := cfg.oidcProvider.VerifyIDToken(r.Context(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify ID token", err)
+		return
+	}
+
+	user, status, err := cfg.resolveOIDCUser(claims)
+	if err != nil {
+		respondWithError(w, status, "Couldn't sign in with identity provider", err)
+		return
+	}
+
+	// The identity provider only replaces the password step, so accounts
+	// with two-factor authentication still have to pass it.
+	challenge, err := cfg.mfaChallenge(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check two-factor authentication", err)
+		return
+	}
+	if challenge != nil {
+		respondWithJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	session, err := cfg.createSession(user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, session)
+}
+
+// resolveOIDCUser finds the user linked to the identity, links it to an
+// existing account with the same verified email, or provisions a new user.
+// The returned status code is only meaningful when err is non-nil.
+func (cfg *apiConfig) resolveOIDCUser(claims *oidc.IDTokenClaims) (database.User, int, error) {
+	issuer := cfg.oidcProvider.Issuer()
+
+	identity, err := cfg.db.GetUserIdentity(issuer, claims.Subject)
+	if err != nil {
+		return database.User{}, http.StatusInternalServerError, err
+	}
+	if identity.Subject != "" {
+		user, err := cfg.db.GetUser(identity.UserID)
+		if err != nil {
+			return database.User{}, http.StatusInternalServerError, err
+		}
+		if user == nil {
+			return database.User{}, http.StatusUnauthorized, errors.New("linked user no longer exists")
+		}
+		return *user, 0, nil
+	}
+
+	// Without a verified email we could be linking an attacker-controlled
+	// identity to someone else's account, so refuse to continue.
+	if claims.Email == "" || !claims.EmailVerified {
+		return database.User{}, http.StatusForbidden, errors.New("identity provider did not supply a verified email")
+	}
+
+	user, err := cfg.db.GetUserByEmail(claims.Email)
+	if err != nil {
+		return database.User{}, http.StatusInternalServerError, err
+	}
+	if user.ID == uuid.Nil {
+		// Just-in-time provisioning. The password is a random value nobody
+		// knows, so the account can only sign in through the provider until a
+		// password is set.
+		randomPassword, err := oidc.RandomString()
+		if err != nil {
+			return database.User{}, http.StatusInternalServerError, err
+		}
+		hashedPassword, err := auth.HashPassword(randomPassword)
+		if err != nil {
+			return database.User{}, http.StatusInternalServerError, err
+		}
+		created, err := cfg.db.CreateUser(database.CreateUserParams{
+			Email:    claims.Email,
+			Password: hashedPassword,
+		})
+		if err != nil {
+			return database.User{}, http.StatusInternalServerError, err
+		}
+		if err := cfg.db.MarkUserEmailVerified(created.ID); err != nil {
+			return database.User{}, http.StatusInternalServerError, err
+		}
+		user = *created
+	}
+
+	err = cfg.db.CreateUserIdentity(database.UserIdentity{
+		Issuer:  issuer,
+		Subject: claims.Subject,
+		UserID:  user.ID,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return database.User{}, http.StatusInternalServerError, err
+	}
+
+	return user, 0, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newOIDCTestConfig(t *testing.T) (*apiConfig, *oidctest.Issuer) {
+	t.Helper()
+	cfg := newTestConfig(t)
+	issuer, err := oidctest.NewIssuer("tubely")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	cfg.oidcProvider = oidc.NewProvider(oidc.Config{
+		Issuer:      issuer.URL,
+		ClientID:    "tubely",
+		RedirectURL: cfg.appURL + "/api/oidc/callback",
+	})
+	return cfg, issuer
+}
+
+// startOIDCLogin starts a login as a browser would and returns the callback
+// the issuer redirects to, along with the state cookie the browser got.
+func startOIDCLogin(t *testing.T, cfg *apiConfig, issuer *oidctest.Issuer) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatalf("login didn't set an HttpOnly state cookie: %v", rec.Result().Cookies())
+	}
+	callback, err := issuer.Authorize(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.String(), stateCookie
+}
+
+func finishOIDCLogin(cfg *apiConfig, callbackURL string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, callbackURL, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerOIDCCallback(rec, req)
+	return rec
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startOIDCLogin(t, cfg, issuer)
+	var session loginResponse
+	decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusOK, &session)
+	if session.Email != "new@example.com" || session.Token == "" || session.RefreshToken == "" {
+		t.Fatalf("session = %+v", session)
+	}
+	user, err := cfg.db.GetUser(session.ID)
+	if err != nil || user == nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("provisioned user = %+v, %v, want a verified user", user, err)
+	}
+
+	// Signing in again with the same identity reuses the account, even after
+	// the email changed at the provider.
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-1", Email: "renamed@example.com", EmailVerified: true})
+	callbackURL, cookie = startOIDCLogin(t, cfg, issuer)
+	var again loginResponse
+	decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusOK, &again)
+	if again.ID != session.ID {
+		t.Errorf("second login signed in %s, want %s", again.ID, session.ID)
+	}
+}
+
+func TestOIDCLoginLinksExistingAccount(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	existing, _ := createTestUser(t, cfg, "existing@example.com")
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-2", Email: "existing@example.com", EmailVerified: true})
+
+	callbackURL, cookie := startOIDCLogin(t, cfg, issuer)
+	var session loginResponse
+	decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusOK, &session)
+	if session.ID != existing.ID {
+		t.Fatalf("signed in %s, want existing user %s", session.ID, existing.ID)
+	}
+	identity, err := cfg.db.GetUserIdentity(issuer.URL, "sub-2")
+	if err != nil || identity.UserID != existing.ID {
+		t.Errorf("identity = %+v, %v, want it linked to %s", identity, err, existing.ID)
+	}
+}
+
+func TestOIDCLoginRequiresVerifiedEmail(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	existing, _ := createTestUser(t, cfg, "victim@example.com")
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-3", Email: "victim@example.com"})
+
+	callbackURL, cookie := startOIDCLogin(t, cfg, issuer)
+	decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusForbidden, nil)
+	identities, err := cfg.db.GetUserIdentities(existing.ID)
+	if err != nil || len(identities) != 0 {
+		t.Errorf("identities = %v, %v, want none", identities, err)
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-4", Email: "user@example.com", EmailVerified: true})
+
+	t.Run("no cookie", func(t *testing.T) {
+		callbackURL, _ := startOIDCLogin(t, cfg, issuer)
+		decodeResponse(t, finishOIDCLogin(cfg, callbackURL, nil), http.StatusBadRequest, nil)
+	})
+
+	t.Run("cookie of another login", func(t *testing.T) {
+		victimCallback, _ := startOIDCLogin(t, cfg, issuer)
+		_, attackerCookie := startOIDCLogin(t, cfg, issuer)
+		decodeResponse(t, finishOIDCLogin(cfg, victimCallback, attackerCookie), http.StatusBadRequest, nil)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		callbackURL := cfg.appURL + "/api/oidc/callback?code=code&state=forged"
+		cookie := &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+		decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusBadRequest, nil)
+	})
+
+	t.Run("replayed state", func(t *testing.T) {
+		callbackURL, cookie := startOIDCLogin(t, cfg, issuer)
+		decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusOK, nil)
+		decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusBadRequest, nil)
+	})
+}
+
+func TestResolveOIDCUserKeepsIdentityLink(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	user, _ := createTestUser(t, cfg, "linked@example.com")
+	err := cfg.db.CreateUserIdentity(database.UserIdentity{Issuer: issuer.URL, Subject: "sub-5", UserID: user.ID, Email: user.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A linked identity signs in its user even without a verified email.
+	resolved, _, err := cfg.resolveOIDCUser(&oidc.IDTokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-5"}})
+	if err != nil || resolved.ID != user.ID {
+		t.Errorf("resolveOIDCUser = %s, %v, want %s", resolved.ID, err, user.ID)
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	cfg, issuer := newOIDCTestConfig(t)
+	user, _ := createTestUser(t, cfg, "mfa@example.com")
+	secret := enableTestTOTP(t, cfg, user.ID)
+	issuer.SetIdentity(oidctest.Identity{Subject: "sub-6", Email: user.Email, EmailVerified: true})
+
+	callbackURL, cookie := startOIDCLogin(t, cfg, issuer)
+	var challenge struct {
+		mfaChallengeResponse
+		Token string `json:"token"`
+	}
+	decodeResponse(t, finishOIDCLogin(cfg, callbackURL, cookie), http.StatusOK, &challenge)
+	if !challenge.MFARequired || challenge.ChallengeToken == "" || challenge.Token != "" {
+		t.Fatalf("callback response = %+v, want only a challenge", challenge)
+	}
+
+	var session loginResponse
+	decodeResponse(t, answerTestChallenge(t, cfg, challenge.ChallengeToken, secret), http.StatusOK, &session)
+	if session.ID != user.ID || session.Token == "" {
+		t.Errorf("session = %+v, want one for %s", session, user.ID)
+	}
+}
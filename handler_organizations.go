@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const organizationInvitationTTL = time.Hour * 24 * 7
+
+func (cfg *apiConfig) handlerOrganizationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Name is required", nil)
+		return
+	}
+
+	org, err := cfg.db.CreateOrganization(params.Name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (cfg *apiConfig) handlerOrganizationsRetrieve(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	orgs, err := cfg.db.GetUserOrganizations(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve organizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersRetrieve(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve members", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (cfg *apiConfig) handlerOrganizationInvite(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string           `json:"email"`
+		Role  database.OrgRole `json:"role"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, http.StatusBadRequest, "Email is required", nil)
+		return
+	}
+	if params.Role == "" {
+		params.Role = database.OrgRoleMember
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return
+	}
+	if !role.CanManage() {
+		respondWithError(w, http.StatusForbidden, "Only owners and admins can invite members", nil)
+		return
+	}
+	if params.Role == database.OrgRoleOwner && role != database.OrgRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can invite owners", nil)
+		return
+	}
+
+	invitationToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create invitation token", err)
+		return
+	}
+
+	invitation, err := cfg.db.CreateOrganizationInvitation(database.CreateOrganizationInvitationParams{
+		Token:     invitationToken,
+		OrgID:     orgID,
+		Email:     params.Email,
+		Role:      params.Role,
+		InvitedBy: userID,
+		ExpiresAt: time.Now().UTC().Add(organizationInvitationTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create invitation", err)
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+	sendEmailInBackground("invitation", func(ctx context.Context) error {
+		return cfg.sendOrganizationInvitationEmail(ctx, invitation, org)
+	})
+
+	respondWithJSON(w, http.StatusCreated, invitation)
+}
+
+func (cfg *apiConfig) handlerOrganizationInvitationAccept(w http.ResponseWriter, r *http.Request) {
+	invitationToken := r.PathValue("token")
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	invitation, err := cfg.db.GetOrganizationInvitation(invitationToken)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get invitation", err)
+		return
+	}
+	if invitation.Token == "" {
+		respondWithError(w, http.StatusNotFound, "Invitation not found", nil)
+		return
+	}
+	if invitation.AcceptedAt != nil || time.Now().UTC().After(invitation.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Invitation is no longer valid", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		respondWithError(w, http.StatusForbidden, "Invitation was sent to a different email", nil)
+		return
+	}
+	if user.EmailVerifiedAt == nil {
+		respondWithError(w, http.StatusForbidden, "Verify your email before accepting invitations", nil)
+		return
+	}
+
+	err = cfg.db.AcceptOrganizationInvitation(invitation.Token, userID)
+	if err != nil {
+		respondWithError(w, http.StatusConflict, "Couldn't accept invitation", err)
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(invitation.OrgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, database.OrganizationMembership{
+		Organization: org,
+		Role:         invitation.Role,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role database.OrgRole `json:"role"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Invalid role", nil)
+		return
+	}
+
+	// The caller's role is checked before the member is looked up, so
+	// outsiders can't tell who belongs to the organization.
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return
+	}
+	if !role.CanManage() {
+		respondWithError(w, http.StatusForbidden, "Only owners and admins can change roles", nil)
+		return
+	}
+	memberRole, err := cfg.db.GetOrganizationRole(orgID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return
+	}
+	if memberRole == "" {
+		respondWithError(w, http.StatusNotFound, "User is not a member of the organization", nil)
+		return
+	}
+	if (params.Role == database.OrgRoleOwner || memberRole == database.OrgRoleOwner) && role != database.OrgRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only owners can grant or revoke ownership", nil)
+		return
+	}
+	if memberRole == database.OrgRoleOwner && params.Role != database.OrgRoleOwner {
+		if ok := cfg.ensureAnotherOwner(w, orgID); !ok {
+			return
+		}
+	}
+
+	err = cfg.db.AddOrganizationMember(orgID, memberID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerOrganizationMemberRemove(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return
+	}
+	// Members may always leave; removing someone else requires management
+	// rights, checked before the member is looked up so outsiders can't tell
+	// who belongs to the organization.
+	if memberID != userID && !role.CanManage() {
+		respondWithError(w, http.StatusForbidden, "Only owners and admins can remove members", nil)
+		return
+	}
+	memberRole := role
+	if memberID != userID {
+		memberRole, err = cfg.db.GetOrganizationRole(orgID, memberID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+			return
+		}
+	}
+	if memberRole == "" {
+		respondWithError(w, http.StatusNotFound, "User is not a member of the organization", nil)
+		return
+	}
+	if memberRole == database.OrgRoleOwner {
+		if memberID != userID && role != database.OrgRoleOwner {
+			respondWithError(w, http.StatusForbidden, "Only owners can remove owners", nil)
+			return
+		}
+		if ok := cfg.ensureAnotherOwner(w, orgID); !ok {
+			return
+		}
+	}
+
+	err = cfg.db.RemoveOrganizationMember(orgID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ensureAnotherOwner responds with a conflict and returns false if demoting or
+// removing an owner would leave the organization without one.
+func (cfg *apiConfig) ensureAnotherOwner(w http.ResponseWriter, orgID uuid.UUID) bool {
+	owners, err := cfg.db.CountOrganizationOwners(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count organization owners", err)
+		return false
+	}
+	if owners < 2 {
+		respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+		return false
+	}
+	return true
+}
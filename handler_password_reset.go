@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	// Respond the same way whether or not the account exists so the
+	// endpoint can't be used to discover registered emails.
+	if user.ID != uuid.Nil {
+		sendEmailInBackground("password reset", func(ctx context.Context) error {
+			return cfg.sendPasswordResetEmail(ctx, user)
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+
+	userID, err := cfg.db.ConsumeUserToken(auth.HashToken(params.Token), database.UserTokenPurposePasswordReset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Reset link is invalid or expired", nil)
+		return
+	}
+
+	err = cfg.setPassword(userID, params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password", err)
+		return
+	}
+
+	// Receiving the reset link proves control of the mailbox, and the owner
+	// shouldn't stay locked out by someone else's guessing.
+	user, err := cfg.db.GetUser(userID)
+	if err == nil && user != nil {
+		if err := cfg.db.MarkUserEmailVerified(userID); err != nil {
+			log.Printf("Couldn't mark email verified: %v", err)
+		}
+		if err := cfg.db.ClearLoginFailures(database.LoginFailureScopeAccount, loginAccountKey(user.Email)); err != nil {
+			log.Printf("Couldn't clear failed logins: %v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestVerifySecondFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	secret := enableTestTOTP(t, cfg, user.ID)
+	totp, err := cfg.db.GetUserTOTP(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(code, recoveryCode string) bool {
+		t.Helper()
+		ok, err := cfg.verifySecondFactor(totp, code, recoveryCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	codeAt := func(step int64) string {
+		t.Helper()
+		code, err := auth.TOTPCode(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	current := auth.TOTPStep(time.Now())
+	if !verify(codeAt(current), "") {
+		t.Fatal("current code was rejected")
+	}
+	// A code can't be replayed, and neither can one from an earlier step
+	// that is still in the skew window.
+	if verify(codeAt(current), "") {
+		t.Error("code was accepted twice")
+	}
+	if verify(codeAt(current-1), "") {
+		t.Error("code older than the last used one was accepted")
+	}
+	if verify(codeAt(current+2), "") {
+		t.Error("code outside the skew window was accepted")
+	}
+
+	codes, err := cfg.issueRecoveryCodes(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("issued %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	if !verify("", strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Fatal("recovery code was rejected")
+	}
+	if verify("", codes[0]) {
+		t.Error("recovery code was accepted twice")
+	}
+	if unused, err := cfg.db.CountUnusedRecoveryCodes(user.ID); err != nil || unused != recoveryCodeCount-1 {
+		t.Errorf("unused recovery codes = %d, %v; want %d", unused, err, recoveryCodeCount-1)
+	}
+
+	// New codes replace the old ones.
+	if _, err := cfg.issueRecoveryCodes(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if verify("", codes[1]) {
+		t.Error("replaced recovery code was accepted")
+	}
+}
+
+func TestLoginTOTPRejectsReplayedCode(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	secret := enableTestTOTP(t, cfg, user.ID)
+	challenge := func() string {
+		var challenge mfaChallengeResponse
+		decodeResponse(t, login(t, cfg, user.Email, "password", "192.0.2.1"), http.StatusOK, &challenge)
+		return challenge.ChallengeToken
+	}
+
+	decodeResponse(t, answerTestChallenge(t, cfg, challenge(), secret), http.StatusOK, nil)
+	decodeResponse(t, answerTestChallenge(t, cfg, challenge(), secret), http.StatusUnauthorized, nil)
+}
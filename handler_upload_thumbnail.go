@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 
@@ -26,7 +27,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -67,35 +68,65 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusNotFound, "ID does not match any videos", nil)
 		return
 	}
-	if videoData.UserID.ID() != userID.ID() {
-		respondWithError(w, http.StatusUnauthorized, "User is not the owner of the video", nil)
+	canEdit, err := cfg.canEditVideo(videoData, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
 		return
 	}
-
-	assetPath, err := getAssetPath(mediaType)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate asset path", err)
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
 		return
 	}
-	imageSavePath := cfg.getAssetDiskPath(assetPath)
-	saveFile, err := os.Create(imageSavePath)
+
+	tempFile, err := os.CreateTemp(cfg.assetsRoot, ".upload-*")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating image file on disk", err)
 		return
 	}
-	defer saveFile.Close()
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
 
-	if _, err := io.Copy(saveFile, imageFile); err != nil {
+	hasher := newContentHasher()
+	if _, err := io.Copy(tempFile, hasher.reader(imageFile)); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error copying image to disk", err)
 		return
 	}
+	if err := tempFile.Close(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error copying image to disk", err)
+		return
+	}
+
+	policy, used, err := cfg.userQuota(videoData.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+	if err := policy.checkUpload(used, hasher.size); err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+		return
+	}
 
+	assetPath, err := cfg.storeLocalAsset(videoData.UserID, tempFile.Name(), hasher, mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error storing image", err)
+		return
+	}
+
+	previousThumbnail := videoData.ThumbnailURL
 	thumbnailUrl := cfg.getAssetURL(assetPath)
 	videoData.ThumbnailURL = &thumbnailUrl
 	if err := cfg.db.UpdateVideo(videoData); err != nil {
+		if releaseErr := cfg.releaseLocalAsset(videoData.UserID, thumbnailUrl); releaseErr != nil {
+			log.Printf("Couldn't release thumbnail %s: %v", assetPath, releaseErr)
+		}
 		respondWithError(w, http.StatusUnauthorized, "Error updating video information", err)
 		return
 	}
+	if previousThumbnail != nil {
+		if err := cfg.releaseLocalAsset(videoData.UserID, *previousThumbnail); err != nil {
+			log.Printf("Couldn't release thumbnail of video %s: %v", videoID, err)
+		}
+	}
 
 	respondWithJSON(w, http.StatusOK, videoData)
 }
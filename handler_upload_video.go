@@ -1,23 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
 	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const maxMemory = 1 << 30
-
-	r.Body = http.MaxBytesReader(w, r.Body, maxMemory)
+// multipartOverhead is allowed on top of the file size for the multipart
+// boundaries and headers of an upload request.
+const multipartOverhead = 1 << 20
 
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
 	if err != nil {
@@ -31,7 +35,7 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
 		return
@@ -43,14 +47,41 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	videoData, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "ID does not match any videos", err)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(videoData, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
 	}
-	if videoData.UserID.ID() != userID.ID() {
-		respondWithError(w, http.StatusUnauthorized, "User is not the owner of the video", nil)
+
+	// Uploads are charged to the video's owner, who may not be the uploader
+	// for organization videos.
+	ownerID := videoData.UserID
+	policy, used, err := cfg.userQuota(ownerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
 		return
 	}
+	if r.ContentLength > 0 {
+		if err := policy.checkUpload(used, r.ContentLength-multipartOverhead); err != nil {
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+			return
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, policy.uploadLimit()+multipartOverhead)
 
 	videoFile, header, err := r.FormFile("video")
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Upload is too large", err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Unable to parse video form file", err)
 		return
 	}
@@ -61,8 +92,8 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusBadRequest, "Couldn't parse Content-Type", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type, only mp4 allowed", nil)
+	if !acceptedUploadTypes[mediaType] {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Invalid Content-Type, only MP4, MOV, MKV and WebM are allowed", nil)
 		return
 	}
 
@@ -74,66 +105,135 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer os.Remove(tempVideoFile.Name())
 	defer tempVideoFile.Close()
 
-	if _, err := io.Copy(tempVideoFile, videoFile); err != nil {
+	hasher := newContentHasher()
+	if _, err := io.Copy(tempVideoFile, hasher.reader(videoFile)); err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error copying video to disk", err)
 		return
 	}
 
-	if _, err := tempVideoFile.Seek(0, io.SeekStart); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error resetting file offset", err)
+	videoData, err = cfg.ingestVideo(r.Context(), videoData, userID, tempVideoFile, hasher)
+	if err != nil {
+		respondWithIngestError(w, err)
 		return
 	}
+	respondWithJSON(w, http.StatusOK, videoData)
+}
 
-	processedVideoPath, err := processVideoForFastStart(tempVideoFile.Name())
+// ingestVideo validates the video file received into tempVideoFile, stores
+// it and makes it the video's current version, charged to the video's
+// owner. Failures are *ingestError.
+func (cfg *apiConfig) ingestVideo(ctx context.Context, videoData database.Video, userID uuid.UUID, tempVideoFile *os.File, hasher *contentHasher) (database.Video, error) {
+	ownerID := videoData.UserID
+	policy, used, err := cfg.userQuota(ownerID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video file", err)
-		return
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't get storage quota", err}
 	}
-	defer os.Remove(processedVideoPath)
-	processedVideoFile, err := os.Open(processedVideoPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error opening processed video file", err)
-		return
+	if err := policy.checkUpload(used, hasher.size); err != nil {
+		return database.Video{}, &ingestError{http.StatusRequestEntityTooLarge, err.Error(), err}
 	}
-	defer processedVideoFile.Close()
 
-	aspectRatio, err := getVideoAspectRatio(processedVideoFile.Name())
+	container, err := sniffContainer(tempVideoFile)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error getting aspect ratio from video", err)
-		return
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Error reading uploaded video", err}
+	}
+	if container == media.ContainerUnknown {
+		return database.Video{}, &ingestError{http.StatusUnsupportedMediaType, "File is not an MP4, MOV, MKV or WebM video", nil}
+	}
+
+	probe, err := cfg.probeUpload(ctx, tempVideoFile.Name(), container)
+	if errors.Is(err, media.ErrInvalidMedia) {
+		return database.Video{}, &ingestError{http.StatusBadRequest, "Invalid video file", err}
 	}
-	key, err := getAssetPath(mediaType)
-	key = fmt.Sprintf("%s/%s", aspectRatio, key)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate asset path", err)
-		return
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Error inspecting video file", err}
 	}
-	if _, err := cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket: &cfg.s3Bucket,
-		Key: &key,
-		Body: processedVideoFile,
-		ContentType: &mediaType,
-	}); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error sending video to s3 bucket", err)
-		return
+	if err := policy.checkVideo(probe); err != nil {
+		return database.Video{}, &ingestError{http.StatusRequestEntityTooLarge, err.Error(), err}
 	}
-	
-	newVideoUrl := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
-	videoData.VideoURL = &newVideoUrl
-	if err := cfg.db.UpdateVideo(videoData); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error updating video information", err)
-		return
+	dimensions, err := videoDimensions(probe)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusBadRequest, "Invalid video file", err}
 	}
 
-	respondWithJSON(w, http.StatusOK, videoData)
+	// Identical uploads share one stored object, so processing and uploading
+	// can be skipped entirely for content we already have.
+	digest := cfg.contentDigest(hasher)
+	asset, found, err := cfg.db.AcquireAsset(database.AssetStorageS3, digest)
+	if err != nil {
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Error looking up stored videos", err}
+	}
+	if !found {
+		asset, err = cfg.uploadVideoObject(ctx, tempVideoFile.Name(), digest, probe, aspectKeyPrefix(dimensions.AspectClass))
+		if err != nil {
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Error storing video file", err}
+		}
+	}
+	cfg.chargeStorage(ownerID, asset.Size)
+	newVideoUrl := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, asset.Key)
+	// The converted file can be larger than the upload that was checked.
+	if err := policy.checkStorage(used, asset.Size); err != nil {
+		if releaseErr := cfg.releaseS3Object(ctx, ownerID, newVideoUrl); releaseErr != nil {
+			log.Printf("Couldn't release video file %s: %v", asset.Key, releaseErr)
+		}
+		return database.Video{}, &ingestError{http.StatusRequestEntityTooLarge, err.Error(), err}
+	}
+
+	return cfg.commitVideoFile(ctx, videoData, userID, storedVideoFile{
+		url:        newVideoUrl,
+		path:       tempVideoFile.Name(),
+		probe:      probe,
+		dimensions: dimensions,
+	})
+}
+
+// uploadVideoObject converts the validated upload, stores the result in the
+// bucket under keyPrefix and records it as an asset.
+func (cfg *apiConfig) uploadVideoObject(ctx context.Context, filePath, digest string, probe media.ProbeResult, keyPrefix string) (database.Asset, error) {
+	processedVideoPath, err := cfg.convertVideo(ctx, filePath, probe)
+	if err != nil {
+		return database.Asset{}, err
+	}
+	defer os.Remove(processedVideoPath)
+	return cfg.putS3Object(ctx, processedVideoPath, digest, keyPrefix, media.ContainerMP4.MediaType())
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
-	outputPath := filePath + ".processing"
-	command := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-	if err := command.Run(); err != nil {
-		return "", err
+// putS3Object stores a file in the bucket under keyPrefix and records it as
+// an asset. If the same content was stored in the meantime, that asset is
+// returned with a new reference instead.
+func (cfg *apiConfig) putS3Object(ctx context.Context, filePath, digest, keyPrefix, mediaType string) (database.Asset, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return database.Asset{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return database.Asset{}, err
+	}
+
+	params := database.CreateAssetParams{
+		Storage:   database.AssetStorageS3,
+		Key:       fmt.Sprintf("%s/%s", keyPrefix, getAssetPath(digest, mediaType)),
+		Digest:    digest,
+		MediaType: mediaType,
+		Size:      info.Size(),
+	}
+	existing, found, err := cfg.lockAsset(ctx, params)
+	if err != nil {
+		return database.Asset{}, err
+	}
+	if found {
+		return existing, nil
+	}
+	if _, err := cfg.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &cfg.s3Bucket,
+		Key:         &params.Key,
+		Body:        file,
+		ContentType: &mediaType,
+	}); err != nil {
+		cfg.unlockAsset(params.Storage, params.Key)
+		return database.Asset{}, fmt.Errorf("couldn't upload to s3: %w", err)
 	}
 
-	return outputPath, nil
+	return cfg.db.AddAssetReference(params)
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/textproto"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fetch"
+	"github.com/google/uuid"
+)
+
+func newUploadRequest(t *testing.T, videoID uuid.UUID, token string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="video"; filename="video.mp4"`)
+	header.Set("Content-Type", "video/mp4")
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID.String(), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.SetPathValue("videoID", videoID.String())
+	return req
+}
+
+// uploadTestVideo uploads content as the file of a new video and returns
+// the updated video.
+func uploadTestVideo(t *testing.T, cfg *apiConfig, user database.User, token string, content []byte) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, newUploadRequest(t, video.ID, token, content))
+	decodeResponse(t, rec, http.StatusOK, &video)
+	return video
+}
+
+// checkStoredVideo checks that the video's file is in the bucket and that
+// the owner is charged for exactly what their videos store. Responses only
+// hold stream URLs, so the video is read from the database.
+func checkStoredVideo(t *testing.T, cfg *apiConfig, bucket *fakeS3, video database.Video, content []byte) {
+	t.Helper()
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.VideoURL == nil {
+		t.Fatal("video has no file")
+	}
+	stored, ok := bucket.object(cfg.s3KeyFromURL(*video.VideoURL))
+	if !ok || !bytes.Equal(stored, content) {
+		t.Errorf("bucket has %q at %s, want the uploaded file", stored, *video.VideoURL)
+	}
+
+	videos, err := cfg.db.GetVideosUploadedBy(video.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, owned := range videos {
+		records, err := cfg.getVideoRecords(owned.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		videoSize, err := cfg.videoStorageSize(owned, records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += videoSize
+	}
+	if used, err := cfg.db.GetUserStorage(video.UserID); err != nil || used != size {
+		t.Errorf("storage = %d, %v; want %d", used, err, size)
+	}
+}
+
+func TestUploadVideo(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	content := testVideo("upload")
+
+	video := uploadTestVideo(t, cfg, user, token, content)
+	checkStoredVideo(t, cfg, bucket, video, content)
+	if video.PreviewURL == nil || video.PreviewVideoURL == nil {
+		t.Errorf("previews = %v, %v; want both", video.PreviewURL, video.PreviewVideoURL)
+	}
+	if video.AudioAACURL == nil || video.AudioOpusURL == nil {
+		t.Errorf("audio renditions = %v, %v; want both", video.AudioAACURL, video.AudioOpusURL)
+	}
+	// H.264/AAC in an MP4 is only remuxed.
+	if !called(fake, "remux") || called(fake, "transcode") {
+		t.Errorf("media calls = %v, want a remux", fake.Calls)
+	}
+}
+
+func TestUploadVideoRejectsOtherFiles(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, newUploadRequest(t, video.ID, token, []byte("not a video")))
+	decodeResponse(t, rec, http.StatusUnsupportedMediaType, nil)
+	if bucket.len() != 0 {
+		t.Errorf("bucket has %d objects, want none", bucket.len())
+	}
+	if used, err := cfg.db.GetUserStorage(user.ID); err != nil || used != 0 {
+		t.Errorf("storage = %d, %v; want 0", used, err)
+	}
+}
+
+func TestUploadVideoOverQuota(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	cfg.quotas = quotaPolicies{defaultUserRole: {MaxUploadBytes: 10}}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, newUploadRequest(t, video.ID, token, testVideo("too large")))
+	decodeResponse(t, rec, http.StatusRequestEntityTooLarge, nil)
+	if bucket.len() != 0 {
+		t.Errorf("bucket has %d objects, want none", bucket.len())
+	}
+}
+
+func TestVideoImport(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	content := testVideo("import")
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer source.Close()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	importVideo := func() *httptest.ResponseRecorder {
+		req := newJSONRequest(t, http.MethodPost, "/api/videos/"+video.ID.String()+"/import", token, map[string]string{
+			"url": source.URL + "/video.mp4",
+		})
+		req.SetPathValue("videoID", video.ID.String())
+		rec := httptest.NewRecorder()
+		cfg.handlerVideoImport(rec, req)
+		return rec
+	}
+
+	// The source server listens on loopback, which isn't imported from
+	// unless allowed.
+	cfg.importer = fetch.New(fetch.Options{})
+	decodeResponse(t, importVideo(), http.StatusBadRequest, nil)
+
+	cfg.importer = fetch.New(fetch.Options{Allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}})
+	decodeResponse(t, importVideo(), http.StatusOK, &video)
+	checkStoredVideo(t, cfg, bucket, video, content)
+}
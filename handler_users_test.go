@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+func TestChangePasswordRevokesAccessTokens(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, oldToken := createTestUser(t, cfg, "user@example.com")
+	// Token times have second precision, so the change has to happen in a
+	// later second for the old token to predate it.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	rec := httptest.NewRecorder()
+	cfg.handlerUsersChangePassword(rec, newJSONRequest(t, http.MethodPut, "/api/users/password", oldToken, map[string]string{
+		"current_password": "password",
+		"new_password":     "new password",
+	}))
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+
+	if _, err := auth.ValidateJWT(oldToken, cfg.jwtKeys); err == nil {
+		t.Error("access token issued before the password change is still valid")
+	}
+	newToken, err := auth.MakeJWT(user.ID, cfg.jwtKeys, accessTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateJWT(newToken, cfg.jwtKeys); err != nil {
+		t.Errorf("access token issued after the password change is invalid: %v", err)
+	}
+}
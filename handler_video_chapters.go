@@ -0,0 +1,337 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxChapterTitleLength is the longest chapter title accepted, in bytes.
+const maxChapterTitleLength = 200
+
+// chapterStart is a chapter start given either as seconds, the way
+// chapters are returned, or as a timestamp like "1:30".
+type chapterStart time.Duration
+
+func (s *chapterStart) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		if seconds < 0 {
+			return fmt.Errorf("negative chapter start %v", seconds)
+		}
+		*s = chapterStart(time.Duration(seconds * float64(time.Second)))
+		return nil
+	}
+	var timestamp string
+	if err := json.Unmarshal(data, &timestamp); err != nil {
+		return errors.New("chapter start must be seconds or a timestamp")
+	}
+	start, err := parseTimestamp(timestamp)
+	if err != nil {
+		return err
+	}
+	*s = chapterStart(start)
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoChaptersRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canView, err := cfg.canViewVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+
+	chapters, err := cfg.db.GetVideoChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+// handlerVideoChaptersUpdate replaces the chapters of a video. A chapter
+// may use the thumbnail of a suggestion or of a current chapter.
+func (cfg *apiConfig) handlerVideoChaptersUpdate(w http.ResponseWriter, r *http.Request) {
+	type chapter struct {
+		Start        chapterStart `json:"start"`
+		Title        string       `json:"title"`
+		ThumbnailURL *string      `json:"thumbnail_url"`
+	}
+	type parameters struct {
+		Chapters []chapter `json:"chapters"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.videoForChapters(w, videoID, userID)
+	if err != nil {
+		return
+	}
+	version, err := cfg.db.GetVideoVersion(videoID, video.Version)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video version", err)
+		return
+	}
+	suggestions, err := cfg.db.GetChapterSuggestions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter suggestions", err)
+		return
+	}
+	current, err := cfg.db.GetVideoChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+	thumbnails := map[string]bool{}
+	for _, suggestion := range suggestions {
+		if suggestion.ThumbnailURL != nil {
+			thumbnails[*suggestion.ThumbnailURL] = true
+		}
+	}
+	for _, chapter := range current {
+		if chapter.ThumbnailURL != nil {
+			thumbnails[*chapter.ThumbnailURL] = true
+		}
+	}
+
+	chapters := []database.VideoChapter{}
+	for _, chapter := range params.Chapters {
+		start := time.Duration(chapter.Start)
+		title := strings.TrimSpace(chapter.Title)
+		if title == "" || len(title) > maxChapterTitleLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chapter titles must be 1 to %d characters", maxChapterTitleLength), nil)
+			return
+		}
+		if version.Duration > 0 && start.Seconds() >= version.Duration {
+			respondWithError(w, http.StatusBadRequest, "Chapter starts after the end of the video", nil)
+			return
+		}
+		if chapter.ThumbnailURL != nil && !thumbnails[*chapter.ThumbnailURL] {
+			respondWithError(w, http.StatusBadRequest, "Chapter thumbnails must be one of the video's chapter thumbnails", nil)
+			return
+		}
+		chapters = append(chapters, database.VideoChapter{
+			Start:        start.Seconds(),
+			Title:        title,
+			ThumbnailURL: chapter.ThumbnailURL,
+		})
+	}
+	slices.SortFunc(chapters, func(a, b database.VideoChapter) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	for i := 1; i < len(chapters); i++ {
+		if chapters[i].Start == chapters[i-1].Start {
+			respondWithError(w, http.StatusBadRequest, "Chapters must start at different times", nil)
+			return
+		}
+	}
+
+	if err := cfg.setVideoChapters(video, current, chapters); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+func (cfg *apiConfig) handlerChapterSuggestionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if _, err := cfg.videoForChapters(w, videoID, userID); err != nil {
+		return
+	}
+	suggestions, err := cfg.db.GetChapterSuggestions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter suggestions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, suggestions)
+}
+
+// handlerChapterSuggestionsAccept replaces the chapters of a video with
+// its suggestions, numbered as titles. They can be renamed and adjusted
+// with a PUT afterwards.
+func (cfg *apiConfig) handlerChapterSuggestionsAccept(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.videoForChapters(w, videoID, userID)
+	if err != nil {
+		return
+	}
+	suggestions, err := cfg.db.GetChapterSuggestions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapter suggestions", err)
+		return
+	}
+	if len(suggestions) == 0 {
+		respondWithError(w, http.StatusConflict, "Video has no chapter suggestions", nil)
+		return
+	}
+	current, err := cfg.db.GetVideoChapters(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chapters", err)
+		return
+	}
+
+	chapters := make([]database.VideoChapter, len(suggestions))
+	for i, suggestion := range suggestions {
+		chapters[i] = database.VideoChapter{
+			Start:        suggestion.Start,
+			Title:        fmt.Sprintf("Chapter %d", i+1),
+			ThumbnailURL: suggestion.ThumbnailURL,
+		}
+	}
+	if err := cfg.setVideoChapters(video, current, chapters); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save chapters", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chapters)
+}
+
+// videoForChapters returns the video if the user may edit its chapters.
+// Otherwise the error response has been written.
+func (cfg *apiConfig) videoForChapters(w http.ResponseWriter, videoID, userID uuid.UUID) (database.Video, error) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		err := errors.New("video not found")
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return database.Video{}, err
+	}
+	canEdit, err := cfg.canEditVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return database.Video{}, err
+	}
+	if !canEdit {
+		err := errors.New("not allowed to edit the video")
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", err)
+		return database.Video{}, err
+	}
+	return video, nil
+}
+
+// setVideoChapters replaces the current chapters of the video. Each
+// chapter holds its own reference to its thumbnail, so thumbnails outlive
+// the suggestions they came from.
+func (cfg *apiConfig) setVideoChapters(video database.Video, current, chapters []database.VideoChapter) error {
+	acquired := []*string{}
+	release := func(thumbnailURLs []*string) {
+		for _, thumbnailURL := range thumbnailURLs {
+			cfg.releaseChapterThumbnail(video.UserID, thumbnailURL)
+		}
+	}
+	for _, chapter := range chapters {
+		if chapter.ThumbnailURL == nil {
+			continue
+		}
+		if err := cfg.acquireLocalAsset(video.UserID, *chapter.ThumbnailURL); err != nil {
+			release(acquired)
+			return err
+		}
+		acquired = append(acquired, chapter.ThumbnailURL)
+	}
+
+	if err := cfg.db.ReplaceVideoChapters(video.ID, chapters); err != nil {
+		release(acquired)
+		return err
+	}
+	for _, chapter := range current {
+		cfg.releaseChapterThumbnail(video.UserID, chapter.ThumbnailURL)
+	}
+	log.Printf("Set %d chapters on video %s", len(chapters), video.ID)
+	return nil
+}
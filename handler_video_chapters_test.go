@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// chapterRequest calls a chapter handler for the video.
+func chapterRequest(t *testing.T, handler http.HandlerFunc, method string, video database.Video, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, method, "/api/videos/"+video.ID.String()+"/chapters", token, body)
+	req.SetPathValue("videoID", video.ID.String())
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// thumbnailReferences returns how many references the local asset behind
+// thumbnailURL has.
+func thumbnailReferences(t *testing.T, cfg *apiConfig, thumbnailURL string) int {
+	t.Helper()
+	assetPath := cfg.localAssetPath(thumbnailURL)
+	asset, found, err := cfg.db.AcquireAssetKey(database.AssetStorageLocal, assetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		return 0
+	}
+	if _, _, err := cfg.db.ReleaseAsset(database.AssetStorageLocal, assetPath); err != nil {
+		t.Fatal(err)
+	}
+	return asset.RefCount - 1
+}
+
+func TestVideoChapters(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	fake.Result = media.FakeVideo(1920, 1080, 60)
+	fake.SceneChanges = []media.SceneChange{
+		{At: 20 * time.Second, Score: 0.8},
+		{At: 25 * time.Second, Score: 0.6},
+		{At: 40 * time.Second, Score: 0.9},
+		{At: 50 * time.Second, Score: 0.3},
+	}
+	content := testVideo("chapters")
+	video := uploadTestVideo(t, cfg, owner, ownerToken, content)
+
+	var suggestions []database.ChapterSuggestion
+	rec := chapterRequest(t, cfg.handlerChapterSuggestionsRetrieve, http.MethodGet, video, ownerToken, nil)
+	decodeResponse(t, rec, http.StatusOK, &suggestions)
+	if len(suggestions) != 3 || suggestions[0].Start != 0 || suggestions[1].Start != 20 || suggestions[2].Start != 40 {
+		t.Fatalf("suggestions = %+v, want starts at 0, 20 and 40", suggestions)
+	}
+	for _, suggestion := range suggestions {
+		if suggestion.ThumbnailURL == nil {
+			t.Fatalf("suggestion at %v has no thumbnail", suggestion.Start)
+		}
+	}
+	// The fake extracts the same frame everywhere, so the suggestions share
+	// one thumbnail file.
+	thumbnailURL := *suggestions[0].ThumbnailURL
+	thumbnailPath := cfg.getAssetDiskPath(cfg.localAssetPath(thumbnailURL))
+	if refs := thumbnailReferences(t, cfg, thumbnailURL); refs != 3 {
+		t.Errorf("thumbnail has %d references, want 3", refs)
+	}
+	rec = chapterRequest(t, cfg.handlerChapterSuggestionsRetrieve, http.MethodGet, video, otherToken, nil)
+	decodeResponse(t, rec, http.StatusForbidden, nil)
+
+	var chapters []database.VideoChapter
+	rec = chapterRequest(t, cfg.handlerChapterSuggestionsAccept, http.MethodPost, video, ownerToken, nil)
+	decodeResponse(t, rec, http.StatusOK, &chapters)
+	if len(chapters) != 3 || chapters[1].Title != "Chapter 2" || chapters[1].Start != 20 || chapters[1].ThumbnailURL == nil {
+		t.Fatalf("accepted chapters = %+v", chapters)
+	}
+	if refs := thumbnailReferences(t, cfg, thumbnailURL); refs != 6 {
+		t.Errorf("thumbnail has %d references after accepting, want 6", refs)
+	}
+	checkStoredVideo(t, cfg, bucket, video, content)
+
+	rec = chapterRequest(t, cfg.handlerVideoChaptersUpdate, http.MethodPut, video, ownerToken, map[string]any{
+		"chapters": []map[string]any{
+			{"start": "0:45", "title": "Outro"},
+			{"start": 0, "title": " Intro ", "thumbnail_url": thumbnailURL},
+		},
+	})
+	decodeResponse(t, rec, http.StatusOK, &chapters)
+	if len(chapters) != 2 || chapters[0].Title != "Intro" || chapters[1].Start != 45 || chapters[1].ThumbnailURL != nil {
+		t.Fatalf("edited chapters = %+v, want Intro at 0 and Outro at 45", chapters)
+	}
+	rec = chapterRequest(t, cfg.handlerVideoChaptersRetrieve, http.MethodGet, video, ownerToken, nil)
+	decodeResponse(t, rec, http.StatusOK, &chapters)
+	if len(chapters) != 2 {
+		t.Errorf("stored chapters = %+v, want the edited ones", chapters)
+	}
+	// The replaced chapters released their thumbnails.
+	if refs := thumbnailReferences(t, cfg, thumbnailURL); refs != 4 {
+		t.Errorf("thumbnail has %d references after editing, want 4", refs)
+	}
+	checkStoredVideo(t, cfg, bucket, video, content)
+
+	for _, chapter := range []map[string]any{
+		{"start": 0, "title": ""},
+		{"start": 60, "title": "After the end"},
+		{"start": 0, "title": "Elsewhere", "thumbnail_url": cfg.getAssetURL("other.jpg")},
+	} {
+		rec = chapterRequest(t, cfg.handlerVideoChaptersUpdate, http.MethodPut, video, ownerToken, map[string]any{
+			"chapters": []map[string]any{chapter},
+		})
+		decodeResponse(t, rec, http.StatusBadRequest, nil)
+	}
+	rec = chapterRequest(t, cfg.handlerVideoChaptersUpdate, http.MethodPut, video, ownerToken, map[string]any{
+		"chapters": []map[string]any{{"start": 10, "title": "One"}, {"start": "0:10", "title": "Two"}},
+	})
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+	rec = chapterRequest(t, cfg.handlerVideoChaptersUpdate, http.MethodPut, video, otherToken, map[string]any{
+		"chapters": []map[string]any{{"start": 0, "title": "Mine"}},
+	})
+	decodeResponse(t, rec, http.StatusForbidden, nil)
+
+	// New suggestions replace the old ones and release their thumbnails,
+	// leaving the chapters alone.
+	fake.SceneChanges = nil
+	video = reuploadTestVideo(t, cfg, video, ownerToken, content)
+	rec = chapterRequest(t, cfg.handlerChapterSuggestionsRetrieve, http.MethodGet, video, ownerToken, nil)
+	decodeResponse(t, rec, http.StatusOK, &suggestions)
+	if len(suggestions) != 1 {
+		t.Errorf("suggestions after re-uploading = %+v, want only the start", suggestions)
+	}
+	if refs := thumbnailReferences(t, cfg, thumbnailURL); refs != 2 {
+		t.Errorf("thumbnail has %d references after re-uploading, want 2", refs)
+	}
+	checkStoredVideo(t, cfg, bucket, video, content)
+
+	req := newJSONRequest(t, http.MethodDelete, "/api/videos/"+video.ID.String(), ownerToken, nil)
+	req.SetPathValue("videoID", video.ID.String())
+	rec = httptest.NewRecorder()
+	cfg.handlerVideoMetaDelete(rec, req)
+	decodeResponse(t, rec, http.StatusNoContent, nil)
+	if refs := thumbnailReferences(t, cfg, thumbnailURL); refs != 0 {
+		t.Errorf("thumbnail has %d references after deleting the video, want 0", refs)
+	}
+	if _, err := os.Stat(thumbnailPath); !os.IsNotExist(err) {
+		t.Errorf("thumbnail of a deleted video is still stored: %v", err)
+	}
+}
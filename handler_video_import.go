@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fetch"
+	"github.com/google/uuid"
+)
+
+// parseImportAllowlist reads comma separated CIDRs or single IPs that URL
+// imports may connect to even though they are private.
+func parseImportAllowlist(raw string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// handlerVideoImport downloads the video file from a URL instead of
+// receiving it in the request, then processes it like an upload.
+func (cfg *apiConfig) handlerVideoImport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	videoData, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if videoData.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(videoData, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	policy, _, err := cfg.userQuota(videoData.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+		return
+	}
+
+	tempVideoFile, err := os.CreateTemp("", "tubely-import-*")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file for video", err)
+		return
+	}
+	defer os.Remove(tempVideoFile.Name())
+	defer tempVideoFile.Close()
+
+	if _, err := cfg.importer.Download(r.Context(), params.URL, tempVideoFile, policy.uploadLimit()); err != nil {
+		switch {
+		case errors.Is(err, fetch.ErrUnsupportedURL):
+			respondWithError(w, http.StatusBadRequest, "URL must be an http or https URL", err)
+		case errors.Is(err, fetch.ErrBlockedAddress):
+			respondWithError(w, http.StatusBadRequest, "URL points to an address that can't be imported from", err)
+		case errors.Is(err, fetch.ErrTooManyRedirects):
+			respondWithError(w, http.StatusBadRequest, "URL redirects too many times", err)
+		case errors.Is(err, fetch.ErrTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", err)
+		default:
+			respondWithError(w, http.StatusBadGateway, "Couldn't download video", err)
+		}
+		return
+	}
+	hasher, err := hashFile(tempVideoFile.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading downloaded video", err)
+		return
+	}
+
+	videoData, err = cfg.ingestVideo(r.Context(), videoData, userID, tempVideoFile, hasher)
+	if err != nil {
+		respondWithIngestError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, videoData)
+}
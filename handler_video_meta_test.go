@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func getVideo(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodGet, "/api/videos/"+videoID.String(), token, nil)
+	req.SetPathValue("videoID", videoID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoGet(rec, req)
+	return rec
+}
+
+func TestVideoGetRequiresAccess(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	member, memberToken := createTestUser(t, cfg, "member@example.com")
+	_, outsiderToken := createTestUser(t, cfg, "outsider@example.com")
+
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.AddOrganizationMember(org.ID, member.ID, database.OrgRoleMember); err != nil {
+		t.Fatal(err)
+	}
+	orgVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: owner.ID, OrgID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	personalVideo, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "mine", UserID: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got database.Video
+	decodeResponse(t, getVideo(t, cfg, orgVideo.ID, memberToken), http.StatusOK, &got)
+	if got.ID != orgVideo.ID {
+		t.Errorf("got video %s, want %s", got.ID, orgVideo.ID)
+	}
+	decodeResponse(t, getVideo(t, cfg, personalVideo.ID, ownerToken), http.StatusOK, nil)
+
+	decodeResponse(t, getVideo(t, cfg, orgVideo.ID, outsiderToken), http.StatusForbidden, nil)
+	decodeResponse(t, getVideo(t, cfg, personalVideo.ID, memberToken), http.StatusForbidden, nil)
+	decodeResponse(t, getVideo(t, cfg, orgVideo.ID, ""), http.StatusUnauthorized, nil)
+	decodeResponse(t, getVideo(t, cfg, uuid.New(), ownerToken), http.StatusNotFound, nil)
+}
+
+func TestEditingOthersVideosIsForbidden(t *testing.T) {
+	cfg := newTestConfig(t)
+	owner, _ := createTestUser(t, cfg, "owner@example.com")
+	viewer, viewerToken := createTestUser(t, cfg, "viewer@example.com")
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.AddOrganizationMember(org.ID, viewer.ID, database.OrgRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "shared", UserID: owner.ID, OrgID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newJSONRequest(t, http.MethodPost, "/api/video_upload/"+video.ID.String(), viewerToken, nil)
+	req.SetPathValue("videoID", video.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, req)
+	decodeResponse(t, rec, http.StatusForbidden, nil)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// byteRange is an inclusive range of bytes within an object.
+type byteRange struct {
+	start, end int64
+}
+
+func (br byteRange) length() int64 {
+	return br.end - br.start + 1
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, size)
+}
+
+func (br byteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", br.start, br.end)
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRange parses a Range header for an object of the given size. Only a
+// single byte range is supported; for anything else ok is false and the
+// whole object should be served, which RFC 9110 permits.
+func parseRange(header string, size int64) (br byteRange, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errRangeNotSatisfiable
+		}
+		return byteRange{start: max(size-n, 0), end: size - 1}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	if start >= size {
+		return byteRange{}, false, errRangeNotSatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	return byteRange{start: start, end: end}, true, nil
+}
+
+// etagMatches reports whether any entity tag in an If-Match or If-None-Match
+// header matches etag. Weak comparison is used unless strong is set.
+func etagMatches(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range request may be honoured given the
+// If-Range header, which holds either an entity tag or a date.
+func ifRangeMatches(header, etag string, lastModified time.Time) bool {
+	if header == "" {
+		return true
+	}
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return !strings.HasPrefix(header, "W/") && header == etag
+	}
+	t, err := http.ParseTime(header)
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(t)
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag, false)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(header)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+func isS3NotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	return errors.As(err, &notFound) || errors.As(err, &noSuchKey)
+}
+
+func isS3PreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+// streamTokenTTL bounds how long a stream URL works. Players fetch ranges
+// while the video plays, so it has to outlast a viewing.
+const streamTokenTTL = time.Hour * 4
+
+// handlerVideoStreamURL returns a URL that plays the video for a few hours.
+// Browsers can't set headers on <video> requests, so the URL carries a token
+// that is only good for streaming this video.
+func (cfg *apiConfig) handlerVideoStreamURL(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canView, err := cfg.canViewVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(streamTokenTTL)
+	streamToken, err := auth.MakeStreamJWT(userID, video.ID, cfg.jwtKeys, streamTokenTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create stream token", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       fmt.Sprintf("/api/videos/%s/stream?stream_token=%s", video.ID, url.QueryEscape(streamToken)),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlerVideoStream proxies a video file from the bucket so that it can be
+// played, including seeking, without making the bucket public. Requests are
+// authorized by an access token in the Authorization header or by a stream
+// token from handlerVideoStreamURL in the stream_token query parameter. The
+// rendition parameter selects one of the video's renditions instead of the
+// video file; the unwatermarked "original" is only available to users who
+// can edit it.
+func (cfg *apiConfig) handlerVideoStream(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	var userID uuid.UUID
+	if streamToken := r.URL.Query().Get("stream_token"); streamToken != "" {
+		userID, err = auth.ValidateStreamJWT(streamToken, cfg.jwtKeys, videoID)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate stream token", err)
+			return
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err = auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canView, err := cfg.canViewVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+	objectURL := video.VideoURL
+	if rendition := r.URL.Query().Get("rendition"); rendition == "original" {
+		canEdit, err := cfg.canEditVideo(video, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+			return
+		}
+		if !canEdit {
+			respondWithError(w, http.StatusForbidden, "Only editors can get the original", nil)
+			return
+		}
+		objectURL = video.OriginalVideoURL
+	} else if rendition != "" {
+		renditionURL, ok := videoRendition(video.VideoRenditions, rendition)
+		if !ok {
+			respondWithError(w, http.StatusBadRequest, "Unknown rendition", nil)
+			return
+		}
+		objectURL = renditionURL
+	}
+	if objectURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no file", nil)
+		return
+	}
+	key := cfg.s3KeyFromURL(*objectURL)
+	if key == "" {
+		respondWithError(w, http.StatusNotFound, "Video isn't stored in the bucket", nil)
+		return
+	}
+
+	head, err := cfg.s3Client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			respondWithError(w, http.StatusNotFound, "Video file not found", err)
+			return
+		}
+		respondWithError(w, http.StatusBadGateway, "Couldn't get video file", err)
+		return
+	}
+
+	var size int64
+	if head.ContentLength != nil {
+		size = *head.ContentLength
+	}
+	var etag string
+	if head.ETag != nil {
+		etag = *head.ETag
+	}
+	var lastModified time.Time
+	if head.LastModified != nil {
+		lastModified = *head.LastModified
+	}
+	contentType := "application/octet-stream"
+	if head.ContentType != nil {
+		contentType = *head.ContentType
+	}
+
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Cache-Control", "private, no-cache")
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, etag, true) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video file has changed", nil)
+		return
+	}
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	status := http.StatusOK
+	br := byteRange{start: 0, end: size - 1}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && ifRangeMatches(r.Header.Get("If-Range"), etag, lastModified) {
+		parsed, ok, err := parseRange(rangeHeader, size)
+		if err != nil {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable", err)
+			return
+		}
+		if ok {
+			br = parsed
+			status = http.StatusPartialContent
+			header.Set("Content-Range", br.contentRange(size))
+		}
+	}
+
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.FormatInt(max(br.length(), 0), 10))
+	if r.Method == http.MethodHead || size == 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	}
+	if status == http.StatusPartialContent {
+		rangeSpec := br.header()
+		input.Range = &rangeSpec
+	}
+	// Pin the download to the object we just described, so the headers
+	// can't disagree with the body if the file is replaced in between.
+	if etag != "" {
+		input.IfMatch = &etag
+	}
+	object, err := cfg.s3Client.GetObject(r.Context(), input)
+	if err != nil {
+		header.Del("Content-Range")
+		header.Del("Content-Length")
+		if isS3PreconditionFailed(err) {
+			header.Set("Retry-After", "1")
+			respondWithError(w, http.StatusServiceUnavailable, "Video file changed, try again", err)
+			return
+		}
+		if isS3NotFound(err) {
+			respondWithError(w, http.StatusNotFound, "Video file not found", err)
+			return
+		}
+		respondWithError(w, http.StatusBadGateway, "Couldn't get video file", err)
+		return
+	}
+	defer object.Body.Close()
+
+	w.WriteHeader(status)
+	if _, err := io.CopyN(w, object.Body, br.length()); err != nil && r.Context().Err() == nil {
+		log.Printf("Couldn't stream video %s: %v", videoID, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// streamVideo requests the video's stream through the mux, so path values
+// are set as in production.
+func streamVideo(cfg *apiConfig, target string, header http.Header) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream_url", cfg.handlerVideoStreamURL)
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestVideoStreamURL(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, token := createTestUser(t, cfg, "viewer@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var streamURL struct {
+		URL string `json:"url"`
+	}
+	rec := streamVideo(cfg, "/api/videos/"+video.ID.String()+"/stream_url", bearer(token))
+	decodeResponse(t, rec, http.StatusOK, &streamURL)
+
+	// The video has no file yet, so getting past authorization ends in 404.
+	rec = streamVideo(cfg, streamURL.URL, nil)
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+	if rec.Body.String() != `{"error":"Video has no file"}` {
+		t.Errorf("stream URL response = %s", rec.Body.String())
+	}
+
+	t.Run("access token in the URL", func(t *testing.T) {
+		rec := streamVideo(cfg, "/api/videos/"+video.ID.String()+"/stream?access_token="+url.QueryEscape(token), nil)
+		decodeResponse(t, rec, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("access token as stream token", func(t *testing.T) {
+		rec := streamVideo(cfg, "/api/videos/"+video.ID.String()+"/stream?stream_token="+url.QueryEscape(token), nil)
+		decodeResponse(t, rec, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("stream token for another video", func(t *testing.T) {
+		other, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "other", UserID: user.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamToken, err := auth.MakeStreamJWT(user.ID, video.ID, cfg.jwtKeys, streamTokenTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := streamVideo(cfg, "/api/videos/"+other.ID.String()+"/stream?stream_token="+url.QueryEscape(streamToken), nil)
+		decodeResponse(t, rec, http.StatusUnauthorized, nil)
+	})
+
+	t.Run("stream token as access token", func(t *testing.T) {
+		streamToken, err := auth.MakeStreamJWT(user.ID, video.ID, cfg.jwtKeys, streamTokenTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerVideosRetrieve(rec, newJSONRequest(t, http.MethodGet, "/api/videos", streamToken, nil))
+		decodeResponse(t, rec, http.StatusUnauthorized, nil)
+	})
+}
+
+func TestVideoStreamMissingVideo(t *testing.T) {
+	cfg := newTestConfig(t)
+	_, token := createTestUser(t, cfg, "viewer@example.com")
+	missing := uuid.New().String()
+
+	rec := streamVideo(cfg, "/api/videos/"+missing+"/stream", bearer(token))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+
+	rec = streamVideo(cfg, "/api/videos/"+missing+"/stream_url", bearer(token))
+	decodeResponse(t, rec, http.StatusNotFound, nil)
+}
+
+func TestVideoJSONHidesBucketURLs(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, token := createTestUser(t, cfg, "viewer@example.com")
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := "https://cdn.example.com/landscape/video.mp4"
+	audioURL := "https://cdn.example.com/audio/video.m4a"
+	video.VideoURL = &videoURL
+	video.AudioAACURL = &audioURL
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := getVideo(t, cfg, video.ID, token)
+	if strings.Contains(rec.Body.String(), "cdn.example.com") {
+		t.Errorf("video JSON exposes the bucket: %s", rec.Body.String())
+	}
+	var got map[string]any
+	decodeResponse(t, rec, http.StatusOK, &got)
+	streamPath := "/api/videos/" + video.ID.String() + "/stream"
+	if got["video_url"] != streamPath || got["audio_aac_url"] != streamPath+"?rendition=audio_aac" {
+		t.Errorf("video_url = %v, audio_aac_url = %v; want stream paths", got["video_url"], got["audio_aac_url"])
+	}
+	if got["audio_opus_url"] != nil {
+		t.Errorf("audio_opus_url = %v, want null", got["audio_opus_url"])
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// trimKeyframeTolerance is how far before the requested start a keyframe
+// may be for the cut to copy streams instead of re-encoding. The clip then
+// starts up to this much early.
+const trimKeyframeTolerance = 100 * time.Millisecond
+
+// parseTimestamp parses "90", "1:30" or "00:01:30.5" into an offset.
+func parseTimestamp(timestamp string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(timestamp), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+	seconds := 0.0
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 || (i > 0 && value >= 60) {
+			return 0, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		seconds = seconds*60 + value
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// handlerVideoTrim cuts the part between start and end out of a video's
+// file. By default the result becomes a new clip linked to the source
+// video; with replace it becomes the video's own file.
+func (cfg *apiConfig) handlerVideoTrim(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Start   string `json:"start"`
+		End     string `json:"end"`
+		Replace bool   `json:"replace"`
+		Title   string `json:"title"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	start, err := parseTimestamp(params.Start)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid start", err)
+		return
+	}
+	end, err := parseTimestamp(params.End)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid end", err)
+		return
+	}
+	if end <= start {
+		respondWithError(w, http.StatusBadRequest, "End must be after start", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no file to trim", nil)
+		return
+	}
+	// Watermarked videos are cut from the original, and the result gets
+	// the current watermark of whoever the trimmed video belongs to.
+	sourceURL := video.VideoURL
+	if video.OriginalVideoURL != nil {
+		sourceURL = video.OriginalVideoURL
+	}
+	sourceKey := cfg.s3KeyFromURL(*sourceURL)
+	if sourceKey == "" {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in the bucket", nil)
+		return
+	}
+
+	sourcePath, err := cfg.downloadS3Object(r.Context(), sourceKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	defer os.Remove(sourcePath)
+
+	probe, err := cfg.media.Probe(r.Context(), sourcePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video file", err)
+		return
+	}
+	duration := time.Duration(probe.Duration * float64(time.Second))
+	if start >= duration {
+		respondWithError(w, http.StatusBadRequest, "Start is after the end of the video", nil)
+		return
+	}
+	end = min(end, duration)
+	dimensions, err := videoDimensions(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video file", err)
+		return
+	}
+
+	keyframes, err := cfg.media.Keyframes(r.Context(), sourcePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video file", err)
+		return
+	}
+	streamCopy := false
+	for _, keyframe := range keyframes {
+		if keyframe <= start && start-keyframe <= trimKeyframeTolerance {
+			streamCopy = true
+			break
+		}
+	}
+
+	trimmed, err := os.CreateTemp("", "tubely-trim-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file for video", err)
+		return
+	}
+	trimmed.Close()
+	defer os.Remove(trimmed.Name())
+	if err := cfg.media.Trim(r.Context(), sourcePath, trimmed.Name(), start, end, streamCopy); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't trim video", err)
+		return
+	}
+
+	// A replaced file is charged to the video's owner like an upload; a new
+	// clip belongs to whoever made it.
+	target := video
+	status := http.StatusOK
+	if !params.Replace {
+		title := params.Title
+		if title == "" {
+			title = video.Title + " (clip)"
+		}
+		target, err = cfg.db.CreateVideo(database.CreateVideoParams{
+			Title:       title,
+			Description: video.Description,
+			UserID:      userID,
+			OrgID:       video.OrgID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+			return
+		}
+		target.SourceVideoID = &video.ID
+		status = http.StatusCreated
+	}
+	discardClip := func() {
+		if params.Replace {
+			return
+		}
+		if err := cfg.db.DeleteVideo(target.ID); err != nil {
+			log.Printf("Couldn't delete clip %s: %v", target.ID, err)
+		}
+	}
+
+	trimmedURL, err := cfg.storeS3File(r.Context(), target.UserID, trimmed.Name(), aspectKeyPrefix(dimensions.AspectClass), media.ContainerMP4.MediaType())
+	if err != nil {
+		discardClip()
+		if isQuotaError(err) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Error storing video file", err)
+		return
+	}
+	trimmedProbe := probe
+	trimmedProbe.Duration = (end - start).Seconds()
+	target, err = cfg.commitVideoFile(r.Context(), target, userID, storedVideoFile{
+		url:        trimmedURL,
+		path:       trimmed.Name(),
+		probe:      trimmedProbe,
+		dimensions: dimensions,
+	})
+	if err != nil {
+		discardClip()
+		respondWithIngestError(w, err)
+		return
+	}
+	respondWithJSON(w, status, target)
+}
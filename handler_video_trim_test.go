@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func trimVideo(t *testing.T, cfg *apiConfig, video database.Video, token string, params map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodPost, "/api/videos/"+video.ID.String()+"/trim", token, params)
+	req.SetPathValue("videoID", video.ID.String())
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoTrim(rec, req)
+	return rec
+}
+
+func TestVideoTrimClip(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "editor@example.com")
+	video := uploadTestVideo(t, cfg, user, token, testVideo("source"))
+	fake.KeyframeTimes = []time.Duration{0, 2 * time.Second}
+
+	var clip database.Video
+	rec := trimVideo(t, cfg, video, token, map[string]any{"start": "2.05", "end": "5"})
+	decodeResponse(t, rec, http.StatusCreated, &clip)
+	if clip.SourceVideoID == nil || *clip.SourceVideoID != video.ID || clip.Title != "clip (clip)" {
+		t.Errorf("clip = %q from %v, want a clip of %s", clip.Title, clip.SourceVideoID, video.ID)
+	}
+	// The fake copies the input, so the clip shares the source's file.
+	checkStoredVideo(t, cfg, bucket, clip, testVideo("source"))
+	if !called(fake, "trim_copy") {
+		t.Errorf("media calls = %v, want a stream copy from the keyframe", fake.Calls)
+	}
+
+	fake.Calls = nil
+	rec = trimVideo(t, cfg, video, token, map[string]any{"start": "3", "end": "5"})
+	decodeResponse(t, rec, http.StatusCreated, nil)
+	if !called(fake, "trim_encode") {
+		t.Errorf("media calls = %v, want a re-encode away from keyframes", fake.Calls)
+	}
+}
+
+func TestVideoTrimReplace(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	video := uploadTestVideo(t, cfg, owner, ownerToken, testVideo("source"))
+
+	fake.Result = media.FakeVideo(1920, 1080, 4)
+	var replaced database.Video
+	rec := trimVideo(t, cfg, video, ownerToken, map[string]any{"start": "0", "end": "3", "replace": true})
+	decodeResponse(t, rec, http.StatusOK, &replaced)
+	if replaced.ID != video.ID || replaced.Version != video.Version+1 {
+		t.Errorf("replaced video %s at version %d, want %s at version %d", replaced.ID, replaced.Version, video.ID, video.Version+1)
+	}
+	checkStoredVideo(t, cfg, bucket, replaced, testVideo("source"))
+
+	rec = trimVideo(t, cfg, video, ownerToken, map[string]any{"start": "5", "end": "8"})
+	decodeResponse(t, rec, http.StatusBadRequest, nil)
+
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	rec = trimVideo(t, cfg, video, otherToken, map[string]any{"start": "0", "end": "3"})
+	decodeResponse(t, rec, http.StatusForbidden, nil)
+}
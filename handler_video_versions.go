@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoVersionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canView, err := cfg.canViewVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusForbidden, "You can't view this video", nil)
+		return
+	}
+
+	versions, err := cfg.db.GetVideoVersions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video versions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, versions)
+}
+
+// handlerVideoVersionRollback makes an earlier version the video's file
+// again. The previews, renditions and chapter suggestions are made anew
+// from it; the versions in between are kept.
+func (cfg *apiConfig) handlerVideoVersionRollback(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+	versionNumber, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid version", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	canEdit, err := cfg.canEditVideo(video, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video permissions", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	version, err := cfg.db.GetVideoVersion(videoID, versionNumber)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video version", err)
+		return
+	}
+	if version.Version == 0 {
+		respondWithError(w, http.StatusNotFound, "Version not found", nil)
+		return
+	}
+	if version.Version == video.Version {
+		respondWithJSON(w, http.StatusOK, video)
+		return
+	}
+	key := cfg.s3KeyFromURL(version.VideoURL)
+	if key == "" {
+		respondWithError(w, http.StatusConflict, "Version isn't stored in the bucket", nil)
+		return
+	}
+
+	versionPath, err := cfg.downloadS3Object(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	defer os.Remove(versionPath)
+	probe, err := cfg.media.Probe(r.Context(), versionPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video file", err)
+		return
+	}
+	dimensions, err := videoDimensions(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't inspect video file", err)
+		return
+	}
+
+	ownerID := video.UserID
+	previous := video
+	video.VideoURL = &version.VideoURL
+	video.OriginalVideoURL = version.OriginalVideoURL
+	video.Version = version.Version
+	video.VideoDimensions = dimensions
+	video.PreviewURL, video.PreviewVideoURL = cfg.generatePreviews(r.Context(), ownerID, versionPath, probe.Duration)
+	video.VideoRenditions = cfg.generateRenditions(r.Context(), ownerID, versionPath, probe)
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		cfg.releasePreviews(ownerID, video.PreviewURL, video.PreviewVideoURL)
+		cfg.releaseRenditions(r.Context(), ownerID, video.VideoRenditions)
+		respondWithError(w, http.StatusInternalServerError, "Error updating video information", err)
+		return
+	}
+	cfg.releasePreviews(ownerID, previous.PreviewURL, previous.PreviewVideoURL)
+	cfg.releaseRenditions(r.Context(), ownerID, previous.VideoRenditions)
+	suggestions := cfg.generateChapterSuggestions(r.Context(), ownerID, versionPath, probe.Duration)
+	cfg.replaceChapterSuggestions(videoID, ownerID, suggestions)
+
+	respondWithJSON(w, http.StatusOK, video)
+}
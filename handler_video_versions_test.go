@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// reuploadTestVideo uploads content as the next version of the video.
+func reuploadTestVideo(t *testing.T, cfg *apiConfig, video database.Video, token string, content []byte) database.Video {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, newUploadRequest(t, video.ID, token, content))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func rollbackVideo(t *testing.T, cfg *apiConfig, video database.Video, token string, version int) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodPost, fmt.Sprintf("/api/videos/%s/versions/%d/rollback", video.ID, version), token, nil)
+	req.SetPathValue("videoID", video.ID.String())
+	req.SetPathValue("version", fmt.Sprint(version))
+	rec := httptest.NewRecorder()
+	cfg.handlerVideoVersionRollback(rec, req)
+	return rec
+}
+
+// versionNumbers returns the video's versions, newest first.
+func versionNumbers(t *testing.T, cfg *apiConfig, video database.Video) []int {
+	t.Helper()
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers := []int{}
+	for _, version := range versions {
+		numbers = append(numbers, version.Version)
+	}
+	return numbers
+}
+
+func TestVideoVersionRollback(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	first := uploadTestVideo(t, cfg, user, token, testVideo("first"))
+	first, err := cfg.db.GetVideo(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := reuploadTestVideo(t, cfg, first, token, testVideo("second"))
+	if second.Version != first.Version+1 || *second.VideoURL == *first.VideoURL {
+		t.Fatalf("second upload is version %d at %s", second.Version, *second.VideoURL)
+	}
+
+	decodeResponse(t, rollbackVideo(t, cfg, second, token, first.Version), http.StatusOK, nil)
+	rolledBack, err := cfg.db.GetVideo(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Version != first.Version || *rolledBack.VideoURL != *first.VideoURL {
+		t.Errorf("video is version %d at %s, want version %d at %s", rolledBack.Version, *rolledBack.VideoURL, first.Version, *first.VideoURL)
+	}
+	// The renditions are made from the restored file again and those of the
+	// replaced file are released. The fake's audio is the same for both, so
+	// only the restored video may still hold a reference.
+	if *rolledBack.AudioAACURL != *first.AudioAACURL {
+		t.Errorf("audio rendition = %s, want %s", *rolledBack.AudioAACURL, *first.AudioAACURL)
+	}
+	checkStoredVideo(t, cfg, bucket, rolledBack, testVideo("first"))
+	if err := cfg.releaseS3Object(context.Background(), user.ID, *rolledBack.AudioAACURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bucket.object(cfg.s3KeyFromURL(*second.AudioAACURL)); ok {
+		t.Error("audio rendition of the replaced file is still referenced")
+	}
+	if _, ok := bucket.object(cfg.s3KeyFromURL(*second.VideoURL)); !ok {
+		t.Error("file of the replaced version was deleted")
+	}
+
+	decodeResponse(t, rollbackVideo(t, cfg, second, token, 7), http.StatusNotFound, nil)
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	decodeResponse(t, rollbackVideo(t, cfg, second, otherToken, second.Version), http.StatusForbidden, nil)
+}
+
+func TestPruneVideoVersionsKeep(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	cfg.versionRetention = versionRetention{Keep: 2}
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video := uploadTestVideo(t, cfg, user, token, testVideo("1"))
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstURL := *video.VideoURL
+	for _, body := range []string{"2", "3"} {
+		video = reuploadTestVideo(t, cfg, video, token, testVideo(body))
+	}
+	if got := versionNumbers(t, cfg, video); fmt.Sprint(got) != "[3 2]" {
+		t.Errorf("versions = %v, want [3 2]", got)
+	}
+	if _, ok := bucket.object(cfg.s3KeyFromURL(firstURL)); ok {
+		t.Error("file of the pruned version is still stored")
+	}
+	checkStoredVideo(t, cfg, bucket, video, testVideo("3"))
+
+	// After a rollback the current version is older than the versions the
+	// policy keeps, but it is never pruned itself.
+	cfg.versionRetention = versionRetention{Keep: 1}
+	decodeResponse(t, rollbackVideo(t, cfg, video, token, 2), http.StatusOK, nil)
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.pruneVideoVersions(context.Background(), video)
+	if got := versionNumbers(t, cfg, video); fmt.Sprint(got) != "[3 2]" {
+		t.Errorf("versions = %v, want [3 2]", got)
+	}
+	checkStoredVideo(t, cfg, bucket, video, testVideo("2"))
+}
+
+func TestPruneVideoVersionsMaxAge(t *testing.T) {
+	cfg, bucket, _ := newMediaTestConfig(t)
+	cfg.versionRetention = versionRetention{MaxAge: time.Nanosecond}
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	video := uploadTestVideo(t, cfg, user, token, testVideo("1"))
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video = reuploadTestVideo(t, cfg, video, token, testVideo("2"))
+
+	// Every version is too old by now, but the current one is kept.
+	if got := versionNumbers(t, cfg, video); fmt.Sprint(got) != "[2]" {
+		t.Errorf("versions = %v, want [2]", got)
+	}
+	checkStoredVideo(t, cfg, bucket, video, testVideo("2"))
+}
+
+func TestVersionRetentionPrunable(t *testing.T) {
+	now := time.Now()
+	old := database.VideoVersion{CreatedAt: now.Add(-48 * time.Hour)}
+	recent := database.VideoVersion{CreatedAt: now.Add(-time.Hour)}
+	tests := []struct {
+		retention versionRetention
+		version   database.VideoVersion
+		index     int
+		want      bool
+	}{
+		{versionRetention{}, old, 100, false},
+		{versionRetention{Keep: 5}, old, 4, false},
+		{versionRetention{Keep: 5}, recent, 5, true},
+		{versionRetention{MaxAge: 24 * time.Hour}, recent, 100, false},
+		{versionRetention{MaxAge: 24 * time.Hour}, old, 0, true},
+		{versionRetention{Keep: 5, MaxAge: 24 * time.Hour}, old, 1, true},
+	}
+	for _, tt := range tests {
+		if got := tt.retention.prunable(tt.version, tt.index, now); got != tt.want {
+			t.Errorf("%+v.prunable(%v old, index %d) = %v, want %v", tt.retention, now.Sub(tt.version.CreatedAt), tt.index, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// defaultWatermark is used for the settings left out of a PUT.
+var defaultWatermark = media.Watermark{
+	Position: media.WatermarkBottomRight,
+	Opacity:  0.8,
+	Scale:    0.15,
+}
+
+// watermarkScope resolves whose watermark a request is about: the
+// organization in the path, or the user making the request. The user must
+// be a member of the organization, and with manage also an owner or admin.
+// On failure the error response has been written and ok is false.
+func (cfg *apiConfig) watermarkScope(w http.ResponseWriter, r *http.Request, manage bool) (scope database.WatermarkScope, scopeID, userID uuid.UUID, ok bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+	userID, err = auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+
+	if r.PathValue("orgID") == "" {
+		return database.WatermarkScopeUser, userID, userID, true
+	}
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check organization membership", err)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+	if manage && !role.CanManage() {
+		respondWithError(w, http.StatusForbidden, "Only owners and admins can change the watermark", nil)
+		return "", uuid.Nil, uuid.Nil, false
+	}
+	return database.WatermarkScopeOrg, orgID, userID, true
+}
+
+func (cfg *apiConfig) handlerWatermarkGet(w http.ResponseWriter, r *http.Request) {
+	scope, scopeID, _, ok := cfg.watermarkScope(w, r, false)
+	if !ok {
+		return
+	}
+	watermark, err := cfg.db.GetWatermark(scope, scopeID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	if watermark.ImageURL == "" {
+		respondWithError(w, http.StatusNotFound, "No watermark set", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, watermark)
+}
+
+// handlerWatermarkSet takes a multipart form with the PNG or JPEG image and
+// optional position, opacity and scale fields. Without an image, only the
+// settings of the existing watermark are changed. Videos that are already
+// uploaded keep the watermark they were made with.
+func (cfg *apiConfig) handlerWatermarkSet(w http.ResponseWriter, r *http.Request) {
+	const maxImageSize = 5 << 20
+
+	scope, scopeID, userID, ok := cfg.watermarkScope(w, r, true)
+	if !ok {
+		return
+	}
+	previous, err := cfg.db.GetWatermark(scope, scopeID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageSize+multipartOverhead)
+	if err := r.ParseMultipartForm(maxImageSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Image is too large", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+
+	settings := defaultWatermark
+	if previous.ImageURL != "" {
+		settings = media.Watermark{
+			Position: media.WatermarkPosition(previous.Position),
+			Opacity:  previous.Opacity,
+			Scale:    previous.Scale,
+		}
+	}
+	if position := r.FormValue("position"); position != "" {
+		settings.Position = media.WatermarkPosition(position)
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"opacity", &settings.Opacity},
+		{"scale", &settings.Scale},
+	} {
+		value := r.FormValue(field.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid "+field.name, err)
+			return
+		}
+		*field.value = parsed
+	}
+	if err := settings.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	watermark := previous
+	watermark.Scope = scope
+	watermark.ScopeID = scopeID
+	watermark.Position = string(settings.Position)
+	watermark.Opacity = settings.Opacity
+	watermark.Scale = settings.Scale
+
+	imageFile, header, err := r.FormFile("image")
+	newImage := err == nil
+	switch {
+	case errors.Is(err, http.ErrMissingFile):
+		if previous.ImageURL == "" {
+			respondWithError(w, http.StatusBadRequest, "Image is required", err)
+			return
+		}
+	case err != nil:
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	default:
+		defer imageFile.Close()
+		mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+		if err != nil || (mediaType != "image/png" && mediaType != "image/jpeg") {
+			respondWithError(w, http.StatusBadRequest, "Invalid File Type. Expected image/jpeg or image/png", err)
+			return
+		}
+
+		tempFile, err := os.CreateTemp(cfg.assetsRoot, ".upload-*")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating image file on disk", err)
+			return
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		hasher := newContentHasher()
+		if _, err := io.Copy(tempFile, hasher.reader(imageFile)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error copying image to disk", err)
+			return
+		}
+		if err := tempFile.Close(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error copying image to disk", err)
+			return
+		}
+
+		// The image is charged to whoever uploads it, which for organization
+		// watermarks is the admin making the change.
+		policy, used, err := cfg.userQuota(userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get storage quota", err)
+			return
+		}
+		if err := policy.checkUpload(used, hasher.size); err != nil {
+			respondWithError(w, http.StatusRequestEntityTooLarge, err.Error(), err)
+			return
+		}
+		assetPath, err := cfg.storeLocalAsset(userID, tempFile.Name(), hasher, mediaType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error storing image", err)
+			return
+		}
+		watermark.ImageURL = cfg.getAssetURL(assetPath)
+		watermark.UpdatedBy = userID
+	}
+
+	saved, err := cfg.db.SetWatermark(watermark)
+	if err != nil {
+		if newImage {
+			cfg.releaseWatermarkImage(watermark)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save watermark", err)
+		return
+	}
+	if newImage && previous.ImageURL != "" {
+		cfg.releaseWatermarkImage(previous)
+	}
+
+	respondWithJSON(w, http.StatusOK, saved)
+}
+
+func (cfg *apiConfig) handlerWatermarkDelete(w http.ResponseWriter, r *http.Request) {
+	scope, scopeID, _, ok := cfg.watermarkScope(w, r, true)
+	if !ok {
+		return
+	}
+	watermark, err := cfg.db.GetWatermark(scope, scopeID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get watermark", err)
+		return
+	}
+	if watermark.ImageURL == "" {
+		respondWithError(w, http.StatusNotFound, "No watermark set", nil)
+		return
+	}
+	if err := cfg.db.DeleteWatermark(scope, scopeID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete watermark", err)
+		return
+	}
+	cfg.releaseWatermarkImage(watermark)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseWatermarkImage drops the reference held by the watermark's image,
+// refunding whoever uploaded it.
+func (cfg *apiConfig) releaseWatermarkImage(watermark database.Watermark) {
+	if err := cfg.releaseLocalAsset(watermark.UpdatedBy, watermark.ImageURL); err != nil {
+		log.Printf("Couldn't release watermark image %s: %v", watermark.ImageURL, err)
+	}
+}
+
+// videoWatermark returns the watermark burned onto the video: the
+// organization's for organization videos, otherwise the owner's. The
+// ImageURL is empty if there is none.
+func (cfg *apiConfig) videoWatermark(video database.Video) (database.Watermark, error) {
+	if video.OrgID != nil {
+		return cfg.db.GetWatermark(database.WatermarkScopeOrg, *video.OrgID)
+	}
+	return cfg.db.GetWatermark(database.WatermarkScopeUser, video.UserID)
+}
+
+// applyWatermark burns the watermark onto the video at sourcePath and
+// returns the path of the result, a temp file the caller must remove.
+func (cfg *apiConfig) applyWatermark(ctx context.Context, watermark database.Watermark, sourcePath string) (string, error) {
+	tempFile, err := os.CreateTemp("", "tubely-watermark-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	tempFile.Close()
+
+	err = cfg.media.TranscodeWithWatermark(ctx, sourcePath, tempFile.Name(), media.Watermark{
+		ImagePath: cfg.getAssetDiskPath(cfg.localAssetPath(watermark.ImageURL)),
+		Position:  media.WatermarkPosition(watermark.Position),
+		Opacity:   watermark.Opacity,
+		Scale:     watermark.Scale,
+	})
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
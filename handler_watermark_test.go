@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// setWatermark sends the watermark form, for the organization unless orgID
+// is nil. An empty image leaves the image out.
+func setWatermark(t *testing.T, cfg *apiConfig, token string, orgID *uuid.UUID, image []byte, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if image != nil {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="image"; filename="logo.png"`)
+		header.Set("Content-Type", "image/png")
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/api/users/me/watermark"
+	if orgID != nil {
+		target = "/api/organizations/" + orgID.String() + "/watermark"
+	}
+	req := httptest.NewRequest(http.MethodPut, target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	if orgID != nil {
+		req.SetPathValue("orgID", orgID.String())
+	}
+	rec := httptest.NewRecorder()
+	cfg.handlerWatermarkSet(rec, req)
+	return rec
+}
+
+func TestWatermarkSettingsAreValidated(t *testing.T) {
+	cfg := newTestConfig(t)
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	_, token := createTestUser(t, cfg, "user@example.com")
+	image := []byte("png logo")
+
+	decodeResponse(t, setWatermark(t, cfg, token, nil, nil, nil), http.StatusBadRequest, nil)
+	for _, fields := range []map[string]string{
+		{"position": "middle"},
+		{"opacity": "0"},
+		{"opacity": "1.5"},
+		{"scale": "2"},
+		{"scale": "large"},
+	} {
+		rec := setWatermark(t, cfg, token, nil, image, fields)
+		decodeResponse(t, rec, http.StatusBadRequest, nil)
+	}
+
+	var watermark database.Watermark
+	decodeResponse(t, setWatermark(t, cfg, token, nil, image, map[string]string{"position": "top-left"}), http.StatusOK, &watermark)
+	if watermark.Position != "top-left" || watermark.Opacity != defaultWatermark.Opacity || watermark.Scale != defaultWatermark.Scale {
+		t.Errorf("watermark = %+v, want top-left with default opacity and scale", watermark)
+	}
+
+	// Settings can be changed without sending the image again, and keep
+	// the values that aren't sent.
+	var updated database.Watermark
+	decodeResponse(t, setWatermark(t, cfg, token, nil, nil, map[string]string{"opacity": "0.5"}), http.StatusOK, &updated)
+	if updated.ImageURL != watermark.ImageURL || updated.Position != "top-left" || updated.Opacity != 0.5 {
+		t.Errorf("updated watermark = %+v", updated)
+	}
+	decodeResponse(t, setWatermark(t, cfg, token, nil, nil, map[string]string{"scale": "0"}), http.StatusBadRequest, nil)
+}
+
+func TestWatermarkedUpload(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	editor, editorToken := createTestUser(t, cfg, "editor@example.com")
+	viewer, viewerToken := createTestUser(t, cfg, "viewer@example.com")
+	org, err := cfg.db.CreateOrganization("Team", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for member, role := range map[uuid.UUID]database.OrgRole{editor.ID: database.OrgRoleMember, viewer.ID: database.OrgRoleViewer} {
+		if err := cfg.db.AddOrganizationMember(org.ID, member, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Organization videos get the organization's watermark, not that of
+	// whoever uploads them.
+	decodeResponse(t, setWatermark(t, cfg, editorToken, nil, []byte("personal logo"), nil), http.StatusOK, nil)
+	decodeResponse(t, setWatermark(t, cfg, editorToken, &org.ID, []byte("team logo"), nil), http.StatusForbidden, nil)
+	decodeResponse(t, setWatermark(t, cfg, ownerToken, &org.ID, []byte("team logo"), nil), http.StatusOK, nil)
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: owner.ID, OrgID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := testVideo("upload")
+	rec := httptest.NewRecorder()
+	cfg.handlerUploadVideo(rec, newUploadRequest(t, video.ID, editorToken, content))
+	decodeResponse(t, rec, http.StatusOK, nil)
+	if !called(fake, "watermark") {
+		t.Fatalf("media calls = %v, want a watermark", fake.Calls)
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.OriginalVideoURL == nil {
+		t.Fatal("original wasn't kept")
+	}
+	if watermarked, _ := bucket.object(cfg.s3KeyFromURL(*video.VideoURL)); !bytes.Equal(watermarked, append(testVideo("upload"), "team logo"...)) {
+		t.Errorf("video = %q, want the upload with the team logo", watermarked)
+	}
+	if original, _ := bucket.object(cfg.s3KeyFromURL(*video.OriginalVideoURL)); !bytes.Equal(original, content) {
+		t.Errorf("original = %q, want the upload", original)
+	}
+
+	stream := func(token, query string) *httptest.ResponseRecorder {
+		return streamVideo(cfg, "/api/videos/"+video.ID.String()+"/stream"+query, bearer(token))
+	}
+	for _, token := range []string{viewerToken, editorToken} {
+		rec := stream(token, "")
+		if body, _ := io.ReadAll(rec.Body); rec.Code != http.StatusOK || !bytes.HasSuffix(body, []byte("team logo")) {
+			t.Errorf("stream = %d %q, want the watermarked file", rec.Code, body)
+		}
+	}
+	decodeResponse(t, stream(viewerToken, "?rendition=original"), http.StatusForbidden, nil)
+	for _, token := range []string{editorToken, ownerToken} {
+		rec := stream(token, "?rendition=original")
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+			t.Errorf("original stream = %d %q, want the upload", rec.Code, rec.Body.Bytes())
+		}
+	}
+
+	// Personal videos get their owner's watermark.
+	personal := uploadTestVideo(t, cfg, editor, editorToken, testVideo("personal"))
+	personal, err = cfg.db.GetVideo(personal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watermarked, _ := bucket.object(cfg.s3KeyFromURL(*personal.VideoURL)); !bytes.Equal(watermarked, append(testVideo("personal"), "personal logo"...)) {
+		t.Errorf("personal video = %q, want the upload with the personal logo", watermarked)
+	}
+}
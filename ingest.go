@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// acceptedUploadTypes are the declared Content-Types a video upload may
+// have. The actual container is always detected from the file itself.
+var acceptedUploadTypes = map[string]bool{
+	"video/mp4":                true,
+	"video/quicktime":          true,
+	"video/x-matroska":         true,
+	"video/webm":               true,
+	"application/octet-stream": true,
+}
+
+// sniffContainer detects the container of the file from its leading bytes.
+func sniffContainer(file io.ReaderAt) (media.Container, error) {
+	header := make([]byte, media.SniffLength)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return media.ContainerUnknown, err
+	}
+	return media.Sniff(header[:n]), nil
+}
+
+// probeUpload checks that the uploaded file is a valid video in the
+// detected container. Errors wrapping media.ErrInvalidMedia mean the upload
+// itself is bad.
+func (cfg *apiConfig) probeUpload(ctx context.Context, uploadPath string, container media.Container) (media.ProbeResult, error) {
+	probe, err := cfg.media.Probe(ctx, uploadPath)
+	if err != nil {
+		return media.ProbeResult{}, err
+	}
+	if err := media.Validate(probe, container); err != nil {
+		return media.ProbeResult{}, err
+	}
+	return probe, nil
+}
+
+// convertVideo turns a validated upload into the canonical faststart MP4
+// that is stored and served. Browser-playable inputs are only remuxed;
+// everything else is transcoded to H.264/AAC. The caller must remove the
+// returned file.
+func (cfg *apiConfig) convertVideo(ctx context.Context, uploadPath string, probe media.ProbeResult) (string, error) {
+	output, err := os.CreateTemp("", "tubely-processed-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	outputPath := output.Name()
+	output.Close()
+
+	if media.NeedsTranscode(probe) {
+		err = cfg.media.Transcode(ctx, uploadPath, outputPath)
+	} else {
+		err = cfg.media.Remux(ctx, uploadPath, outputPath)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("couldn't convert upload: %w", err)
+	}
+	return outputPath, nil
+}
+
+// storedVideoFile is a video file stored in the bucket and charged to the
+// owner of the video it is for, along with the local copy it came from.
+type storedVideoFile struct {
+	url        string
+	path       string
+	probe      media.ProbeResult
+	dimensions database.VideoDimensions
+}
+
+// commitVideoFile makes the stored file the video's new version. With a
+// watermark, viewers get a watermarked copy and the file is kept as the
+// original only editors can stream. Everything stored for the file is
+// released again if the video can't be updated; on success the previous
+// version's previews and renditions are. Failures are *ingestError.
+func (cfg *apiConfig) commitVideoFile(ctx context.Context, video database.Video, userID uuid.UUID, file storedVideoFile) (database.Video, error) {
+	ownerID := video.UserID
+	previous := video
+	video.VideoURL = &file.url
+	video.OriginalVideoURL = nil
+	video.VideoDimensions = file.dimensions
+	video.PreviewURL, video.PreviewVideoURL = nil, nil
+	video.VideoRenditions = database.VideoRenditions{}
+	discard := func() {
+		for _, objectURL := range []*string{video.VideoURL, video.OriginalVideoURL} {
+			if objectURL == nil {
+				continue
+			}
+			if err := cfg.releaseS3Object(ctx, ownerID, *objectURL); err != nil {
+				log.Printf("Couldn't release video file %s: %v", *objectURL, err)
+			}
+		}
+		cfg.releasePreviews(ownerID, video.PreviewURL, video.PreviewVideoURL)
+		cfg.releaseRenditions(ctx, ownerID, video.VideoRenditions)
+	}
+
+	watermark, err := cfg.videoWatermark(video)
+	if err != nil {
+		discard()
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Couldn't get watermark", err}
+	}
+	sourcePath := file.path
+	if watermark.ImageURL != "" {
+		watermarkedPath, err := cfg.applyWatermark(ctx, watermark, file.path)
+		if err == nil {
+			defer os.Remove(watermarkedPath)
+			var watermarkedURL string
+			watermarkedURL, err = cfg.storeS3File(ctx, ownerID, watermarkedPath, aspectKeyPrefix(file.dimensions.AspectClass), media.ContainerMP4.MediaType())
+			if err == nil {
+				video.VideoURL, video.OriginalVideoURL = &watermarkedURL, &file.url
+				sourcePath = watermarkedPath
+			}
+		}
+		if err != nil {
+			discard()
+			if isQuotaError(err) {
+				return database.Video{}, &ingestError{http.StatusRequestEntityTooLarge, err.Error(), err}
+			}
+			return database.Video{}, &ingestError{http.StatusInternalServerError, "Error applying watermark", err}
+		}
+	}
+
+	video.PreviewURL, video.PreviewVideoURL = cfg.generatePreviews(ctx, ownerID, sourcePath, file.probe.Duration)
+	video.VideoRenditions = cfg.generateRenditions(ctx, ownerID, sourcePath, file.probe)
+	version, err := cfg.createVideoVersion(video, userID, file.probe)
+	if err == nil {
+		video.Version = version.Version
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			if deleteErr := cfg.db.DeleteVideoVersion(video.ID, version.Version); deleteErr != nil {
+				log.Printf("Couldn't delete version %d of video %s: %v", version.Version, video.ID, deleteErr)
+			}
+		}
+	}
+	if err != nil {
+		discard()
+		return database.Video{}, &ingestError{http.StatusInternalServerError, "Error updating video information", err}
+	}
+
+	// The previous file stays with its version until that is pruned.
+	cfg.releasePreviews(ownerID, previous.PreviewURL, previous.PreviewVideoURL)
+	cfg.releaseRenditions(ctx, ownerID, previous.VideoRenditions)
+	cfg.pruneVideoVersions(ctx, video)
+	suggestions := cfg.generateChapterSuggestions(ctx, ownerID, sourcePath, file.probe.Duration)
+	cfg.replaceChapterSuggestions(video.ID, ownerID, suggestions)
+	return video, nil
+}
+
+// ingestError is why a video file was refused or couldn't be processed,
+// with the status and message to respond with.
+type ingestError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *ingestError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+func (e *ingestError) Unwrap() error {
+	return e.err
+}
+
+func respondWithIngestError(w http.ResponseWriter, err error) {
+	var ingestErr *ingestError
+	if errors.As(err, &ingestErr) {
+		respondWithError(w, ingestErr.status, ingestErr.message, ingestErr.err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+}
@@ -2,10 +2,12 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -17,7 +19,9 @@ import (
 type TokenType string
 
 const (
-	TokenTypeAccess TokenType = "tubely-access"
+	TokenTypeAccess       TokenType = "tubely-access"
+	TokenTypeMFAChallenge TokenType = "tubely-mfa-challenge"
+	TokenTypeStream       TokenType = "tubely-stream"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -34,49 +38,175 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// Claims are the claims carried by every token Tubely issues. The token type
+// keeps access tokens from being accepted where another kind is expected.
+type Claims struct {
+	jwt.RegisteredClaims
+	TokenType TokenType `json:"token_type"`
+	// VideoID is the only video a stream token can play.
+	VideoID string `json:"video_id,omitempty"`
+}
+
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	keys *Keyring,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
-	})
-	return token.SignedString(signingKey)
-}
-
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
+	return makeToken(userID, keys, TokenTypeAccess, expiresIn)
+}
+
+// MakeChallengeJWT issues the short-lived token that proves the password step
+// of a two-factor login succeeded. It is not accepted as an access token.
+func MakeChallengeJWT(userID uuid.UUID, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makeToken(userID, keys, TokenTypeMFAChallenge, expiresIn)
+}
+
+func ValidateChallengeJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	userID, _, err := validateToken(tokenString, keys, TokenTypeMFAChallenge)
+	return userID, err
+}
+
+// MakeStreamJWT issues a short-lived token that lets the user play one video.
+// Unlike access tokens it is safe to put in a URL, where players need it.
+func MakeStreamJWT(userID, videoID uuid.UUID, keys *Keyring, expiresIn time.Duration) (string, error) {
+	claims := newClaims(userID, keys, TokenTypeStream, expiresIn)
+	claims.VideoID = videoID.String()
+	return keys.sign(claims)
+}
+
+// ValidateStreamJWT returns the user a stream token was issued to, provided
+// it was issued for the given video.
+func ValidateStreamJWT(tokenString string, keys *Keyring, videoID uuid.UUID) (uuid.UUID, error) {
+	userID, claims, err := validateToken(tokenString, keys, TokenTypeStream)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims.VideoID != videoID.String() {
+		return uuid.Nil, errors.New("token is for another video")
+	}
+	return userID, nil
+}
+
+func makeToken(userID uuid.UUID, keys *Keyring, tokenType TokenType, expiresIn time.Duration) (string, error) {
+	return keys.sign(newClaims(userID, keys, tokenType, expiresIn))
+}
+
+func newClaims(userID uuid.UUID, keys *Keyring, tokenType TokenType, expiresIn time.Duration) Claims {
+	now := time.Now().UTC()
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keys.issuer,
+			Audience:  keys.audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		TokenType: tokenType,
+	}
+}
+
+func ValidateJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	userID, _, err := validateToken(tokenString, keys, TokenTypeAccess)
+	return userID, err
+}
+
+func validateToken(tokenString string, keys *Keyring, tokenType TokenType) (uuid.UUID, Claims, error) {
+	claims := Claims{}
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, &claims)
+	if err != nil {
+		return uuid.Nil, Claims{}, err
+	}
+	if _, hasKeyID := token.Header["kid"]; !hasKeyID {
+		if tokenType != TokenTypeAccess {
+			return uuid.Nil, Claims{}, errors.New("token has no key ID")
+		}
+		userID, err := validateLegacyJWT(tokenString, keys)
+		return userID, Claims{}, err
+	}
+
+	claims = Claims{}
+	options := []jwt.ParserOption{jwt.WithIssuer(keys.issuer)}
+	if len(keys.audience) > 0 {
+		options = append(options, jwt.WithAudience(keys.audience[0]))
+	}
+	_, err = jwt.ParseWithClaims(
 		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, ok := keys.lookup(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			// Pin the algorithm to the key so that an RSA public key can
+			// never be used as an HMAC secret.
+			if token.Method.Alg() != string(key.Algorithm) {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return key.verificationKey(), nil
+		},
+		options...,
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, Claims{}, err
+	}
+	if claims.TokenType != tokenType {
+		return uuid.Nil, Claims{}, errors.New("invalid token type")
 	}
 
-	userIDString, err := token.Claims.GetSubject()
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, Claims{}, fmt.Errorf("invalid user ID: %w", err)
 	}
+	if err := keys.checkIssuedAt(id, claims.IssuedAt); err != nil {
+		return uuid.Nil, Claims{}, err
+	}
+	return id, claims, nil
+}
 
-	issuer, err := token.Claims.GetIssuer()
+// validateLegacyJWT accepts HS256 access tokens signed with JWT_SECRET before
+// the keyring existed, until the cutoff set with SetLegacySecret. Whoever
+// knows the secret can mint such tokens, so they are never accepted after it.
+func validateLegacyJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	keys.mu.RLock()
+	secret := keys.legacy
+	until := keys.legacyUntil
+	keys.mu.RUnlock()
+	if len(secret) == 0 || !time.Now().Before(until) {
+		return uuid.Nil, errors.New("token has no key ID")
+	}
+
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) { return secret, nil },
+		jwt.WithValidMethods([]string{string(AlgorithmHS256)}),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithIssuedAt(),
+	)
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
-		return uuid.Nil, errors.New("invalid issuer")
+	if claims.ExpiresAt == nil {
+		return uuid.Nil, errors.New("token has no expiry")
+	}
+	if claims.IssuedAt == nil || !claims.IssuedAt.Before(until) {
+		return uuid.Nil, errors.New("legacy token issued after the cutoff")
+	}
+	// Legacy tokens were issued without an audience. One that names an
+	// audience must name ours, as on the keyring path.
+	if len(claims.Audience) > 0 && len(keys.audience) > 0 && !slices.Contains(claims.Audience, keys.audience[0]) {
+		return uuid.Nil, errors.New("invalid audience")
 	}
 
-	id, err := uuid.Parse(userIDString)
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
 	}
+	if err := keys.checkIssuedAt(id, claims.IssuedAt); err != nil {
+		return uuid.Nil, err
+	}
 	return id, nil
 }
 
@@ -102,6 +232,13 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// HashToken returns the hex SHA-256 digest of a random token so that only the
+// digest needs to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
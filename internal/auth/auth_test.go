@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const legacySecret = "legacy secret"
+
+func makeLegacyToken(t *testing.T, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(legacySecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func legacyClaims(userID uuid.UUID, issuedAt time.Time) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		IssuedAt:  jwt.NewNumericDate(issuedAt),
+		ExpiresAt: jwt.NewNumericDate(issuedAt.Add(time.Hour)),
+		Subject:   userID.String(),
+	}
+}
+
+func TestValidateJWTLegacyTokens(t *testing.T) {
+	userID := uuid.New()
+	now := time.Now()
+
+	t.Run("without a cutoff", func(t *testing.T) {
+		keys := NewKeyring("tubely", []string{"tubely"})
+		if _, err := ValidateJWT(makeLegacyToken(t, legacyClaims(userID, now)), keys); err == nil {
+			t.Error("legacy token accepted without SetLegacySecret")
+		}
+	})
+
+	t.Run("before the cutoff", func(t *testing.T) {
+		keys := NewKeyring("tubely", []string{"tubely"})
+		keys.SetLegacySecret(legacySecret, now.Add(time.Hour))
+		got, err := ValidateJWT(makeLegacyToken(t, legacyClaims(userID, now.Add(-time.Minute))), keys)
+		if err != nil || got != userID {
+			t.Errorf("ValidateJWT = %s, %v, want %s", got, err, userID)
+		}
+	})
+
+	t.Run("after the cutoff", func(t *testing.T) {
+		keys := NewKeyring("tubely", []string{"tubely"})
+		keys.SetLegacySecret(legacySecret, now.Add(-time.Minute))
+		if _, err := ValidateJWT(makeLegacyToken(t, legacyClaims(userID, now.Add(-time.Hour))), keys); err == nil {
+			t.Error("legacy token accepted after the cutoff")
+		}
+	})
+
+	invalid := map[string]func(*jwt.RegisteredClaims){
+		"another issuer":   func(c *jwt.RegisteredClaims) { c.Issuer = "someone-else" },
+		"another audience": func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"someone-else"} },
+		"no expiry":        func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil },
+		"no issued at":     func(c *jwt.RegisteredClaims) { c.IssuedAt = nil },
+		"expired": func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(-2 * time.Hour))
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+		},
+	}
+	for name, modify := range invalid {
+		t.Run(name, func(t *testing.T) {
+			keys := NewKeyring("tubely", []string{"tubely"})
+			keys.SetLegacySecret(legacySecret, now.Add(time.Hour))
+			claims := legacyClaims(userID, now.Add(-time.Minute))
+			modify(&claims)
+			if _, err := ValidateJWT(makeLegacyToken(t, claims), keys); err == nil {
+				t.Error("ValidateJWT accepted the token")
+			}
+		})
+	}
+}
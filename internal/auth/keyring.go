@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type SigningAlgorithm string
+
+const (
+	AlgorithmHS256 SigningAlgorithm = "HS256"
+	AlgorithmRS256 SigningAlgorithm = "RS256"
+	AlgorithmEdDSA SigningAlgorithm = "EdDSA"
+)
+
+func (a SigningAlgorithm) method() (jwt.SigningMethod, error) {
+	switch a {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", a)
+}
+
+// SigningKey is one entry of a Keyring. For HS256 keys Private holds the
+// shared secret as []byte and Public is nil.
+type SigningKey struct {
+	ID        string
+	Algorithm SigningAlgorithm
+	Private   crypto.PrivateKey
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+}
+
+func (k SigningKey) verificationKey() crypto.PublicKey {
+	if k.Algorithm == AlgorithmHS256 {
+		return k.Private
+	}
+	return k.Public
+}
+
+// Keyring holds the key used to sign new tokens plus every key that is still
+// accepted for verification. Keys are looked up by the token's kid header.
+type Keyring struct {
+	issuer   string
+	audience []string
+
+	mu       sync.RWMutex
+	keys     map[string]SigningKey
+	activeID string
+
+	legacy      []byte
+	legacyUntil time.Time
+
+	tokensValidAfter func(userID uuid.UUID) (time.Time, error)
+}
+
+func NewKeyring(issuer string, audience []string) *Keyring {
+	return &Keyring{
+		issuer:   issuer,
+		audience: audience,
+		keys:     map[string]SigningKey{},
+	}
+}
+
+// SetLegacySecret lets the keyring accept HS256 tokens that were issued
+// before key IDs were introduced, until the given time. Such tokens carry no
+// kid and use the token type as their issuer.
+func (k *Keyring) SetLegacySecret(secret string, until time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.legacy = []byte(secret)
+	k.legacyUntil = until
+}
+
+// SetTokensValidAfter makes the keyring reject tokens of a user that were
+// issued before the time validAfter returns for them, e.g. because they
+// changed their password since.
+func (k *Keyring) SetTokensValidAfter(validAfter func(userID uuid.UUID) (time.Time, error)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.tokensValidAfter = validAfter
+}
+
+// checkIssuedAt applies SetTokensValidAfter to a token of the user.
+func (k *Keyring) checkIssuedAt(userID uuid.UUID, issuedAt *jwt.NumericDate) error {
+	k.mu.RLock()
+	validAfter := k.tokensValidAfter
+	k.mu.RUnlock()
+	if validAfter == nil {
+		return nil
+	}
+	cutoff, err := validAfter(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't check token revocation: %w", err)
+	}
+	if issuedAt == nil || issuedAt.Before(cutoff) {
+		return errors.New("token was revoked")
+	}
+	return nil
+}
+
+// Replace swaps the whole key set, e.g. after reloading it from storage.
+func (k *Keyring) Replace(keys []SigningKey, activeID string) error {
+	set := make(map[string]SigningKey, len(keys))
+	for _, key := range keys {
+		set[key.ID] = key
+	}
+	if _, ok := set[activeID]; !ok {
+		return fmt.Errorf("active key %q is not in the key set", activeID)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = set
+	k.activeID = activeID
+	return nil
+}
+
+func (k *Keyring) ActiveKey() (SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[k.activeID]
+	if !ok {
+		return SigningKey{}, errors.New("keyring has no active signing key")
+	}
+	return key, nil
+}
+
+func (k *Keyring) lookup(kid string) (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	key, err := k.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+	method, err := key.Algorithm.method()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// JSONWebKey is the public half of an asymmetric signing key in RFC 7517 form.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public verification keys. Symmetric keys are never
+// published.
+func (k *Keyring) JWKS() JSONWebKeySet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range k.keys {
+		jwk := JSONWebKey{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: string(key.Algorithm),
+		}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func GenerateSigningKey(algorithm SigningAlgorithm) (SigningKey, error) {
+	key := SigningKey{
+		Algorithm: algorithm,
+		CreatedAt: time.Now().UTC(),
+	}
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return SigningKey{}, err
+		}
+		key.Private = secret
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.Private = private
+		key.Public = &private.PublicKey
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.Private = private
+		key.Public = public
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return SigningKey{}, err
+	}
+	key.ID = base64.RawURLEncoding.EncodeToString(id)
+	return key, nil
+}
+
+// MarshalPrivateKey encodes the key material for storage: PKCS#8 PEM for
+// asymmetric keys and base64 for HMAC secrets.
+func MarshalPrivateKey(key SigningKey) (string, error) {
+	if key.Algorithm == AlgorithmHS256 {
+		secret, ok := key.Private.([]byte)
+		if !ok {
+			return "", errors.New("HS256 key must be a byte slice")
+		}
+		return base64.StdEncoding.EncodeToString(secret), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func ParsePrivateKey(id string, algorithm SigningAlgorithm, encoded string, createdAt time.Time) (SigningKey, error) {
+	key := SigningKey{
+		ID:        id,
+		Algorithm: algorithm,
+		CreatedAt: createdAt,
+	}
+	if algorithm == AlgorithmHS256 {
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return SigningKey{}, err
+		}
+		key.Private = secret
+		return key, nil
+	}
+
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return SigningKey{}, errors.New("invalid PEM private key")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	switch p := private.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgorithmRS256 {
+			return SigningKey{}, fmt.Errorf("RSA key stored as %s", algorithm)
+		}
+		key.Private = p
+		key.Public = &p.PublicKey
+	case ed25519.PrivateKey:
+		if algorithm != AlgorithmEdDSA {
+			return SigningKey{}, fmt.Errorf("Ed25519 key stored as %s", algorithm)
+		}
+		key.Private = p
+		key.Public = p.Public()
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported private key type %T", private)
+	}
+	return key, nil
+}
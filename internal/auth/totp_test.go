@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors.
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	want, _ := TOTPCode(rfc6238Secret, 1)
+	if got, err := TOTPCode(strings.ToLower(rfc6238Secret), 1); err != nil || got != want {
+		t.Errorf("code with a lowercase secret = %s, %v; want %s", got, err, want)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	current := TOTPStep(now)
+
+	for offset := int64(-3); offset <= 3; offset++ {
+		code, err := TOTPCode(secret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok, err := ValidateTOTP(secret, code, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantOK := offset >= -totpSkew && offset <= totpSkew
+		if ok != wantOK {
+			t.Errorf("code %d steps away accepted = %v, want %v", offset, ok, wantOK)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %d steps away matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"287082", true},
+		{" 287 082 ", true},
+		{"287083", false},
+		{"28708", false},
+		{"94287082", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		_, ok, err := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Errorf("ValidateTOTP(%q) = %v, want %v", tt.code, ok, tt.ok)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || NormalizeRecoveryCode(code) != code {
+			t.Errorf("code %q isn't formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was generated twice", code)
+		}
+		seen[code] = true
+	}
+
+	for _, input := range []string{"ABCDE-FGHIJ", " abcdefghij ", "abcde fghij"} {
+		if got := NormalizeRecoveryCode(input); got != "abcde-fghij" {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q", input, got)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type AssetStorage string
+
+const (
+	AssetStorageLocal AssetStorage = "local"
+	AssetStorageS3    AssetStorage = "s3"
+)
+
+// Asset is a stored file shared by every video that references the same
+// content. Digest identifies the content; Key is where it is stored. An
+// asset without references is locked: its file is being stored or deleted,
+// and nothing else may touch it until UnlockAsset drops the record.
+type Asset struct {
+	CreateAssetParams
+	RefCount  int       `json:"ref_count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type CreateAssetParams struct {
+	Storage   AssetStorage `json:"storage"`
+	Key       string       `json:"key"`
+	Digest    string       `json:"digest"`
+	MediaType string       `json:"media_type"`
+	Size      int64        `json:"size"`
+}
+
+const assetColumns = `storage, key, digest, media_type, size, ref_count, created_at, updated_at`
+
+func scanAsset(row rowScanner) (Asset, error) {
+	var asset Asset
+	err := row.Scan(
+		&asset.Storage,
+		&asset.Key,
+		&asset.Digest,
+		&asset.MediaType,
+		&asset.Size,
+		&asset.RefCount,
+		&asset.CreatedAt,
+		&asset.UpdatedAt,
+	)
+	return asset, err
+}
+
+// AcquireAsset adds a reference to the stored asset with the given content
+// digest. It returns false if no such asset is stored yet.
+func (c Client) AcquireAsset(storage AssetStorage, digest string) (Asset, bool, error) {
+	query := `
+		UPDATE assets
+		SET ref_count = ref_count + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE storage = ? AND digest = ? AND ref_count > 0
+		RETURNING ` + assetColumns
+	asset, err := scanAsset(c.db.QueryRow(query, storage, digest))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Asset{}, false, nil
+		}
+		return Asset{}, false, err
+	}
+	return asset, true, nil
+}
+
+// AcquireAssetKey adds a reference to the asset stored under key. It
+// returns false if there is none.
+func (c Client) AcquireAssetKey(storage AssetStorage, key string) (Asset, bool, error) {
+	query := `
+		UPDATE assets
+		SET ref_count = ref_count + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE storage = ? AND key = ? AND ref_count > 0
+		RETURNING ` + assetColumns
+	asset, err := scanAsset(c.db.QueryRow(query, storage, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Asset{}, false, nil
+		}
+		return Asset{}, false, err
+	}
+	return asset, true, nil
+}
+
+// LockAsset records the asset about to be stored under params.Key as
+// locked. It returns false if the key is in use, unless it has been locked
+// since before staleBefore, which means whoever locked it is gone.
+func (c Client) LockAsset(params CreateAssetParams, staleBefore time.Time) (bool, error) {
+	query := `
+		INSERT INTO assets (storage, key, digest, media_type, size, ref_count, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 0, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(storage, key) DO UPDATE SET
+			digest = excluded.digest,
+			media_type = excluded.media_type,
+			size = excluded.size,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE ref_count = 0 AND updated_at < ?
+		RETURNING key
+	`
+	var key string
+	err := c.db.QueryRow(query, params.Storage, params.Key, params.Digest, params.MediaType, params.Size, staleBefore.UTC()).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UnlockAsset drops the record of a locked asset once its file has been
+// deleted, or couldn't be stored.
+func (c Client) UnlockAsset(storage AssetStorage, key string) error {
+	_, err := c.db.Exec("DELETE FROM assets WHERE storage = ? AND key = ? AND ref_count = 0", storage, key)
+	return err
+}
+
+// AddAssetReference records a newly stored asset with one reference, which
+// unlocks it if it was locked. If the asset is already referenced, its
+// reference count is incremented.
+func (c Client) AddAssetReference(params CreateAssetParams) (Asset, error) {
+	query := `
+		INSERT INTO assets (storage, key, digest, media_type, size, ref_count, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(storage, key) DO UPDATE SET
+			ref_count = ref_count + 1,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING ` + assetColumns
+	return scanAsset(c.db.QueryRow(query, params.Storage, params.Key, params.Digest, params.MediaType, params.Size))
+}
+
+// GetAssetSize returns the size of the asset stored under key, or 0 if
+// there is none.
+func (c Client) GetAssetSize(storage AssetStorage, key string) (int64, error) {
+	var size int64
+	err := c.db.QueryRow("SELECT size FROM assets WHERE storage = ? AND key = ?", storage, key).Scan(&size)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// ReleaseAsset drops a reference to the asset stored under key and returns
+// its size. unreferenced is true once nothing references the asset any more;
+// it is then locked, and the caller must delete the stored file and call
+// UnlockAsset. Files stored before assets were tracked have no record; they
+// are always unreferenced and have size 0.
+func (c Client) ReleaseAsset(storage AssetStorage, key string) (size int64, unreferenced bool, err error) {
+	query := `
+		UPDATE assets
+		SET ref_count = ref_count - 1, updated_at = CURRENT_TIMESTAMP
+		WHERE storage = ? AND key = ? AND ref_count > 0
+		RETURNING size, ref_count
+	`
+	var refCount int
+	err = c.db.QueryRow(query, storage, key).Scan(&size, &refCount)
+	if err == nil {
+		return size, refCount == 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+	// A locked asset holds no reference to drop.
+	var records int
+	err = c.db.QueryRow("SELECT COUNT(*) FROM assets WHERE storage = ? AND key = ?", storage, key).Scan(&records)
+	if err != nil {
+		return 0, false, err
+	}
+	return 0, records == 0, nil
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEventType string
+
+const (
+	AuditEventLoginLockout AuditEventType = "login_lockout"
+	AuditEventLoginUnlock  AuditEventType = "login_unlock"
+	AuditEventTOTPReset    AuditEventType = "totp_reset"
+)
+
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditEventParams
+}
+
+type CreateAuditEventParams struct {
+	EventType AuditEventType `json:"event_type"`
+	UserID    *uuid.UUID     `json:"user_id"`
+	IP        string         `json:"ip"`
+	Details   string         `json:"details"`
+}
+
+func (c Client) CreateAuditEvent(params CreateAuditEventParams) error {
+	query := `
+		INSERT INTO audit_events (id, created_at, event_type, user_id, ip, details)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New().String(), params.EventType, params.UserID, params.IP, params.Details)
+	return err
+}
+
+func (c Client) GetAuditEvents(limit int) ([]AuditEvent, error) {
+	query := `
+		SELECT id, created_at, event_type, user_id, ip, details
+		FROM audit_events
+		ORDER BY created_at DESC
+		LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.Scan(&event.ID, &event.CreatedAt, &event.EventType, &event.UserID, &event.IP, &event.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
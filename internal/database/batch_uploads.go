@@ -0,0 +1,174 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BatchItemStatus tracks an item of a batch upload from its draft being
+// created to its file being processed.
+type BatchItemStatus string
+
+const (
+	BatchItemQueued     BatchItemStatus = "queued"
+	BatchItemProcessing BatchItemStatus = "processing"
+	BatchItemSucceeded  BatchItemStatus = "succeeded"
+	BatchItemFailed     BatchItemStatus = "failed"
+)
+
+// BatchUpload is a set of videos created from one manifest.
+type BatchUpload struct {
+	ID        uuid.UUID         `json:"id"`
+	UserID    uuid.UUID         `json:"user_id"`
+	OrgID     *uuid.UUID        `json:"org_id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Items     []BatchUploadItem `json:"items"`
+}
+
+// BatchUploadItem is one manifest entry. Items that failed before a draft
+// was created have no VideoID.
+type BatchUploadItem struct {
+	Position  int             `json:"position"`
+	Filename  string          `json:"filename"`
+	Title     string          `json:"title"`
+	VideoID   *uuid.UUID      `json:"video_id"`
+	Status    BatchItemStatus `json:"status"`
+	Error     *string         `json:"error"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	// FilePath is where the file of a queued item waits to be processed.
+	FilePath *string `json:"-"`
+}
+
+// ClaimedBatchItem is a queued item a worker took to process.
+type ClaimedBatchItem struct {
+	BatchID  uuid.UUID
+	UserID   uuid.UUID
+	Position int
+	VideoID  uuid.UUID
+	FilePath string
+}
+
+// CreateBatchUpload records a batch with its items.
+func (c Client) CreateBatchUpload(userID uuid.UUID, orgID *uuid.UUID, items []BatchUploadItem) (BatchUpload, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return BatchUpload{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	if _, err := tx.Exec(`
+		INSERT INTO batch_uploads (id, user_id, org_id, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, userID, orgID); err != nil {
+		return BatchUpload{}, err
+	}
+	for _, item := range items {
+		if _, err := tx.Exec(`
+			INSERT INTO batch_upload_items (batch_id, position, filename, title, video_id, status, error, file_path, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		`, id, item.Position, item.Filename, item.Title, item.VideoID, item.Status, item.Error, item.FilePath); err != nil {
+			return BatchUpload{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return BatchUpload{}, err
+	}
+	return c.GetBatchUpload(id)
+}
+
+// GetBatchUpload returns the batch with its items in manifest order, or a
+// zero BatchUpload if there is none.
+func (c Client) GetBatchUpload(id uuid.UUID) (BatchUpload, error) {
+	var batch BatchUpload
+	err := c.db.QueryRow(`
+		SELECT id, user_id, org_id, created_at
+		FROM batch_uploads
+		WHERE id = ?
+	`, id).Scan(&batch.ID, &batch.UserID, &batch.OrgID, &batch.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return BatchUpload{}, nil
+		}
+		return BatchUpload{}, err
+	}
+
+	rows, err := c.db.Query(`
+		SELECT position, filename, title, video_id, status, error, updated_at
+		FROM batch_upload_items
+		WHERE batch_id = ?
+		ORDER BY position
+	`, id)
+	if err != nil {
+		return BatchUpload{}, err
+	}
+	defer rows.Close()
+
+	batch.Items = []BatchUploadItem{}
+	for rows.Next() {
+		var item BatchUploadItem
+		if err := rows.Scan(&item.Position, &item.Filename, &item.Title, &item.VideoID, &item.Status, &item.Error, &item.UpdatedAt); err != nil {
+			return BatchUpload{}, err
+		}
+		batch.Items = append(batch.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return BatchUpload{}, err
+	}
+	return batch, nil
+}
+
+// SetBatchItemStatus updates the status of an item, with the reason it
+// failed if it did.
+func (c Client) SetBatchItemStatus(batchID uuid.UUID, position int, status BatchItemStatus, errMessage *string) error {
+	_, err := c.db.Exec(`
+		UPDATE batch_upload_items
+		SET status = ?, error = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE batch_id = ? AND position = ?
+	`, status, errMessage, batchID, position)
+	return err
+}
+
+// ClaimBatchItem marks the item queued longest as processing and returns
+// it. found is false if no item is queued.
+func (c Client) ClaimBatchItem() (item ClaimedBatchItem, found bool, err error) {
+	err = c.db.QueryRow(`
+		UPDATE batch_upload_items
+		SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE rowid = (
+			SELECT rowid FROM batch_upload_items
+			WHERE status = ?
+			ORDER BY updated_at, position
+			LIMIT 1
+		)
+		RETURNING batch_id, position, video_id, file_path
+	`, BatchItemProcessing, BatchItemQueued).Scan(&item.BatchID, &item.Position, &item.VideoID, &item.FilePath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ClaimedBatchItem{}, false, nil
+	}
+	if err != nil {
+		return ClaimedBatchItem{}, false, err
+	}
+	err = c.db.QueryRow("SELECT user_id FROM batch_uploads WHERE id = ?", item.BatchID).Scan(&item.UserID)
+	if err != nil {
+		return ClaimedBatchItem{}, false, err
+	}
+	return item, true, nil
+}
+
+// RequeueProcessingBatchItems queues the items that were being processed
+// when the server stopped, so they are processed again.
+func (c Client) RequeueProcessingBatchItems() (int64, error) {
+	result, err := c.db.Exec(`
+		UPDATE batch_upload_items
+		SET status = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE status = ?
+	`, BatchItemQueued, BatchItemProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// ChapterSuggestion is a likely chapter start found by scene detection.
+type ChapterSuggestion struct {
+	// Start is the offset in seconds.
+	Start float64 `json:"start"`
+	// Score is how much the picture changes there, from 0 to 1. The start
+	// of the video has score 1.
+	Score        float64 `json:"score"`
+	ThumbnailURL *string `json:"thumbnail_url"`
+}
+
+// VideoChapter is a chapter the owner accepted or entered.
+type VideoChapter struct {
+	// Start is the offset in seconds.
+	Start        float64 `json:"start"`
+	Title        string  `json:"title"`
+	ThumbnailURL *string `json:"thumbnail_url"`
+}
+
+// GetChapterSuggestions returns the suggestions for the video in order.
+func (c Client) GetChapterSuggestions(videoID uuid.UUID) ([]ChapterSuggestion, error) {
+	rows, err := c.db.Query(`
+	SELECT start_seconds, score, thumbnail_url
+	FROM chapter_suggestions
+	WHERE video_id = ?
+	ORDER BY start_seconds
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []ChapterSuggestion{}
+	for rows.Next() {
+		var suggestion ChapterSuggestion
+		if err := rows.Scan(&suggestion.Start, &suggestion.Score, &suggestion.ThumbnailURL); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+// ReplaceChapterSuggestions replaces the suggestions for the video.
+func (c Client) ReplaceChapterSuggestions(videoID uuid.UUID, suggestions []ChapterSuggestion) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM chapter_suggestions WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	for _, suggestion := range suggestions {
+		_, err := tx.Exec(`
+		INSERT INTO chapter_suggestions (video_id, start_seconds, score, thumbnail_url)
+		VALUES (?, ?, ?, ?)
+		`, videoID, suggestion.Start, suggestion.Score, suggestion.ThumbnailURL)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetVideoChapters returns the chapters of the video in order.
+func (c Client) GetVideoChapters(videoID uuid.UUID) ([]VideoChapter, error) {
+	rows, err := c.db.Query(`
+	SELECT start_seconds, title, thumbnail_url
+	FROM video_chapters
+	WHERE video_id = ?
+	ORDER BY start_seconds
+	`, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := []VideoChapter{}
+	for rows.Next() {
+		var chapter VideoChapter
+		if err := rows.Scan(&chapter.Start, &chapter.Title, &chapter.ThumbnailURL); err != nil {
+			return nil, err
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, rows.Err()
+}
+
+// ReplaceVideoChapters replaces the chapters of the video.
+func (c Client) ReplaceVideoChapters(videoID uuid.UUID, chapters []VideoChapter) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_chapters WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		_, err := tx.Exec(`
+		INSERT INTO video_chapters (video_id, start_seconds, title, thumbnail_url)
+		VALUES (?, ?, ?, ?)
+		`, videoID, chapter.Start, chapter.Title, chapter.ThumbnailURL)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -72,13 +72,497 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	organizationMemberTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		org_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(org_id, user_id),
+		FOREIGN KEY(org_id) REFERENCES organizations(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationMemberTable)
+	if err != nil {
+		return err
+	}
+
+	organizationInvitationTable := `
+	CREATE TABLE IF NOT EXISTS organization_invitations (
+		token TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		org_id TEXT NOT NULL,
+		email TEXT NOT NULL,
+		role TEXT NOT NULL,
+		invited_by TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_at TIMESTAMP,
+		FOREIGN KEY(org_id) REFERENCES organizations(id),
+		FOREIGN KEY(invited_by) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(organizationInvitationTable)
+	if err != nil {
+		return err
+	}
+
+	oidcLoginStateTable := `
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		nonce TEXT NOT NULL,
+		code_verifier TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(oidcLoginStateTable)
+	if err != nil {
+		return err
+	}
+
+	userIdentityTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		email TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY(issuer, subject),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userIdentityTable)
+	if err != nil {
+		return err
+	}
+
+	signingKeyTable := `
+	CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+		id TEXT PRIMARY KEY,
+		algorithm TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		retired_at TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(signingKeyTable)
+	if err != nil {
+		return err
+	}
+
+	loginFailureTable := `
+	CREATE TABLE IF NOT EXISTS login_failures (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP,
+		PRIMARY KEY(scope, key)
+	);
+	`
+	_, err = c.db.Exec(loginFailureTable)
+	if err != nil {
+		return err
+	}
+
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		event_type TEXT NOT NULL,
+		user_id TEXT,
+		ip TEXT NOT NULL,
+		details TEXT NOT NULL
+	);
+	`
+	_, err = c.db.Exec(auditEventTable)
+	if err != nil {
+		return err
+	}
+
+	userTokenTable := `
+	CREATE TABLE IF NOT EXISTS user_tokens (
+		token_hash TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		purpose TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(userTokenTable)
+	if err != nil {
+		return err
+	}
+
+	totpTable := `
+	CREATE TABLE IF NOT EXISTS user_totp (
+		user_id TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		enabled_at TIMESTAMP,
+		last_used_step INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(totpTable)
+	if err != nil {
+		return err
+	}
+
+	recoveryCodeTable := `
+	CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		user_id TEXT NOT NULL,
+		code_hash TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP,
+		PRIMARY KEY(user_id, code_hash),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(recoveryCodeTable)
+	if err != nil {
+		return err
+	}
+
+	assetTable := `
+	CREATE TABLE IF NOT EXISTS assets (
+		storage TEXT NOT NULL,
+		key TEXT NOT NULL,
+		digest TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		ref_count INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(storage, key)
+	);
+	CREATE INDEX IF NOT EXISTS assets_digest ON assets(storage, digest);
+	`
+	_, err = c.db.Exec(assetTable)
+	if err != nil {
+		return err
+	}
+
+	usageTable := `
+	CREATE TABLE IF NOT EXISTS user_storage_usage (
+		user_id TEXT PRIMARY KEY,
+		bytes_stored INTEGER NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(usageTable)
+	if err != nil {
+		return err
+	}
+
+	watermarkTable := `
+	CREATE TABLE IF NOT EXISTS watermarks (
+		scope TEXT NOT NULL,
+		scope_id TEXT NOT NULL,
+		image_url TEXT NOT NULL,
+		position TEXT NOT NULL,
+		opacity REAL NOT NULL,
+		scale REAL NOT NULL,
+		updated_by TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(scope, scope_id)
+	);
+	`
+	_, err = c.db.Exec(watermarkTable)
+	if err != nil {
+		return err
+	}
+
+	videoVersionTable := `
+	CREATE TABLE IF NOT EXISTS video_versions (
+		video_id TEXT NOT NULL REFERENCES videos(id),
+		version INTEGER NOT NULL,
+		video_url TEXT NOT NULL,
+		original_video_url TEXT,
+		duration REAL NOT NULL DEFAULT 0,
+		video_codec TEXT NOT NULL DEFAULT '',
+		audio_codec TEXT NOT NULL DEFAULT '',
+		width INTEGER NOT NULL DEFAULT 0,
+		height INTEGER NOT NULL DEFAULT 0,
+		aspect_ratio REAL NOT NULL DEFAULT 0,
+		aspect_class TEXT NOT NULL DEFAULT '',
+		uploaded_by TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(video_id, version)
+	);
+	`
+	_, err = c.db.Exec(videoVersionTable)
+	if err != nil {
+		return err
+	}
+
+	chapterTables := `
+	CREATE TABLE IF NOT EXISTS chapter_suggestions (
+		video_id TEXT NOT NULL REFERENCES videos(id),
+		start_seconds REAL NOT NULL,
+		score REAL NOT NULL,
+		thumbnail_url TEXT,
+		PRIMARY KEY(video_id, start_seconds)
+	);
+	CREATE TABLE IF NOT EXISTS video_chapters (
+		video_id TEXT NOT NULL REFERENCES videos(id),
+		start_seconds REAL NOT NULL,
+		title TEXT NOT NULL,
+		thumbnail_url TEXT,
+		PRIMARY KEY(video_id, start_seconds)
+	);
+	`
+	_, err = c.db.Exec(chapterTables)
+	if err != nil {
+		return err
+	}
+
+	batchTables := `
+	CREATE TABLE IF NOT EXISTS batch_uploads (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL REFERENCES users(id),
+		org_id TEXT REFERENCES organizations(id),
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS batch_upload_items (
+		batch_id TEXT NOT NULL REFERENCES batch_uploads(id),
+		position INTEGER NOT NULL,
+		filename TEXT NOT NULL,
+		title TEXT NOT NULL,
+		video_id TEXT REFERENCES videos(id),
+		status TEXT NOT NULL,
+		error TEXT,
+		file_path TEXT,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY(batch_id, position)
+	);
+	`
+	_, err = c.db.Exec(batchTables)
+	if err != nil {
+		return err
+	}
+
+	// Pending uploads outlive their video or user until they expire, so the
+	// objects uploaded for them are still cleaned up.
+	pendingUploadsTable := `
+	CREATE TABLE IF NOT EXISTS pending_uploads (
+		id TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		max_size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(pendingUploadsTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "email_verified_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "org_id", "TEXT REFERENCES organizations(id)")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "role", "TEXT NOT NULL DEFAULT 'user'")
+	if err != nil {
+		return err
+	}
+
+	videoDimensionColumns := [][2]string{
+		{"width", "INTEGER NOT NULL DEFAULT 0"},
+		{"height", "INTEGER NOT NULL DEFAULT 0"},
+		{"aspect_ratio", "REAL NOT NULL DEFAULT 0"},
+		{"aspect_class", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, column := range videoDimensionColumns {
+		err = c.addColumnIfMissing("videos", column[0], column[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.addColumnIfMissing("videos", "source_video_id", "TEXT REFERENCES videos(id)")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "preview_url", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "preview_video_url", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"normalized_video_url", "audio_aac_url", "audio_opus_url"} {
+		err = c.addColumnIfMissing("videos", column, "TEXT")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.addColumnIfMissing("videos", "original_video_url", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("videos", "version", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// Files uploaded before versions were kept become the first version,
+	// which takes over the video's reference to them.
+	_, err = c.db.Exec(`
+	INSERT OR IGNORE INTO video_versions (
+		video_id, version, video_url, original_video_url,
+		width, height, aspect_ratio, aspect_class, uploaded_by, created_at
+	)
+	SELECT id, 1, video_url, original_video_url,
+		width, height, aspect_ratio, aspect_class, user_id, updated_at
+	FROM videos
+	WHERE video_url IS NOT NULL AND version = 0
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("UPDATE videos SET version = 1 WHERE video_url IS NOT NULL AND version = 0")
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfMissing("users", "tokens_valid_after", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// addColumnIfMissing lets autoMigrate extend tables that were created by an
+// earlier version of the schema.
+func (c *Client) addColumnIfMissing(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			name         string
+			columnType   string
+			notNull      int
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM assets"); err != nil {
+		return fmt.Errorf("failed to reset table assets: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_storage_usage"); err != nil {
+		return fmt.Errorf("failed to reset table user_storage_usage: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM pending_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table pending_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM batch_upload_items"); err != nil {
+		return fmt.Errorf("failed to reset table batch_upload_items: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM batch_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table batch_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM chapter_suggestions"); err != nil {
+		return fmt.Errorf("failed to reset table chapter_suggestions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_chapters"); err != nil {
+		return fmt.Errorf("failed to reset table video_chapters: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_versions"); err != nil {
+		return fmt.Errorf("failed to reset table video_versions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM watermarks"); err != nil {
+		return fmt.Errorf("failed to reset table watermarks: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organization_invitations"); err != nil {
+		return fmt.Errorf("failed to reset table organization_invitations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM oidc_login_states"); err != nil {
+		return fmt.Errorf("failed to reset table oidc_login_states: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_identities"); err != nil {
+		return fmt.Errorf("failed to reset table user_identities: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM login_failures"); err != nil {
+		return fmt.Errorf("failed to reset table login_failures: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM audit_events"); err != nil {
+		return fmt.Errorf("failed to reset table audit_events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_tokens"); err != nil {
+		return fmt.Errorf("failed to reset table user_tokens: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_recovery_codes"); err != nil {
+		return fmt.Errorf("failed to reset table user_recovery_codes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_totp"); err != nil {
+		return fmt.Errorf("failed to reset table user_totp: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type LoginFailureScope string
+
+const (
+	LoginFailureScopeAccount LoginFailureScope = "account"
+	LoginFailureScopeIP      LoginFailureScope = "ip"
+)
+
+type LoginFailure struct {
+	Scope         LoginFailureScope `json:"scope"`
+	Key           string            `json:"key"`
+	Failures      int               `json:"failures"`
+	LastFailureAt time.Time         `json:"last_failure_at"`
+	LockedUntil   *time.Time        `json:"locked_until"`
+}
+
+// GetLoginFailure returns the failure record for the key, or a zero record
+// if there have been no failures.
+func (c Client) GetLoginFailure(scope LoginFailureScope, key string) (LoginFailure, error) {
+	query := `
+		SELECT scope, key, failures, last_failure_at, locked_until
+		FROM login_failures
+		WHERE scope = ? AND key = ?
+	`
+	var failure LoginFailure
+	err := c.db.QueryRow(query, scope, key).
+		Scan(&failure.Scope, &failure.Key, &failure.Failures, &failure.LastFailureAt, &failure.LockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LoginFailure{Scope: scope, Key: key}, nil
+		}
+		return LoginFailure{}, err
+	}
+	return failure, nil
+}
+
+// RecordLoginFailure increments the failure counter and returns the updated
+// record. Counters whose last failure is older than resetAfter start over.
+func (c Client) RecordLoginFailure(scope LoginFailureScope, key string, resetAfter time.Duration) (LoginFailure, error) {
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO login_failures (scope, key, failures, last_failure_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT(scope, key) DO UPDATE SET
+			failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+			locked_until = CASE WHEN last_failure_at < ? THEN NULL ELSE locked_until END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING scope, key, failures, last_failure_at, locked_until
+	`
+	resetBefore := now.Add(-resetAfter)
+	var failure LoginFailure
+	err := c.db.QueryRow(query, scope, key, now, resetBefore, resetBefore).
+		Scan(&failure.Scope, &failure.Key, &failure.Failures, &failure.LastFailureAt, &failure.LockedUntil)
+	return failure, err
+}
+
+func (c Client) LockLogin(scope LoginFailureScope, key string, until time.Time) error {
+	query := `
+		UPDATE login_failures
+		SET locked_until = ?
+		WHERE scope = ? AND key = ?
+	`
+	_, err := c.db.Exec(query, until, scope, key)
+	return err
+}
+
+func (c Client) ClearLoginFailures(scope LoginFailureScope, key string) error {
+	query := `
+		DELETE FROM login_failures
+		WHERE scope = ? AND key = ?
+	`
+	_, err := c.db.Exec(query, scope, key)
+	return err
+}
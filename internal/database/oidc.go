@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OIDCLoginState struct {
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c Client) CreateOIDCLoginState(state OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state, created_at, nonce, code_verifier, expires_at)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, state.State, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCLoginState deletes and returns the login state so that every
+// state value can complete at most one callback. Expired rows are purged on
+// the way.
+func (c Client) ConsumeOIDCLoginState(state string) (OIDCLoginState, error) {
+	if _, err := c.db.Exec("DELETE FROM oidc_login_states WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return OIDCLoginState{}, err
+	}
+
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state = ?
+		RETURNING state, nonce, code_verifier, expires_at
+	`
+	var s OIDCLoginState
+	err := c.db.QueryRow(query, state).Scan(&s.State, &s.Nonce, &s.CodeVerifier, &s.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OIDCLoginState{}, nil
+		}
+		return OIDCLoginState{}, err
+	}
+	return s, nil
+}
+
+func (c Client) GetUserIdentity(issuer, subject string) (UserIdentity, error) {
+	query := `
+		SELECT issuer, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE issuer = ? AND subject = ?
+	`
+	var identity UserIdentity
+	err := c.db.QueryRow(query, issuer, subject).
+		Scan(&identity.Issuer, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserIdentity{}, nil
+		}
+		return UserIdentity{}, err
+	}
+	return identity, nil
+}
+
+func (c Client) CreateUserIdentity(identity UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, identity.Issuer, identity.Subject, identity.UserID.String(), identity.Email)
+	return err
+}
+
+func (c Client) GetUserIdentities(userID uuid.UUID) ([]UserIdentity, error) {
+	query := `
+		SELECT issuer, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE user_id = ?
+		ORDER BY created_at
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []UserIdentity{}
+	for rows.Next() {
+		var identity UserIdentity
+		if err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.UserID, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
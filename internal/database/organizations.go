@@ -0,0 +1,315 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OrgRole string
+
+const (
+	OrgRoleOwner  OrgRole = "owner"
+	OrgRoleAdmin  OrgRole = "admin"
+	OrgRoleMember OrgRole = "member"
+	OrgRoleViewer OrgRole = "viewer"
+)
+
+func (r OrgRole) Valid() bool {
+	switch r {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember, OrgRoleViewer:
+		return true
+	}
+	return false
+}
+
+// CanManage reports whether the role may invite, remove and re-role members.
+func (r OrgRole) CanManage() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin
+}
+
+// CanEditVideos reports whether the role may create and upload to the
+// organization's videos.
+func (r OrgRole) CanEditVideos() bool {
+	return r == OrgRoleOwner || r == OrgRoleAdmin || r == OrgRoleMember
+}
+
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+}
+
+type OrganizationMembership struct {
+	Organization
+	Role OrgRole `json:"role"`
+}
+
+type OrganizationMember struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      OrgRole   `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrganizationInvitation struct {
+	CreateOrganizationInvitationParams
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+type CreateOrganizationInvitationParams struct {
+	Token     string    `json:"token"`
+	OrgID     uuid.UUID `json:"org_id"`
+	Email     string    `json:"email"`
+	Role      OrgRole   `json:"role"`
+	InvitedBy uuid.UUID `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateOrganization creates the organization and makes ownerID its first owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	id := uuid.New()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO organizations (id, created_at, updated_at, name)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
+	`, id.String(), name)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (org_id, user_id, role, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, id.String(), ownerID.String(), OrgRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+
+	return c.GetOrganization(id)
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+		SELECT id, created_at, updated_at, name
+		FROM organizations
+		WHERE id = ?
+	`
+	var org Organization
+	err := c.db.QueryRow(query, id.String()).Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, nil
+		}
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+func (c Client) GetUserOrganizations(userID uuid.UUID) ([]OrganizationMembership, error) {
+	query := `
+		SELECT o.id, o.created_at, o.updated_at, o.name, m.role
+		FROM organizations o
+		JOIN organization_members m ON o.id = m.org_id
+		WHERE m.user_id = ?
+		ORDER BY o.name
+	`
+	rows, err := c.db.Query(query, userID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []OrganizationMembership{}
+	for rows.Next() {
+		var org OrganizationMembership
+		if err := rows.Scan(&org.ID, &org.CreatedAt, &org.UpdatedAt, &org.Name, &org.Role); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+// GetOrganizationRole returns the user's role in the organization, or an
+// empty role if the user is not a member.
+func (c Client) GetOrganizationRole(orgID, userID uuid.UUID) (OrgRole, error) {
+	query := `
+		SELECT role
+		FROM organization_members
+		WHERE org_id = ? AND user_id = ?
+	`
+	var role OrgRole
+	err := c.db.QueryRow(query, orgID.String(), userID.String()).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.email, m.role, m.created_at
+		FROM organization_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = ?
+		ORDER BY m.created_at
+	`
+	rows, err := c.db.Query(query, orgID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		if err := rows.Scan(&member.OrgID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (c Client) CountOrganizationOwners(orgID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM organization_members
+		WHERE org_id = ? AND role = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, orgID.String(), OrgRoleOwner).Scan(&count)
+	return count, err
+}
+
+// AddOrganizationMember inserts the membership, or updates the role if the
+// user already belongs to the organization.
+func (c Client) AddOrganizationMember(orgID, userID uuid.UUID, role OrgRole) error {
+	query := `
+		INSERT INTO organization_members (org_id, user_id, role, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(org_id, user_id) DO UPDATE SET
+			role = excluded.role,
+			updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, orgID.String(), userID.String(), role)
+	return err
+}
+
+func (c Client) RemoveOrganizationMember(orgID, userID uuid.UUID) error {
+	query := `
+		DELETE FROM organization_members
+		WHERE org_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID.String(), userID.String())
+	return err
+}
+
+func (c Client) CreateOrganizationInvitation(params CreateOrganizationInvitationParams) (OrganizationInvitation, error) {
+	query := `
+		INSERT INTO organization_invitations (
+			token,
+			created_at,
+			org_id,
+			email,
+			role,
+			invited_by,
+			expires_at
+		) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(
+		query,
+		params.Token,
+		params.OrgID.String(),
+		params.Email,
+		params.Role,
+		params.InvitedBy.String(),
+		params.ExpiresAt,
+	)
+	if err != nil {
+		return OrganizationInvitation{}, err
+	}
+
+	return c.GetOrganizationInvitation(params.Token)
+}
+
+func (c Client) GetOrganizationInvitation(token string) (OrganizationInvitation, error) {
+	query := `
+		SELECT token, created_at, org_id, email, role, invited_by, expires_at, accepted_at
+		FROM organization_invitations
+		WHERE token = ?
+	`
+	var inv OrganizationInvitation
+	err := c.db.QueryRow(query, token).Scan(
+		&inv.Token,
+		&inv.CreatedAt,
+		&inv.OrgID,
+		&inv.Email,
+		&inv.Role,
+		&inv.InvitedBy,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OrganizationInvitation{}, nil
+		}
+		return OrganizationInvitation{}, err
+	}
+	return inv, nil
+}
+
+// AcceptOrganizationInvitation marks the invitation as used and adds the
+// user to the organization in a single transaction.
+func (c Client) AcceptOrganizationInvitation(token string, userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orgID string
+	var role OrgRole
+	err = tx.QueryRow(`
+		UPDATE organization_invitations
+		SET accepted_at = CURRENT_TIMESTAMP
+		WHERE token = ? AND accepted_at IS NULL
+		RETURNING org_id, role
+	`, token).Scan(&orgID, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("invitation has already been accepted")
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO organization_members (org_id, user_id, role, created_at, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(org_id, user_id) DO NOTHING
+	`, orgID, userID.String(), role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
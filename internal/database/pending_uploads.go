@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingUpload is a video file a client was allowed to put into the
+// bucket directly. It is processed once the client reports the upload as
+// complete.
+type PendingUpload struct {
+	ID          uuid.UUID `json:"id"`
+	VideoID     uuid.UUID `json:"video_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	ObjectKey   string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	MaxSize     int64     `json:"max_size"`
+	CreatedAt   time.Time `json:"created_at"`
+	// ExpiresAt is when the client can no longer start the upload.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (c Client) CreatePendingUpload(upload PendingUpload) (PendingUpload, error) {
+	_, err := c.db.Exec(`
+		INSERT INTO pending_uploads (id, video_id, user_id, object_key, content_type, max_size, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+	`, upload.ID, upload.VideoID, upload.UserID, upload.ObjectKey, upload.ContentType, upload.MaxSize, upload.ExpiresAt.UTC())
+	if err != nil {
+		return PendingUpload{}, err
+	}
+	return c.GetPendingUpload(upload.ID)
+}
+
+const pendingUploadColumns = `id, video_id, user_id, object_key, content_type, max_size, created_at, expires_at`
+
+func scanPendingUpload(row rowScanner) (PendingUpload, error) {
+	var upload PendingUpload
+	err := row.Scan(
+		&upload.ID,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.ObjectKey,
+		&upload.ContentType,
+		&upload.MaxSize,
+		&upload.CreatedAt,
+		&upload.ExpiresAt,
+	)
+	return upload, err
+}
+
+// GetPendingUpload returns the upload, or a zero PendingUpload if there is
+// none.
+func (c Client) GetPendingUpload(id uuid.UUID) (PendingUpload, error) {
+	upload, err := scanPendingUpload(c.db.QueryRow(`
+		SELECT `+pendingUploadColumns+`
+		FROM pending_uploads
+		WHERE id = ?
+	`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PendingUpload{}, nil
+		}
+		return PendingUpload{}, err
+	}
+	return upload, nil
+}
+
+// GetPendingUploadsExpiredBefore returns the uploads that expired before
+// the time.
+func (c Client) GetPendingUploadsExpiredBefore(before time.Time) ([]PendingUpload, error) {
+	rows, err := c.db.Query(`
+		SELECT `+pendingUploadColumns+`
+		FROM pending_uploads
+		WHERE expires_at < ?
+	`, before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []PendingUpload{}
+	for rows.Next() {
+		upload, err := scanPendingUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// ClaimPendingUpload deletes the upload and returns it, or a zero
+// PendingUpload if it was already gone, so only one caller can process it.
+func (c Client) ClaimPendingUpload(id uuid.UUID) (PendingUpload, error) {
+	upload, err := scanPendingUpload(c.db.QueryRow(`
+		DELETE FROM pending_uploads
+		WHERE id = ?
+		RETURNING `+pendingUploadColumns, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PendingUpload{}, nil
+		}
+		return PendingUpload{}, err
+	}
+	return upload, nil
+}
+
+// CountPendingUploads returns how many uploads the user started that were
+// neither completed nor cleaned up yet.
+func (c Client) CountPendingUploads(userID uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM pending_uploads WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// GetPendingUploadBytes returns how many bytes may still be uploaded for
+// the owner's videos, by whoever started the uploads.
+func (c Client) GetPendingUploadBytes(ownerID uuid.UUID) (int64, error) {
+	query := `
+		SELECT COALESCE(SUM(max_size), 0)
+		FROM pending_uploads
+		WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)
+	`
+	var size int64
+	err := c.db.QueryRow(query, ownerID).Scan(&size)
+	return size, err
+}
+
+func (c Client) DeletePendingUpload(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM pending_uploads WHERE id = ?", id)
+	return err
+}
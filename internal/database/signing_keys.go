@@ -0,0 +1,65 @@
+package database
+
+import "time"
+
+type SigningKey struct {
+	ID         string     `json:"id"`
+	Algorithm  string     `json:"algorithm"`
+	PrivateKey string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RetiredAt  *time.Time `json:"retired_at"`
+}
+
+func (c Client) CreateSigningKey(key SigningKey) error {
+	query := `
+		INSERT INTO jwt_signing_keys (id, algorithm, private_key, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt)
+	return err
+}
+
+// GetSigningKeys returns every stored key, newest first.
+func (c Client) GetSigningKeys() ([]SigningKey, error) {
+	query := `
+		SELECT id, algorithm, private_key, created_at, retired_at
+		FROM jwt_signing_keys
+		ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &key.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RetireSigningKeys marks every key except keepID as retired. Retired keys
+// still verify tokens but are no longer used for signing.
+func (c Client) RetireSigningKeys(keepID string) error {
+	query := `
+		UPDATE jwt_signing_keys
+		SET retired_at = ?
+		WHERE id != ? AND retired_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), keepID)
+	return err
+}
+
+func (c Client) DeleteSigningKeysRetiredBefore(cutoff time.Time) error {
+	query := `
+		DELETE FROM jwt_signing_keys
+		WHERE retired_at IS NOT NULL AND retired_at < ?
+	`
+	_, err := c.db.Exec(query, cutoff)
+	return err
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserTOTP struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Secret       string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	LastUsedStep int64      `json:"-"`
+}
+
+// GetUserTOTP returns the user's TOTP enrollment, or a zero value with a nil
+// UserID if there is none.
+func (c Client) GetUserTOTP(userID uuid.UUID) (UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, created_at, enabled_at, last_used_step
+		FROM user_totp
+		WHERE user_id = ?
+	`
+	var totp UserTOTP
+	err := c.db.QueryRow(query, userID.String()).
+		Scan(&totp.UserID, &totp.Secret, &totp.CreatedAt, &totp.EnabledAt, &totp.LastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserTOTP{}, nil
+		}
+		return UserTOTP{}, err
+	}
+	return totp, nil
+}
+
+// SetPendingTOTP stores a new, not yet enabled secret, replacing any earlier
+// unfinished enrollment. It never overwrites an enabled secret.
+func (c Client) SetPendingTOTP(userID uuid.UUID, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, created_at, last_used_step)
+		VALUES (?, ?, CURRENT_TIMESTAMP, 0)
+		ON CONFLICT(user_id) DO UPDATE SET
+			secret = excluded.secret,
+			created_at = CURRENT_TIMESTAMP,
+			last_used_step = 0
+		WHERE enabled_at IS NULL
+	`
+	_, err := c.db.Exec(query, userID.String(), secret)
+	return err
+}
+
+func (c Client) EnableTOTP(userID uuid.UUID) error {
+	query := `
+		UPDATE user_totp
+		SET enabled_at = CURRENT_TIMESTAMP
+		WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
+
+// UseTOTPStep records that the code for step was used. It returns false if
+// that step or a later one was already used, which rejects replayed codes.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_used_step = ?
+		WHERE user_id = ? AND last_used_step < ?
+	`
+	result, err := c.db.Exec(query, step, userID.String(), step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// DeleteTOTP removes the enrollment and all recovery codes.
+func (c Client) DeleteTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's existing recovery codes and stores
+// the given digests.
+func (c Client) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID.String()); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`
+			INSERT INTO user_recovery_codes (user_id, code_hash, created_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+		`, userID.String(), hash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ConsumeRecoveryCode marks the code as used and reports whether it was valid.
+func (c Client) ConsumeRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes
+		SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := c.db.Exec(query, userID.String(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (c Client) CountUnusedRecoveryCodes(userID uuid.UUID) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM user_recovery_codes
+		WHERE user_id = ? AND used_at IS NULL
+	`
+	var count int
+	err := c.db.QueryRow(query, userID.String()).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// AddUserStorage adjusts the number of bytes stored on behalf of the user.
+// Negative deltas never take the total below zero, and don't recreate the
+// counter of a user that no longer exists.
+func (c Client) AddUserStorage(userID uuid.UUID, delta int64) error {
+	if delta < 0 {
+		query := `
+			UPDATE user_storage_usage
+			SET bytes_stored = MAX(0, bytes_stored + ?), updated_at = CURRENT_TIMESTAMP
+			WHERE user_id = ?
+		`
+		_, err := c.db.Exec(query, delta, userID.String())
+		return err
+	}
+
+	query := `
+		INSERT INTO user_storage_usage (user_id, bytes_stored, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			bytes_stored = bytes_stored + excluded.bytes_stored,
+			updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, userID.String(), delta)
+	return err
+}
+
+func (c Client) GetUserStorage(userID uuid.UUID) (int64, error) {
+	query := `
+		SELECT bytes_stored
+		FROM user_storage_usage
+		WHERE user_id = ?
+	`
+	var bytesStored int64
+	err := c.db.QueryRow(query, userID.String()).Scan(&bytesStored)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return bytesStored, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserTokenPurpose string
+
+const (
+	UserTokenPurposeVerifyEmail   UserTokenPurpose = "verify_email"
+	UserTokenPurposePasswordReset UserTokenPurpose = "password_reset"
+)
+
+type CreateUserTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Purpose   UserTokenPurpose
+	ExpiresAt time.Time
+}
+
+func (c Client) CreateUserToken(params CreateUserTokenParams) error {
+	query := `
+		INSERT INTO user_tokens (token_hash, created_at, user_id, purpose, expires_at)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.TokenHash, params.UserID.String(), params.Purpose, params.ExpiresAt)
+	return err
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns the
+// user it belongs to. It returns uuid.Nil if no such token exists, so each
+// token can be redeemed at most once.
+func (c Client) ConsumeUserToken(tokenHash string, purpose UserTokenPurpose) (uuid.UUID, error) {
+	now := time.Now().UTC()
+	query := `
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+		RETURNING user_id
+	`
+	var userID uuid.UUID
+	err := c.db.QueryRow(query, now, tokenHash, purpose, now).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// InvalidateUserTokens marks every outstanding token of the purpose as used,
+// e.g. so that older reset links stop working once the password changed.
+func (c Client) InvalidateUserTokens(userID uuid.UUID, purpose UserTokenPurpose) error {
+	query := `
+		UPDATE user_tokens
+		SET used_at = ?
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), userID.String(), purpose)
+	return err
+}
@@ -9,9 +9,11 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"`
 	CreateUserParams
 }
 
@@ -53,13 +55,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, email_verified_at, role
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.EmailVerifiedAt, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,15 +77,15 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.email_verified_at, u.role
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token, time.Now().UTC()).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.EmailVerifiedAt, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +119,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, email_verified_at, role
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.EmailVerifiedAt, &user.Role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,11 +139,162 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
-func (c Client) DeleteUser(id uuid.UUID) error {
+func (c Client) UpdateUserPassword(id uuid.UUID, password string) error {
 	query := `
-		DELETE FROM users
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
 	`
+	_, err := c.db.Exec(query, password, id.String())
+	return err
+}
+
+// SetUserTokensValidAfter makes access tokens of the user issued before the
+// time invalid.
+func (c Client) SetUserTokensValidAfter(id uuid.UUID, validAfter time.Time) error {
+	query := `
+		UPDATE users
+		SET tokens_valid_after = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, validAfter.UTC(), id.String())
+	return err
+}
+
+// GetUserTokensValidAfter returns the time set with SetUserTokensValidAfter,
+// or the zero time if there is none or the user doesn't exist.
+func (c Client) GetUserTokensValidAfter(id uuid.UUID) (time.Time, error) {
+	var validAfter sql.NullTime
+	err := c.db.QueryRow("SELECT tokens_valid_after FROM users WHERE id = ?", id.String()).Scan(&validAfter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return validAfter.Time, nil
+}
+
+func (c Client) SetUserRole(id uuid.UUID, role string) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, role, id.String())
+	return err
+}
+
+func (c Client) MarkUserEmailVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND email_verified_at IS NULL
+	`
 	_, err := c.db.Exec(query, id.String())
 	return err
 }
+
+// DeleteUser removes the user together with everything that belongs to them:
+// sessions, tokens, two-factor settings, linked identities, watermark, videos
+// and organization memberships. Organization videos are handed to another
+// owner of the organization and returned with their new owner; the user's
+// other videos are deleted. Organizations left without members are deleted.
+// Audit events are kept but no longer reference the user. Stored assets of
+// the deleted videos must be released by the caller afterwards.
+func (c Client) DeleteUser(id uuid.UUID) ([]Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userID := id.String()
+	rows, err := tx.Query(reassignOrganizationVideos, userID)
+	if err != nil {
+		return nil, err
+	}
+	reassigned := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reassigned = append(reassigned, video)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		"DELETE FROM refresh_tokens WHERE user_id = ?",
+		"DELETE FROM user_tokens WHERE user_id = ?",
+		"DELETE FROM user_recovery_codes WHERE user_id = ?",
+		"DELETE FROM user_totp WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM user_storage_usage WHERE user_id = ?",
+		"DELETE FROM watermarks WHERE scope = 'user' AND scope_id = ?",
+		"UPDATE videos SET source_video_id = NULL WHERE source_video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM video_versions WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM chapter_suggestions WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM video_chapters WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM batch_upload_items WHERE batch_id IN (SELECT id FROM batch_uploads WHERE user_id = ?)",
+		"DELETE FROM batch_uploads WHERE user_id = ?",
+		"UPDATE batch_upload_items SET video_id = NULL WHERE video_id IN (SELECT id FROM videos WHERE user_id = ?)",
+		"DELETE FROM videos WHERE user_id = ?",
+		"DELETE FROM organization_invitations WHERE invited_by = ?",
+		"DELETE FROM organization_members WHERE user_id = ?",
+		"UPDATE audit_events SET user_id = NULL WHERE user_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	emptyOrgs := `
+		SELECT id FROM organizations
+		WHERE id NOT IN (SELECT org_id FROM organization_members)
+	`
+	rows, err = tx.Query(emptyOrgs)
+	if err != nil {
+		return nil, err
+	}
+	orgIDs := []string{}
+	for rows.Next() {
+		var orgID string
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, orgID := range orgIDs {
+		if _, err := tx.Exec("UPDATE videos SET org_id = NULL WHERE org_id = ?", orgID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE batch_uploads SET org_id = NULL WHERE org_id = ?", orgID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM organization_invitations WHERE org_id = ?", orgID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM watermarks WHERE scope = 'org' AND scope_id = ?", orgID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM organizations WHERE id = ?", orgID); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+		return nil, err
+	}
+	return reassigned, tx.Commit()
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VideoVersion is a file that was uploaded for a video. Each version holds
+// a reference to its files until it is pruned, so the video can be rolled
+// back to it.
+type VideoVersion struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Version int       `json:"version"`
+	// VideoURL points into the bucket, so it is left out of the JSON as
+	// well.
+	VideoURL string `json:"-"`
+	// OriginalVideoURL is the file without watermark, see Video.
+	OriginalVideoURL *string `json:"-"`
+	Duration         float64 `json:"duration"`
+	VideoCodec       string  `json:"video_codec"`
+	AudioCodec       string  `json:"audio_codec"`
+	VideoDimensions
+	UploadedBy uuid.UUID `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateVideoVersionParams struct {
+	VideoID          uuid.UUID
+	VideoURL         string
+	OriginalVideoURL *string
+	Duration         float64
+	VideoCodec       string
+	AudioCodec       string
+	VideoDimensions
+	UploadedBy uuid.UUID
+}
+
+const videoVersionColumns = `
+		video_id,
+		version,
+		video_url,
+		original_video_url,
+		duration,
+		video_codec,
+		audio_codec,
+		width,
+		height,
+		aspect_ratio,
+		aspect_class,
+		uploaded_by,
+		created_at`
+
+func scanVideoVersion(row rowScanner) (VideoVersion, error) {
+	var version VideoVersion
+	err := row.Scan(
+		&version.VideoID,
+		&version.Version,
+		&version.VideoURL,
+		&version.OriginalVideoURL,
+		&version.Duration,
+		&version.VideoCodec,
+		&version.AudioCodec,
+		&version.Width,
+		&version.Height,
+		&version.AspectRatio,
+		&version.AspectClass,
+		&version.UploadedBy,
+		&version.CreatedAt,
+	)
+	return version, err
+}
+
+// CreateVideoVersion adds a version numbered one past the video's latest.
+func (c Client) CreateVideoVersion(params CreateVideoVersionParams) (VideoVersion, error) {
+	query := `
+	INSERT INTO video_versions (
+		video_id,
+		version,
+		video_url,
+		original_video_url,
+		duration,
+		video_codec,
+		audio_codec,
+		width,
+		height,
+		aspect_ratio,
+		aspect_class,
+		uploaded_by,
+		created_at
+	)
+	SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP
+	FROM video_versions
+	WHERE video_id = ?
+	RETURNING version
+	`
+	var version int
+	err := c.db.QueryRow(
+		query,
+		params.VideoID,
+		params.VideoURL,
+		params.OriginalVideoURL,
+		params.Duration,
+		params.VideoCodec,
+		params.AudioCodec,
+		params.Width,
+		params.Height,
+		params.AspectRatio,
+		params.AspectClass,
+		params.UploadedBy,
+		params.VideoID,
+	).Scan(&version)
+	if err != nil {
+		return VideoVersion{}, err
+	}
+	return c.GetVideoVersion(params.VideoID, version)
+}
+
+// GetVideoVersion returns the version, or a zero VideoVersion if the video
+// has no such version.
+func (c Client) GetVideoVersion(videoID uuid.UUID, version int) (VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ? AND version = ?
+	`
+	videoVersion, err := scanVideoVersion(c.db.QueryRow(query, videoID, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoVersion{}, nil
+		}
+		return VideoVersion{}, err
+	}
+	return videoVersion, nil
+}
+
+// GetVideoVersions returns the versions of the video, newest first.
+func (c Client) GetVideoVersions(videoID uuid.UUID) ([]VideoVersion, error) {
+	query := `
+	SELECT` + videoVersionColumns + `
+	FROM video_versions
+	WHERE video_id = ?
+	ORDER BY version DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []VideoVersion{}
+	for rows.Next() {
+		version, err := scanVideoVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (c Client) DeleteVideoVersion(videoID uuid.UUID, version int) error {
+	_, err := c.db.Exec("DELETE FROM video_versions WHERE video_id = ? AND version = ?", videoID, version)
+	return err
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -14,18 +15,81 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	// PreviewURL is a short looping animated WebP of the video and
+	// PreviewVideoURL the same as a silent MP4.
+	PreviewURL      *string `json:"preview_url"`
+	PreviewVideoURL *string `json:"preview_video_url"`
+	// OriginalVideoURL is the video without watermark when one was burned
+	// in. Only owners may see it, so it is left out of the JSON.
+	OriginalVideoURL *string `json:"-"`
+	// SourceVideoID is the video this one was clipped from, if any.
+	SourceVideoID *uuid.UUID `json:"source_video_id"`
+	// Version is the VideoVersion the video file belongs to, zero before
+	// the first upload.
+	Version int `json:"version"`
 	CreateVideoParams
+	VideoDimensions
+	VideoRenditions
+}
+
+// MarshalJSON replaces the bucket URLs of the video file and its
+// renditions with their paths on the streaming proxy, so clients can't
+// skip its access checks.
+func (v Video) MarshalJSON() ([]byte, error) {
+	type video Video
+	streamURL := func(objectURL *string, rendition string) *string {
+		if objectURL == nil {
+			return nil
+		}
+		proxyURL := "/api/videos/" + v.ID.String() + "/stream"
+		if rendition != "" {
+			proxyURL += "?rendition=" + rendition
+		}
+		return &proxyURL
+	}
+	return json.Marshal(struct {
+		video
+		VideoURL           *string `json:"video_url"`
+		NormalizedVideoURL *string `json:"normalized_video_url"`
+		AudioAACURL        *string `json:"audio_aac_url"`
+		AudioOpusURL       *string `json:"audio_opus_url"`
+	}{
+		video:              video(v),
+		VideoURL:           streamURL(v.VideoURL, ""),
+		NormalizedVideoURL: streamURL(v.NormalizedVideoURL, "normalized"),
+		AudioAACURL:        streamURL(v.AudioAACURL, "audio_aac"),
+		AudioOpusURL:       streamURL(v.AudioOpusURL, "audio_opus"),
+	})
+}
+
+// VideoRenditions are files derived from the uploaded video. Each is nil
+// when it is turned off or the video has no sound.
+type VideoRenditions struct {
+	// NormalizedVideoURL is the video with its loudness normalized.
+	NormalizedVideoURL *string `json:"normalized_video_url"`
+	// AudioAACURL and AudioOpusURL are the sound alone, for listening.
+	AudioAACURL  *string `json:"audio_aac_url"`
+	AudioOpusURL *string `json:"audio_opus_url"`
+}
+
+// VideoDimensions describe the uploaded video as displayed, i.e. after
+// rotation. They are zero until a video file is uploaded.
+type VideoDimensions struct {
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	AspectRatio float64 `json:"aspect_ratio"`
+	// AspectClass is the nearest common ratio, e.g. "16:9", or "other".
+	AspectClass string `json:"aspect_class"`
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	OrgID       *uuid.UUID `json:"org_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,13 +97,55 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		user_id,
+		org_id,
+		width,
+		height,
+		aspect_ratio,
+		aspect_class,
+		source_video_id,
+		preview_url,
+		preview_video_url,
+		normalized_video_url,
+		audio_aac_url,
+		audio_opus_url,
+		original_video_url,
+		version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.OrgID,
+		&video.Width,
+		&video.Height,
+		&video.AspectRatio,
+		&video.AspectClass,
+		&video.SourceVideoID,
+		&video.PreviewURL,
+		&video.PreviewVideoURL,
+		&video.NormalizedVideoURL,
+		&video.AudioAACURL,
+		&video.AudioOpusURL,
+		&video.OriginalVideoURL,
+		&video.Version,
+	)
+	return video, err
+}
 
-	rows, err := c.db.Query(query, userID)
+func (c Client) queryVideos(query string, args ...any) ([]Video, error) {
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,23 +153,125 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
 
-	return videos, nil
+	return videos, rows.Err()
+}
+
+// GetVideos returns the user's personal videos. Organization videos are
+// listed with GetOrganizationVideos, whoever uploaded them.
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND org_id IS NULL
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// GetVideosUploadedBy returns every video the user owns, personal and
+// organization alike.
+func (c Client) GetVideosUploadedBy(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, userID)
+}
+
+// reassignOrganizationVideos hands the organization videos owned by user ?1
+// to the longest-standing other owner of each organization. Videos of
+// organizations without another owner are left alone.
+const reassignOrganizationVideos = `
+	UPDATE videos
+	SET user_id = (
+		SELECT user_id FROM organization_members
+		WHERE org_id = videos.org_id AND role = 'owner' AND user_id != ?1
+		ORDER BY created_at, user_id
+		LIMIT 1
+	), updated_at = CURRENT_TIMESTAMP
+	WHERE user_id = ?1 AND org_id IS NOT NULL AND EXISTS (
+		SELECT 1 FROM organization_members
+		WHERE org_id = videos.org_id AND role = 'owner' AND user_id != ?1
+	)
+	RETURNING` + videoColumns
+
+func (c Client) GetOrganizationVideos(orgID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE org_id = ?
+	ORDER BY created_at DESC
+	`
+	return c.queryVideos(query, orgID)
+}
+
+// GetVideosWithFiles returns every video that has a video file, grouped by
+// file so videos sharing one are adjacent.
+func (c Client) GetVideosWithFiles() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url IS NOT NULL
+	ORDER BY video_url, created_at
+	`
+	return c.queryVideos(query)
+}
+
+// SetVideoFileDimensions records the dimensions of a video file on every
+// video using it.
+func (c Client) SetVideoFileDimensions(videoURL string, dimensions VideoDimensions) error {
+	query := `
+	UPDATE videos
+	SET width = ?, height = ?, aspect_ratio = ?, aspect_class = ?
+	WHERE video_url = ?
+	`
+	_, err := c.db.Exec(query, dimensions.Width, dimensions.Height, dimensions.AspectRatio, dimensions.AspectClass, videoURL)
+	return err
+}
+
+// MoveVideoFile points the videos, their versions and the asset record of a
+// bucket object at its new key after it has been copied there.
+func (c Client) MoveVideoFile(oldKey, newKey, oldURL, newURL string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+	UPDATE assets
+	SET key = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE storage = ? AND key = ?
+	`, newKey, AssetStorageS3, oldKey); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+	UPDATE videos
+	SET video_url = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE video_url = ?
+	`, newURL, oldURL); err != nil {
+		return err
+	}
+	statements := []string{
+		"UPDATE videos SET original_video_url = ? WHERE original_video_url = ?",
+		"UPDATE video_versions SET video_url = ? WHERE video_url = ?",
+		"UPDATE video_versions SET original_video_url = ? WHERE original_video_url = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, newURL, oldURL); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +283,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		org_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.OrgID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +297,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -125,11 +317,25 @@ func (c Client) UpdateVideo(video Video) error {
 	query := `
 	UPDATE videos
 	SET
+		updated_at = CURRENT_TIMESTAMP,
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		user_id = ?,
+		org_id = ?,
+		width = ?,
+		height = ?,
+		aspect_ratio = ?,
+		aspect_class = ?,
+		source_video_id = ?,
+		preview_url = ?,
+		preview_video_url = ?,
+		normalized_video_url = ?,
+		audio_aac_url = ?,
+		audio_opus_url = ?,
+		original_video_url = ?,
+		version = ?
 	WHERE id = ?
 	`
 
@@ -140,16 +346,46 @@ func (c Client) UpdateVideo(video Video) error {
 		&video.ThumbnailURL,
 		&video.VideoURL,
 		video.UserID,
+		video.OrgID,
+		video.Width,
+		video.Height,
+		video.AspectRatio,
+		video.AspectClass,
+		video.SourceVideoID,
+		video.PreviewURL,
+		video.PreviewVideoURL,
+		video.NormalizedVideoURL,
+		video.AudioAACURL,
+		video.AudioOpusURL,
+		video.OriginalVideoURL,
+		video.Version,
 		video.ID,
 	)
 	return err
 }
 
+// DeleteVideo deletes the video with its versions and chapters. Clips made
+// from it stay but lose their link to it.
 func (c Client) DeleteVideo(id uuid.UUID) error {
-	query := `
-	DELETE FROM videos
-	WHERE id = ?
-	`
-	_, err := c.db.Exec(query, id)
-	return err
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE videos SET source_video_id = NULL WHERE source_video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE batch_upload_items SET video_id = NULL WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	for _, table := range []string{"video_versions", "chapter_suggestions", "video_chapters"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE video_id = ?", id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM videos WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
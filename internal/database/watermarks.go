@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WatermarkScope says whose videos a watermark is burned onto: the
+// personal videos of a user or the videos of an organization.
+type WatermarkScope string
+
+const (
+	WatermarkScopeUser WatermarkScope = "user"
+	WatermarkScopeOrg  WatermarkScope = "org"
+)
+
+type Watermark struct {
+	Scope     WatermarkScope `json:"scope"`
+	ScopeID   uuid.UUID      `json:"scope_id"`
+	ImageURL  string         `json:"image_url"`
+	Position  string         `json:"position"`
+	Opacity   float64        `json:"opacity"`
+	Scale     float64        `json:"scale"`
+	UpdatedBy uuid.UUID      `json:"updated_by"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// GetWatermark returns the watermark of the scope, or a zero Watermark if
+// there is none.
+func (c Client) GetWatermark(scope WatermarkScope, scopeID uuid.UUID) (Watermark, error) {
+	query := `
+		SELECT scope, scope_id, image_url, position, opacity, scale, updated_by, created_at, updated_at
+		FROM watermarks
+		WHERE scope = ? AND scope_id = ?
+	`
+	var watermark Watermark
+	err := c.db.QueryRow(query, scope, scopeID.String()).Scan(
+		&watermark.Scope,
+		&watermark.ScopeID,
+		&watermark.ImageURL,
+		&watermark.Position,
+		&watermark.Opacity,
+		&watermark.Scale,
+		&watermark.UpdatedBy,
+		&watermark.CreatedAt,
+		&watermark.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Watermark{}, nil
+		}
+		return Watermark{}, err
+	}
+	return watermark, nil
+}
+
+// SetWatermark creates or replaces the watermark of the scope.
+func (c Client) SetWatermark(watermark Watermark) (Watermark, error) {
+	query := `
+		INSERT INTO watermarks (scope, scope_id, image_url, position, opacity, scale, updated_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(scope, scope_id) DO UPDATE SET
+			image_url = excluded.image_url,
+			position = excluded.position,
+			opacity = excluded.opacity,
+			scale = excluded.scale,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(
+		query,
+		watermark.Scope,
+		watermark.ScopeID.String(),
+		watermark.ImageURL,
+		watermark.Position,
+		watermark.Opacity,
+		watermark.Scale,
+		watermark.UpdatedBy.String(),
+	)
+	if err != nil {
+		return Watermark{}, err
+	}
+	return c.GetWatermark(watermark.Scope, watermark.ScopeID)
+}
+
+func (c Client) DeleteWatermark(scope WatermarkScope, scopeID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM watermarks WHERE scope = ? AND scope_id = ?", scope, scopeID.String())
+	return err
+}
@@ -0,0 +1,240 @@
+// Package fetch downloads files from user supplied URLs without letting
+// them reach the server's own network.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrUnsupportedURL   = errors.New("only http and https URLs can be fetched")
+	ErrBlockedAddress   = errors.New("address is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrTooLarge         = errors.New("file is too large")
+	ErrBadStatus        = errors.New("unexpected response status")
+)
+
+// blockedPrefixes are public-looking ranges that still lead to internal or
+// translated networks.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local NAT64
+	netip.MustParsePrefix("2002::/16"),      // 6to4
+}
+
+type Options struct {
+	// Allow lists ranges that may be fetched even though they are private,
+	// such as a trusted media server on the local network.
+	Allow        []netip.Prefix
+	MaxRedirects int
+	// MaxAttempts is how many times a download that breaks off is resumed,
+	// counting the first request.
+	MaxAttempts int
+}
+
+// Fetcher downloads files over HTTP(S). It only connects to public
+// addresses, checked after DNS resolution so names can't be pointed at
+// internal hosts, and never through a proxy.
+type Fetcher struct {
+	client      *http.Client
+	allow       []netip.Prefix
+	maxAttempts int
+}
+
+func New(opts Options) *Fetcher {
+	f := &Fetcher{
+		allow:       opts.Allow,
+		maxAttempts: max(opts.MaxAttempts, 1),
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: f.control,
+	}
+	f.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// Allowed reports whether the fetcher may connect to addr.
+func (f *Fetcher) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fetcher) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !f.Allowed(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+// Result describes a finished download.
+type Result struct {
+	Size        int64
+	ContentType string
+	// URL is where the file was found after redirects.
+	URL string
+}
+
+// Download writes the file at rawURL to dst, failing with ErrTooLarge once
+// it exceeds maxBytes. If the connection breaks off and the server supports
+// range requests for an unchanged file, the download picks up where it
+// stopped.
+func (f *Fetcher) Download(ctx context.Context, rawURL string, dst *os.File, maxBytes int64) (Result, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{}, ErrUnsupportedURL
+	}
+	if err := checkURL(u); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{URL: u.String()}
+	validator := ""
+	for attempt := 1; ; attempt++ {
+		resp, err := f.get(ctx, result.URL, result.Size, validator)
+		if err != nil {
+			return Result{}, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusPartialContent && result.Size > 0:
+			if start, ok := rangeStart(resp.Header.Get("Content-Range")); !ok || start != result.Size {
+				resp.Body.Close()
+				return Result{}, fmt.Errorf("%w: range doesn't continue the download", ErrBadStatus)
+			}
+		case resp.StatusCode == http.StatusOK:
+			// A first response, or the file changed and is sent whole.
+			if err := restart(dst); err != nil {
+				resp.Body.Close()
+				return Result{}, err
+			}
+			result.Size = 0
+			result.ContentType = resp.Header.Get("Content-Type")
+			result.URL = resp.Request.URL.String()
+			validator = rangeValidator(resp.Header)
+		default:
+			resp.Body.Close()
+			return Result{}, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+		}
+
+		if resp.ContentLength > 0 && result.Size+resp.ContentLength > maxBytes {
+			resp.Body.Close()
+			return Result{}, ErrTooLarge
+		}
+		n, err := io.Copy(dst, io.LimitReader(resp.Body, maxBytes-result.Size+1))
+		resp.Body.Close()
+		result.Size += n
+		if result.Size > maxBytes {
+			return Result{}, ErrTooLarge
+		}
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil || validator == "" || attempt >= f.maxAttempts {
+			return Result{}, err
+		}
+	}
+}
+
+// get requests the file, from offset on with a validator to resume a
+// download.
+func (f *Fetcher) get(ctx context.Context, rawURL string, offset int64, validator string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	return f.client.Do(req)
+}
+
+// rangeValidator returns what identifies this version of the file for
+// If-Range, or "" if the server doesn't support resuming it.
+func rangeValidator(header http.Header) string {
+	if header.Get("Accept-Ranges") != "bytes" {
+		return ""
+	}
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// rangeStart parses the first byte of a Content-Range such as
+// "bytes 100-199/200".
+func rangeStart(contentRange string) (int64, bool) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	return n, err == nil
+}
+
+func restart(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	return err
+}
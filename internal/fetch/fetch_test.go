@@ -0,0 +1,279 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// loopback allows the httptest servers, which listen on 127.0.0.1.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+
+func download(t *testing.T, f *Fetcher, rawURL string, maxBytes int64) (Result, string, error) {
+	t.Helper()
+	dst, err := os.CreateTemp(t.TempDir(), "download-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	result, err := f.Download(context.Background(), rawURL, dst, maxBytes)
+	content, readErr := os.ReadFile(dst.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return result, string(content), err
+}
+
+func TestAllowed(t *testing.T) {
+	f := New(Options{})
+	blocked := []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254",
+		"0.0.0.0", "100.64.0.1", "::1", "fc00::1", "fe80::1", "::ffff:127.0.0.1",
+		"::ffff:10.0.0.1", "64:ff9b::a00:1", "2002:a00:1::",
+	}
+	for _, addr := range blocked {
+		if f.Allowed(netip.MustParseAddr(addr)) {
+			t.Errorf("Allowed(%s) = true", addr)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:4700:4700::1111"} {
+		if !f.Allowed(netip.MustParseAddr(addr)) {
+			t.Errorf("Allowed(%s) = false", addr)
+		}
+	}
+
+	allowing := New(Options{Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	if !allowing.Allowed(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("Allowed ignores Options.Allow")
+	}
+	if allowing.Allowed(netip.MustParseAddr("192.168.1.1")) {
+		t.Error("Options.Allow lets other private ranges through")
+	}
+}
+
+func TestDownloadBlocksPrivateTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("blocked server was reached: %s", r.URL)
+	}))
+	defer server.Close()
+
+	_, _, err := download(t, New(Options{}), server.URL, 1<<20)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Download(loopback) error = %v, want %v", err, ErrBlockedAddress)
+	}
+
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	_, _, err = download(t, New(Options{}), localhost, 1<<20)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Download(localhost) error = %v, want %v", err, ErrBlockedAddress)
+	}
+
+	for _, rawURL := range []string{"file:///etc/passwd", "ftp://example.com/video.mp4", "http:///video.mp4"} {
+		if _, _, err := download(t, New(Options{}), rawURL, 1<<20); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("Download(%s) error = %v, want %v", rawURL, err, ErrUnsupportedURL)
+		}
+	}
+}
+
+func TestDownloadBlocksRedirectToPrivateAddress(t *testing.T) {
+	// Only 127.0.0.1 is allowed, so 127.0.0.2 stands in for an internal
+	// host the redirect points at.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "127.0.0.2") {
+			t.Errorf("redirect target was reached: %s", r.URL)
+			return
+		}
+		target := url.URL{Scheme: "http", Host: strings.Replace(r.Host, "127.0.0.1", "127.0.0.2", 1), Path: "/internal"}
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, _, err := download(t, New(Options{Allow: loopback, MaxRedirects: 5}), server.URL, 1<<20)
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Download error = %v, want %v", err, ErrBlockedAddress)
+	}
+
+	redirectToFile := httptest.NewServer(http.RedirectHandler("file:///etc/passwd", http.StatusFound))
+	defer redirectToFile.Close()
+	_, _, err = download(t, New(Options{Allow: loopback, MaxRedirects: 5}), redirectToFile.URL, 1<<20)
+	if !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("Download error = %v, want %v", err, ErrUnsupportedURL)
+	}
+}
+
+func TestDownloadRedirectCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hop int
+		fmt.Sscanf(r.URL.Path, "/hop/%d", &hop)
+		if hop == 3 {
+			w.Write([]byte("video"))
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", hop+1), http.StatusFound)
+	}))
+	defer server.Close()
+
+	result, content, err := download(t, New(Options{Allow: loopback, MaxRedirects: 3}), server.URL+"/hop/0", 1<<20)
+	if err != nil || content != "video" {
+		t.Fatalf("Download with 3 redirects = %q, %v", content, err)
+	}
+	if result.URL != server.URL+"/hop/3" {
+		t.Errorf("Result.URL = %s, want the final URL", result.URL)
+	}
+
+	_, _, err = download(t, New(Options{Allow: loopback, MaxRedirects: 2}), server.URL+"/hop/0", 1<<20)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Download with 3 redirects, at most 2 allowed: error = %v, want %v", err, ErrTooManyRedirects)
+	}
+}
+
+func TestDownloadSizeCap(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// Flushing before writing everything leaves out Content-Length.
+			w.Write([]byte(body[:10]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[10:]))
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	f := New(Options{Allow: loopback})
+
+	for _, path := range []string{"/", "/chunked"} {
+		_, content, err := download(t, f, server.URL+path, 99)
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("Download(%s) error = %v, want %v", path, err, ErrTooLarge)
+		}
+		if len(content) > 100 {
+			t.Errorf("Download(%s) wrote %d bytes", path, len(content))
+		}
+
+		result, content, err := download(t, f, server.URL+path, 100)
+		if err != nil || content != body || result.Size != 100 {
+			t.Errorf("Download(%s) at the limit = %d bytes, %v", path, result.Size, err)
+		}
+	}
+}
+
+// flakyServer sends the first half of body and then breaks off the first
+// connection. Later requests are answered by resume.
+func flakyServer(t *testing.T, body string, header http.Header, resume http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	requests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			resume(w, r)
+			return
+		}
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Write([]byte(body[:len(body)/2]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+}
+
+func TestDownloadResumes(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	header := http.Header{"Accept-Ranges": {"bytes"}, "Etag": {`"v1"`}}
+
+	var gotRange, gotIfRange string
+	server := flakyServer(t, body, header, func(w http.ResponseWriter, r *http.Request) {
+		gotRange, gotIfRange = r.Header.Get("Range"), r.Header.Get("If-Range")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 50-99/%d", len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[50:]))
+	})
+	defer server.Close()
+
+	result, content, err := download(t, New(Options{Allow: loopback, MaxAttempts: 2}), server.URL, 1<<20)
+	if err != nil || content != body || result.Size != int64(len(body)) {
+		t.Fatalf("Download = %q, %v; want the whole body", content, err)
+	}
+	if gotRange != "bytes=50-" || gotIfRange != `"v1"` {
+		t.Errorf("resume request had Range %q and If-Range %q", gotRange, gotIfRange)
+	}
+}
+
+func TestDownloadRestartsChangedFile(t *testing.T) {
+	body := strings.Repeat("a", 100)
+	changed := strings.Repeat("b", 80)
+	header := http.Header{"Accept-Ranges": {"bytes"}, "Etag": {`"v1"`}}
+
+	server := flakyServer(t, body, header, func(w http.ResponseWriter, r *http.Request) {
+		// The validator no longer matches, so the whole new file is sent.
+		w.Header().Set("ETag", `"v2"`)
+		w.Write([]byte(changed))
+	})
+	defer server.Close()
+
+	result, content, err := download(t, New(Options{Allow: loopback, MaxAttempts: 2}), server.URL, 1<<20)
+	if err != nil || content != changed || result.Size != int64(len(changed)) {
+		t.Errorf("Download = %q, %v; want the changed file only", content, err)
+	}
+}
+
+func TestDownloadResumeMustContinue(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	header := http.Header{"Accept-Ranges": {"bytes"}, "Etag": {`"v1"`}}
+
+	server := flakyServer(t, body, header, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-99/%d", len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body))
+	})
+	defer server.Close()
+
+	_, _, err := download(t, New(Options{Allow: loopback, MaxAttempts: 2}), server.URL, 1<<20)
+	if !errors.Is(err, ErrBadStatus) {
+		t.Errorf("Download error = %v, want %v", err, ErrBadStatus)
+	}
+}
+
+func TestDownloadWithoutValidatorDoesNotResume(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	weak := http.Header{"Accept-Ranges": {"bytes"}, "Etag": {`W/"v1"`}}
+
+	server := flakyServer(t, body, weak, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("download was resumed with Range %q and If-Range %q", r.Header.Get("Range"), r.Header.Get("If-Range"))
+	})
+	defer server.Close()
+
+	if _, _, err := download(t, New(Options{Allow: loopback, MaxAttempts: 2}), server.URL, 1<<20); err == nil {
+		t.Error("Download of a broken off response succeeded")
+	}
+}
+
+func TestDownloadResumeStaysWithinSizeCap(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	header := http.Header{"Accept-Ranges": {"bytes"}, "Etag": {`"v1"`}}
+
+	server := flakyServer(t, body, header, func(w http.ResponseWriter, r *http.Request) {
+		// Claim more than the rest of the file, without a Content-Length.
+		w.Header().Set("Content-Range", "bytes 50-199/200")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(body[50:]))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(body))
+	})
+	defer server.Close()
+
+	_, _, err := download(t, New(Options{Allow: loopback, MaxAttempts: 2}), server.URL, 120)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Download error = %v, want %v", err, ErrTooLarge)
+	}
+}
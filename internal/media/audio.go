@@ -0,0 +1,86 @@
+package media
+
+import (
+	"context"
+	"fmt"
+)
+
+// AudioCodec is the codec of an audio-only rendition.
+type AudioCodec string
+
+const (
+	// AudioAAC is AAC in an MP4 container, playable everywhere.
+	AudioAAC AudioCodec = "aac"
+	// AudioOpus is Opus in an Ogg container, smaller at the same quality.
+	AudioOpus AudioCodec = "opus"
+)
+
+func ParseAudioCodec(s string) (AudioCodec, error) {
+	switch codec := AudioCodec(s); codec {
+	case AudioAAC, AudioOpus:
+		return codec, nil
+	}
+	return "", fmt.Errorf("unknown audio codec %q", s)
+}
+
+func (c AudioCodec) MediaType() string {
+	if c == AudioOpus {
+		return "audio/ogg"
+	}
+	return "audio/mp4"
+}
+
+func (c AudioCodec) encodeArgs() []string {
+	if c == AudioOpus {
+		return []string{"-c:a", "libopus", "-b:a", "96k", "-f", "ogg"}
+	}
+	return []string{"-c:a", "aac", "-b:a", "128k", "-movflags", "faststart", "-f", "mp4"}
+}
+
+// Loudness is a loudness target: integrated loudness in LUFS, maximum true
+// peak in dBTP and loudness range in LU.
+type Loudness struct {
+	Integrated float64
+	TruePeak   float64
+	Range      float64
+}
+
+// EBUR128 is the target of the EBU R128 broadcast recommendation.
+var EBUR128 = Loudness{Integrated: -23, TruePeak: -1, Range: 7}
+
+// filter is the loudnorm filter for the target. It runs in single pass
+// mode, which adjusts the gain dynamically instead of measuring first.
+// loudnorm works at 192kHz, so the output is resampled to 48kHz.
+func (l Loudness) filter() []string {
+	return []string{
+		"-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", l.Integrated, l.TruePeak, l.Range),
+		"-ar", "48000",
+	}
+}
+
+// NormalizeLoudness writes a faststart MP4 of the input with its audio
+// normalized to the target. The video stream is copied unless transcode is
+// set, in which case it is re-encoded to H.264 like Transcode does.
+func (f *FFmpeg) NormalizeLoudness(ctx context.Context, inputPath, outputPath string, target Loudness, transcode bool) error {
+	args := []string{"-i", inputPath}
+	if transcode {
+		args = append(args, encodeArgs...)
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0", "-c:v", "copy", "-c:a", "aac", "-b:a", "128k")
+	}
+	args = append(args, target.filter()...)
+	args = append(args, "-movflags", "faststart", "-f", "mp4", outputPath)
+	return f.runFFmpeg(ctx, args...)
+}
+
+// ExtractAudio writes the first audio stream of the input on its own,
+// normalized to target unless it is nil.
+func (f *FFmpeg) ExtractAudio(ctx context.Context, inputPath, outputPath string, codec AudioCodec, target *Loudness) error {
+	args := []string{"-i", inputPath, "-map", "0:a:0", "-vn"}
+	if target != nil {
+		args = append(args, target.filter()...)
+	}
+	args = append(args, codec.encodeArgs()...)
+	args = append(args, outputPath)
+	return f.runFFmpeg(ctx, args...)
+}
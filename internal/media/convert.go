@@ -0,0 +1,94 @@
+package media
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Codecs that can be copied into an MP4 container and played by browsers.
+var (
+	mp4VideoCodecs = map[string]bool{"h264": true}
+	mp4AudioCodecs = map[string]bool{"aac": true, "mp3": true}
+)
+
+// NeedsTranscode reports whether the probed file has to be re-encoded to
+// produce a browser-playable MP4, or whether its streams can be copied.
+func NeedsTranscode(result ProbeResult) bool {
+	video, ok := result.VideoStream()
+	if !ok || !mp4VideoCodecs[video.CodecName] {
+		return true
+	}
+	if audio, ok := result.AudioStream(); ok && !mp4AudioCodecs[audio.CodecName] {
+		return true
+	}
+	return false
+}
+
+// Remux copies the streams into an MP4 with the index at the front, so
+// playback can start before the whole file is downloaded.
+func (f *FFmpeg) Remux(ctx context.Context, inputPath, outputPath string) error {
+	return f.runFFmpeg(ctx,
+		"-i", inputPath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c", "copy",
+		"-movflags", "faststart",
+		"-f", "mp4", outputPath,
+	)
+}
+
+// encodeArgs re-encode the first video and audio stream to H.264 and AAC.
+var encodeArgs = []string{
+	"-map", "0:v:0", "-map", "0:a:0?",
+	"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+	"-c:a", "aac", "-b:a", "128k",
+}
+
+// Transcode re-encodes the first video and audio stream to H.264 and AAC in
+// a faststart MP4.
+func (f *FFmpeg) Transcode(ctx context.Context, inputPath, outputPath string) error {
+	args := []string{"-i", inputPath}
+	args = append(args, encodeArgs...)
+	args = append(args, "-movflags", "faststart", "-f", "mp4", outputPath)
+	return f.runFFmpeg(ctx, args...)
+}
+
+// Trim cuts the part between start and end into a faststart MP4. With
+// streamCopy the streams are copied, which is only exact when start is on a
+// keyframe; otherwise the output starts at the keyframe before it. Without
+// it the video is re-encoded and cut exactly.
+func (f *FFmpeg) Trim(ctx context.Context, inputPath, outputPath string, start, end time.Duration, streamCopy bool) error {
+	args := []string{
+		"-ss", formatSeconds(start),
+		"-i", inputPath,
+		"-t", formatSeconds(end - start),
+	}
+	if streamCopy {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?", "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else {
+		args = append(args, encodeArgs...)
+	}
+	args = append(args, "-movflags", "faststart", "-f", "mp4", outputPath)
+	return f.runFFmpeg(ctx, args...)
+}
+
+// ExtractFrame writes the frame at the given offset as a JPEG image.
+func (f *FFmpeg) ExtractFrame(ctx context.Context, inputPath, outputPath string, at time.Duration) error {
+	return f.runFFmpeg(ctx,
+		"-ss", formatSeconds(at),
+		"-i", inputPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-f", "image2", outputPath,
+	)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+func (f *FFmpeg) runFFmpeg(ctx context.Context, args ...string) error {
+	args = append([]string{"-y", "-v", "error"}, args...)
+	_, err := f.executor.Run(ctx, f.ffmpegPath, args...)
+	return err
+}
@@ -0,0 +1,171 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Executor runs media tools with a bound on how many run at once. Jobs wait
+// in line for a slot, are killed after a timeout, and are cancelled when
+// their context is, e.g. because the client disconnected.
+type Executor struct {
+	slots   chan struct{}
+	timeout time.Duration
+
+	queued    atomic.Int64
+	running   atomic.Int64
+	maxQueued atomic.Int64
+	started   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	timedOut  atomic.Int64
+	cancelled atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// NewExecutor returns an executor running at most concurrency jobs at once,
+// each limited to timeout. A zero timeout means no limit.
+func NewExecutor(concurrency int, timeout time.Duration) *Executor {
+	return &Executor{
+		slots:   make(chan struct{}, max(concurrency, 1)),
+		timeout: timeout,
+	}
+}
+
+// ToolError is returned when a media tool fails. It carries the tail of the
+// tool's stderr, which usually says what was wrong with the input.
+type ToolError struct {
+	Tool     string
+	ExitCode int
+	TimedOut bool
+	Stderr   string
+	Err      error
+}
+
+func (e *ToolError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s timed out", e.Tool)
+	}
+	if errors.Is(e.Err, context.Canceled) {
+		return fmt.Sprintf("%s was cancelled", e.Tool)
+	}
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s failed: %v", e.Tool, e.Err)
+	}
+	return fmt.Sprintf("%s failed with exit code %d: %s", e.Tool, e.ExitCode, e.Stderr)
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// maxStderr limits how much of a tool's stderr is kept in a ToolError.
+const maxStderr = 4096
+
+// Run waits for a free slot and runs the tool, returning its stdout.
+func (e *Executor) Run(ctx context.Context, tool string, args ...string) ([]byte, error) {
+	queuedAt := time.Now()
+	depth := e.queued.Add(1)
+	for {
+		highest := e.maxQueued.Load()
+		if depth <= highest || e.maxQueued.CompareAndSwap(highest, depth) {
+			break
+		}
+	}
+	select {
+	case e.slots <- struct{}{}:
+		e.queued.Add(-1)
+	case <-ctx.Done():
+		e.queued.Add(-1)
+		e.cancelled.Add(1)
+		return nil, ctx.Err()
+	}
+	defer func() { <-e.slots }()
+	e.started.Add(1)
+	e.waitNanos.Add(int64(time.Since(queuedAt)))
+
+	e.running.Add(1)
+	defer e.running.Add(-1)
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	command := exec.CommandContext(ctx, tool, args...)
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	err := command.Run()
+	if err == nil {
+		e.completed.Add(1)
+		return stdout.Bytes(), nil
+	}
+
+	toolErr := &ToolError{
+		Tool:     tool,
+		ExitCode: -1,
+		Stderr:   tailString(strings.TrimSpace(stderr.String()), maxStderr),
+		Err:      err,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		toolErr.ExitCode = exitErr.ExitCode()
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		toolErr.TimedOut = true
+		toolErr.Err = context.DeadlineExceeded
+		e.timedOut.Add(1)
+	case errors.Is(ctx.Err(), context.Canceled):
+		toolErr.Err = context.Canceled
+		e.cancelled.Add(1)
+	default:
+		e.failed.Add(1)
+	}
+	return nil, toolErr
+}
+
+func tailString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
+
+type ExecutorStats struct {
+	Concurrency   int     `json:"concurrency"`
+	Running       int64   `json:"running"`
+	QueueDepth    int64   `json:"queue_depth"`
+	MaxQueueDepth int64   `json:"max_queue_depth"`
+	Completed     int64   `json:"completed"`
+	Failed        int64   `json:"failed"`
+	TimedOut      int64   `json:"timed_out"`
+	Cancelled     int64   `json:"cancelled"`
+	AvgWaitMillis float64 `json:"avg_wait_ms"`
+}
+
+func (e *Executor) Stats() ExecutorStats {
+	stats := ExecutorStats{
+		Concurrency:   cap(e.slots),
+		Running:       e.running.Load(),
+		QueueDepth:    e.queued.Load(),
+		MaxQueueDepth: e.maxQueued.Load(),
+		Completed:     e.completed.Load(),
+		Failed:        e.failed.Load(),
+		TimedOut:      e.timedOut.Load(),
+		Cancelled:     e.cancelled.Load(),
+	}
+	if started := e.started.Load(); started > 0 {
+		stats.AvgWaitMillis = float64(e.waitNanos.Load()) / float64(started) / float64(time.Millisecond)
+	}
+	return stats
+}
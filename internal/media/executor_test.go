@@ -0,0 +1,167 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is the fake media tool run by the executor tests. It
+// does nothing unless the test binary is started by one of them.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("MEDIA_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	switch args[1] {
+	case "sleep":
+		duration, _ := time.ParseDuration(args[2])
+		time.Sleep(duration)
+		fmt.Print("done")
+	case "fail":
+		fmt.Fprint(os.Stderr, strings.Repeat("x", 2*maxStderr)+"\nInvalid data found when processing input")
+		os.Exit(3)
+	}
+	os.Exit(0)
+}
+
+// runHelper runs the fake tool with the executor.
+func runHelper(t *testing.T, ctx context.Context, e *Executor, args ...string) ([]byte, error) {
+	t.Helper()
+	return e.Run(ctx, os.Args[0], append([]string{"-test.run=TestHelperProcess", "--"}, args...)...)
+}
+
+func TestExecutorLimitsConcurrency(t *testing.T) {
+	t.Setenv("MEDIA_HELPER_PROCESS", "1")
+	e := NewExecutor(2, 0)
+	const jobs = 5
+	const sleep = 200 * time.Millisecond
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, jobs)
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stdout, err := runHelper(t, context.Background(), e, "sleep", sleep.String())
+			if err == nil && string(stdout) != "done" {
+				err = fmt.Errorf("stdout = %q", stdout)
+			}
+			errs <- err
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	maxRunning := int64(0)
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case <-time.After(10 * time.Millisecond):
+			maxRunning = max(maxRunning, e.Stats().Running)
+		}
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("%d jobs ran at once, want at most 2", maxRunning)
+	}
+	// Five jobs two at a time take at least three rounds.
+	if elapsed := time.Since(start); elapsed < 3*sleep {
+		t.Errorf("jobs finished in %v, faster than two at a time", elapsed)
+	}
+	stats := e.Stats()
+	if stats.Completed != jobs || stats.MaxQueueDepth < jobs-2 || stats.Running != 0 || stats.QueueDepth != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestExecutorTimeout(t *testing.T) {
+	t.Setenv("MEDIA_HELPER_PROCESS", "1")
+	e := NewExecutor(1, 100*time.Millisecond)
+
+	start := time.Now()
+	_, err := runHelper(t, context.Background(), e, "sleep", "10s")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("tool was killed after %v", elapsed)
+	}
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) || !toolErr.TimedOut || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want a timeout", err)
+	}
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("error = %q", err)
+	}
+	if stats := e.Stats(); stats.TimedOut != 1 || stats.Failed != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestExecutorKeepsStderrTail(t *testing.T) {
+	t.Setenv("MEDIA_HELPER_PROCESS", "1")
+	e := NewExecutor(1, 0)
+
+	_, err := runHelper(t, context.Background(), e, "fail")
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) {
+		t.Fatalf("Run = %v, want a ToolError", err)
+	}
+	if toolErr.ExitCode != 3 || toolErr.TimedOut {
+		t.Errorf("exit code = %d, timed out = %v", toolErr.ExitCode, toolErr.TimedOut)
+	}
+	if !strings.HasPrefix(toolErr.Stderr, "...") || !strings.HasSuffix(toolErr.Stderr, "Invalid data found when processing input") {
+		t.Errorf("stderr = %.40q...", toolErr.Stderr)
+	}
+	if len(toolErr.Stderr) != maxStderr+len("...") {
+		t.Errorf("kept %d bytes of stderr, want the last %d", len(toolErr.Stderr), maxStderr)
+	}
+	if !strings.Contains(err.Error(), "exit code 3") {
+		t.Errorf("error = %.60q", err)
+	}
+	if stats := e.Stats(); stats.Failed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestExecutorCancelsQueuedJobs(t *testing.T) {
+	t.Setenv("MEDIA_HELPER_PROCESS", "1")
+	e := NewExecutor(1, 0)
+	running := make(chan error)
+	go func() {
+		_, err := runHelper(t, context.Background(), e, "sleep", "300ms")
+		running <- err
+	}()
+	for e.Stats().Running == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := runHelper(t, ctx, e, "sleep", "0s"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("queued Run = %v, want the context's error", err)
+	}
+	if err := <-running; err != nil {
+		t.Fatal(err)
+	}
+	if stats := e.Stats(); stats.Cancelled != 1 || stats.Completed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
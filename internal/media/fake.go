@@ -0,0 +1,175 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FakeFrame is what Fake.ExtractFrame writes instead of a real image.
+var FakeFrame = []byte("fake frame")
+
+// Fake is a Toolkit that doesn't run anything. Probe returns Result for
+// every file and conversions copy the input unchanged, so the output only
+// depends on the input. Calls records each operation in order.
+type Fake struct {
+	Result        ProbeResult
+	KeyframeTimes []time.Duration
+	SceneChanges  []SceneChange
+	ProbeErr      error
+	ConvertErr    error
+
+	mu    sync.Mutex
+	Calls []FakeCall
+}
+
+type FakeCall struct {
+	Op    string
+	Input string
+}
+
+// FakeVideo is a probe result for an H.264/AAC MP4 of the given size.
+func FakeVideo(width, height int, duration float64) ProbeResult {
+	return ProbeResult{
+		FormatName: "mov,mp4,m4a,3gp,3g2,mj2",
+		Duration:   duration,
+		Streams: []Stream{
+			{Index: 0, CodecType: "video", CodecName: "h264", Width: width, Height: height},
+			{Index: 1, CodecType: "audio", CodecName: "aac"},
+		},
+	}
+}
+
+func (f *Fake) Probe(ctx context.Context, path string) (ProbeResult, error) {
+	f.record("probe", path)
+	if f.ProbeErr != nil {
+		return ProbeResult{}, f.ProbeErr
+	}
+	return f.Result, nil
+}
+
+func (f *Fake) Keyframes(ctx context.Context, path string) ([]time.Duration, error) {
+	f.record("keyframes", path)
+	if f.ProbeErr != nil {
+		return nil, f.ProbeErr
+	}
+	return f.KeyframeTimes, nil
+}
+
+// DetectScenes returns the SceneChanges above threshold.
+func (f *Fake) DetectScenes(ctx context.Context, path string, threshold float64) ([]SceneChange, error) {
+	f.record("scenes", path)
+	if f.ProbeErr != nil {
+		return nil, f.ProbeErr
+	}
+	changes := []SceneChange{}
+	for _, change := range f.SceneChanges {
+		if change.Score > threshold {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (f *Fake) Remux(ctx context.Context, inputPath, outputPath string) error {
+	f.record("remux", inputPath)
+	return f.copy(inputPath, outputPath)
+}
+
+func (f *Fake) Transcode(ctx context.Context, inputPath, outputPath string) error {
+	f.record("transcode", inputPath)
+	return f.copy(inputPath, outputPath)
+}
+
+// TranscodeWithWatermark appends the watermark image to the input, so the
+// output differs from the original.
+func (f *Fake) TranscodeWithWatermark(ctx context.Context, inputPath, outputPath string, watermark Watermark) error {
+	f.record("watermark", inputPath)
+	if err := f.copy(inputPath, outputPath); err != nil {
+		return err
+	}
+	image, err := os.ReadFile(watermark.ImagePath)
+	if err != nil {
+		return err
+	}
+	output, err := os.OpenFile(outputPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := output.Write(image); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
+
+func (f *Fake) Trim(ctx context.Context, inputPath, outputPath string, start, end time.Duration, streamCopy bool) error {
+	if streamCopy {
+		f.record("trim_copy", inputPath)
+	} else {
+		f.record("trim_encode", inputPath)
+	}
+	return f.copy(inputPath, outputPath)
+}
+
+func (f *Fake) ExtractFrame(ctx context.Context, inputPath, outputPath string, at time.Duration) error {
+	f.record("extract_frame", inputPath)
+	if f.ConvertErr != nil {
+		return f.ConvertErr
+	}
+	return os.WriteFile(outputPath, FakeFrame, 0644)
+}
+
+func (f *Fake) NormalizeLoudness(ctx context.Context, inputPath, outputPath string, target Loudness, transcode bool) error {
+	f.record("normalize_loudness", inputPath)
+	return f.copy(inputPath, outputPath)
+}
+
+// ExtractAudio writes "fake audio" followed by the codec.
+func (f *Fake) ExtractAudio(ctx context.Context, inputPath, outputPath string, codec AudioCodec, target *Loudness) error {
+	f.record("extract_audio_"+string(codec), inputPath)
+	if f.ConvertErr != nil {
+		return f.ConvertErr
+	}
+	return os.WriteFile(outputPath, []byte("fake audio "+string(codec)), 0644)
+}
+
+// Preview writes "fake preview" followed by the format and segments, so
+// different options give different files.
+func (f *Fake) Preview(ctx context.Context, inputPath, outputPath string, format PreviewFormat, duration time.Duration, options PreviewOptions) error {
+	f.record("preview_"+string(format), inputPath)
+	if f.ConvertErr != nil {
+		return f.ConvertErr
+	}
+	content := fmt.Sprintf("fake preview %s %v", format, options.Segments(duration))
+	return os.WriteFile(outputPath, []byte(content), 0644)
+}
+
+func (f *Fake) record(op, input string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls = append(f.Calls, FakeCall{Op: op, Input: input})
+}
+
+func (f *Fake) copy(inputPath, outputPath string) error {
+	if f.ConvertErr != nil {
+		return f.ConvertErr
+	}
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
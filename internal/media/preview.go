@@ -0,0 +1,113 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PreviewFormat string
+
+const (
+	// PreviewWebP is an animated WebP image.
+	PreviewWebP PreviewFormat = "webp"
+	// PreviewMP4 is a silent H.264 MP4 meant to be played muted in a loop.
+	PreviewMP4 PreviewFormat = "mp4"
+)
+
+func (f PreviewFormat) MediaType() string {
+	if f == PreviewWebP {
+		return "image/webp"
+	}
+	return "video/mp4"
+}
+
+// PreviewStrategy decides which parts of a video go into its preview.
+type PreviewStrategy string
+
+const (
+	// PreviewStart takes the beginning of the video.
+	PreviewStart PreviewStrategy = "start"
+	// PreviewMiddle takes the middle of the video, skipping intros.
+	PreviewMiddle PreviewStrategy = "middle"
+	// PreviewSpread joins short segments spread over the whole video.
+	PreviewSpread PreviewStrategy = "spread"
+)
+
+func ParsePreviewStrategy(s string) (PreviewStrategy, error) {
+	switch strategy := PreviewStrategy(s); strategy {
+	case PreviewStart, PreviewMiddle, PreviewSpread:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown preview strategy %q", s)
+}
+
+type PreviewOptions struct {
+	Strategy PreviewStrategy
+	// Length is the total length of the preview.
+	Length time.Duration
+	// SegmentCount is how many parts PreviewSpread joins.
+	SegmentCount int
+	// Width of the preview; the height follows the aspect ratio.
+	Width int
+	FPS   int
+}
+
+// Segment is a part of the source video.
+type Segment struct {
+	Start  time.Duration
+	Length time.Duration
+}
+
+// Segments returns the parts of a video of the given duration that make up
+// its preview.
+func (o PreviewOptions) Segments(duration time.Duration) []Segment {
+	if duration <= o.Length {
+		return []Segment{{Start: 0, Length: duration}}
+	}
+	switch o.Strategy {
+	case PreviewMiddle:
+		return []Segment{{Start: (duration - o.Length) / 2, Length: o.Length}}
+	case PreviewSpread:
+		count := max(o.SegmentCount, 1)
+		length := o.Length / time.Duration(count)
+		segments := make([]Segment, count)
+		for i := range segments {
+			center := duration * time.Duration(2*i+1) / time.Duration(2*count)
+			start := min(max(center-length/2, 0), duration-length)
+			segments[i] = Segment{Start: start, Length: length}
+		}
+		return segments
+	}
+	return []Segment{{Start: 0, Length: o.Length}}
+}
+
+// Preview renders the segments options picks from a video of the given
+// duration, joined and without sound, as a looping preview.
+func (f *FFmpeg) Preview(ctx context.Context, inputPath, outputPath string, format PreviewFormat, duration time.Duration, options PreviewOptions) error {
+	segments := options.Segments(duration)
+	args := []string{}
+	inputs := strings.Builder{}
+	for i, segment := range segments {
+		args = append(args,
+			"-ss", formatSeconds(segment.Start),
+			"-t", formatSeconds(segment.Length),
+			"-i", inputPath,
+		)
+		fmt.Fprintf(&inputs, "[%d:v:0]", i)
+	}
+	filter := fmt.Sprintf("%sconcat=n=%d:v=1:a=0,fps=%d,scale=%d:-2[preview]", inputs.String(), len(segments), options.FPS, options.Width)
+	args = append(args, "-filter_complex", filter, "-map", "[preview]", "-an")
+
+	switch format {
+	case PreviewWebP:
+		args = append(args, "-c:v", "libwebp", "-q:v", "60", "-loop", "0", "-f", "webp")
+	case PreviewMP4:
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p", "-movflags", "faststart", "-f", "mp4")
+	default:
+		return fmt.Errorf("unknown preview format %q", format)
+	}
+	args = append(args, outputPath)
+	return f.runFFmpeg(ctx, args...)
+}
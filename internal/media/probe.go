@@ -0,0 +1,174 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidMedia is returned when a file is not a playable video in the
+// container it claims to be.
+var ErrInvalidMedia = errors.New("invalid media")
+
+type Stream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Tags      struct {
+		Rotate string `json:"rotate"`
+	} `json:"tags"`
+	SideData []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// Rotation returns how far the stream is rotated for display, as 0, 90, 180
+// or 270 degrees. Phones record in sensor orientation and store the
+// rotation either in a display matrix or, in older files, a rotate tag.
+func (s Stream) Rotation() int {
+	degrees := 0.0
+	for _, sideData := range s.SideData {
+		if sideData.Rotation != 0 {
+			degrees = sideData.Rotation
+			break
+		}
+	}
+	if degrees == 0 && s.Tags.Rotate != "" {
+		if rotate, err := strconv.ParseFloat(s.Tags.Rotate, 64); err == nil {
+			degrees = rotate
+		}
+	}
+	quarterTurns := int(math.Round(degrees / 90))
+	return ((quarterTurns%4 + 4) % 4) * 90
+}
+
+// DisplaySize returns the width and height the stream is shown at, i.e.
+// with the rotation applied.
+func (s Stream) DisplaySize() (width, height int) {
+	if s.Rotation()%180 != 0 {
+		return s.Height, s.Width
+	}
+	return s.Width, s.Height
+}
+
+type ProbeResult struct {
+	// FormatName is ffprobe's comma separated list of matching demuxers,
+	// e.g. "mov,mp4,m4a,3gp,3g2,mj2".
+	FormatName string
+	Duration   float64
+	Streams    []Stream
+}
+
+// VideoStream returns the first video stream, ignoring cover art.
+func (p ProbeResult) VideoStream() (Stream, bool) {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "video" && stream.CodecName != "mjpeg" && stream.CodecName != "png" {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+func (p ProbeResult) AudioStream() (Stream, bool) {
+	for _, stream := range p.Streams {
+		if stream.CodecType == "audio" {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+// Probe runs ffprobe on the file.
+func (f *FFmpeg) Probe(ctx context.Context, path string) (ProbeResult, error) {
+	type ffprobeOutput struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+		} `json:"format"`
+		Streams []Stream `json:"streams"`
+	}
+
+	stdout, err := f.executor.Run(ctx, f.ffprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	if err != nil {
+		var toolErr *ToolError
+		if errors.As(err, &toolErr) && toolErr.ExitCode > 0 {
+			return ProbeResult{}, fmt.Errorf("%w: %w", ErrInvalidMedia, toolErr)
+		}
+		return ProbeResult{}, err
+	}
+
+	var output ffprobeOutput
+	if err := json.Unmarshal(stdout, &output); err != nil {
+		return ProbeResult{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
+	}
+	result := ProbeResult{
+		FormatName: output.Format.FormatName,
+		Streams:    output.Streams,
+	}
+	if output.Format.Duration != "" {
+		duration, err := strconv.ParseFloat(output.Format.Duration, 64)
+		if err == nil {
+			result.Duration = duration
+		}
+	}
+	return result, nil
+}
+
+// Keyframes returns the timestamps of the keyframes of the first video
+// stream. It reads packet flags only, without decoding.
+func (f *FFmpeg) Keyframes(ctx context.Context, path string) ([]time.Duration, error) {
+	stdout, err := f.executor.Run(ctx, f.ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,flags",
+		"-of", "csv=p=0",
+		path,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	keyframes := []time.Duration{}
+	for _, line := range strings.Split(string(stdout), "\n") {
+		ptsTime, flags, ok := strings.Cut(strings.TrimSpace(line), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(ptsTime, 64)
+		if err != nil {
+			continue
+		}
+		keyframes = append(keyframes, time.Duration(seconds*float64(time.Second)))
+	}
+	return keyframes, nil
+}
+
+// Validate checks that the probed file is a video with a duration and that
+// ffprobe agrees with the container detected from its magic bytes.
+func Validate(result ProbeResult, container Container) error {
+	formats := strings.Split(result.FormatName, ",")
+	matches := false
+	for _, format := range formats {
+		if format == container.probeFormat() {
+			matches = true
+			break
+		}
+	}
+	if !matches {
+		return fmt.Errorf("%w: file looks like %s but ffprobe reads it as %q", ErrInvalidMedia, container, result.FormatName)
+	}
+	if _, ok := result.VideoStream(); !ok {
+		return fmt.Errorf("%w: no video stream", ErrInvalidMedia)
+	}
+	if result.Duration <= 0 {
+		return fmt.Errorf("%w: no duration", ErrInvalidMedia)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package media
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	video := Stream{CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080}
+	audio := Stream{CodecType: "audio", CodecName: "aac"}
+	coverArt := Stream{CodecType: "video", CodecName: "mjpeg"}
+	mp4 := "mov,mp4,m4a,3gp,3g2,mj2"
+
+	tests := []struct {
+		name      string
+		result    ProbeResult
+		container Container
+		valid     bool
+	}{
+		{"mp4", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{video, audio}}, ContainerMP4, true},
+		{"quicktime", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{video}}, ContainerMOV, true},
+		{"webm", ProbeResult{FormatName: "matroska,webm", Duration: 10, Streams: []Stream{video}}, ContainerWebM, true},
+		{"matroska", ProbeResult{FormatName: "matroska,webm", Duration: 10, Streams: []Stream{video}}, ContainerMatroska, true},
+		{"webm claiming mp4", ProbeResult{FormatName: "matroska,webm", Duration: 10, Streams: []Stream{video}}, ContainerMP4, false},
+		{"mp4 claiming webm", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{video}}, ContainerWebM, false},
+		{"image claiming mp4", ProbeResult{FormatName: "png_pipe", Duration: 10, Streams: []Stream{video}}, ContainerMP4, false},
+		{"unknown container", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{video}}, ContainerUnknown, false},
+		{"audio only", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{audio}}, ContainerMP4, false},
+		{"cover art only", ProbeResult{FormatName: mp4, Duration: 10, Streams: []Stream{coverArt, audio}}, ContainerMP4, false},
+		{"no duration", ProbeResult{FormatName: mp4, Streams: []Stream{video}}, ContainerMP4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.result, tt.container)
+			if tt.valid && err != nil {
+				t.Errorf("Validate = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidMedia) {
+				t.Errorf("Validate = %v, want ErrInvalidMedia", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SceneChange is a frame that differs enough from the one before it to
+// likely start a new scene.
+type SceneChange struct {
+	At time.Duration
+	// Score is ffmpeg's scene score, from 0 (same picture) to 1.
+	Score float64
+}
+
+// DetectScenes returns the frames whose scene score exceeds threshold, in
+// order.
+func (f *FFmpeg) DetectScenes(ctx context.Context, inputPath string, threshold float64) ([]SceneChange, error) {
+	output, err := f.executor.Run(ctx, f.ffmpegPath,
+		"-v", "error",
+		"-i", inputPath,
+		"-an",
+		"-filter:v", fmt.Sprintf("select='gt(scene,%g)',metadata=print:file=-", threshold),
+		"-f", "null", "-",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseSceneChanges(output)
+}
+
+// parseSceneChanges reads the output of the metadata filter, a header line
+// per frame followed by its metadata:
+//
+//	frame:3    pts:9216    pts_time:0.36
+//	lavfi.scene_score=0.521349
+func parseSceneChanges(output []byte) ([]SceneChange, error) {
+	changes := []SceneChange{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "lavfi.scene_score="); ok {
+			if len(changes) == 0 {
+				return nil, fmt.Errorf("scene score before the first frame")
+			}
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scene score %q", value)
+			}
+			changes[len(changes)-1].Score = score
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			value, ok := strings.CutPrefix(field, "pts_time:")
+			if !ok {
+				continue
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid frame time %q", value)
+			}
+			changes = append(changes, SceneChange{At: time.Duration(seconds * float64(time.Second))})
+		}
+	}
+	return changes, scanner.Err()
+}
@@ -0,0 +1,109 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Container is a video container format recognised from a file's leading
+// bytes.
+type Container string
+
+const (
+	ContainerUnknown  Container = ""
+	ContainerMP4      Container = "mp4"
+	ContainerMOV      Container = "mov"
+	ContainerMatroska Container = "matroska"
+	ContainerWebM     Container = "webm"
+)
+
+// SniffLength is how many leading bytes Sniff needs to see.
+const SniffLength = 4096
+
+var ebmlMagic = []byte{0x1a, 0x45, 0xdf, 0xa3}
+
+// QuickTime files written by older tools may start with one of these atoms
+// instead of an ftyp box.
+var quickTimeAtoms = map[string]bool{
+	"moov": true,
+	"mdat": true,
+	"wide": true,
+	"free": true,
+	"skip": true,
+	"pnot": true,
+}
+
+// Sniff identifies the container from the start of a file. It only looks at
+// magic bytes; use Probe to check that the rest of the file is valid.
+func Sniff(header []byte) Container {
+	if bytes.HasPrefix(header, ebmlMagic) {
+		return sniffEBML(header)
+	}
+	if len(header) < 12 {
+		return ContainerUnknown
+	}
+
+	boxSize := binary.BigEndian.Uint32(header[0:4])
+	boxType := string(header[4:8])
+	if boxType == "ftyp" && boxSize >= 16 {
+		if string(header[8:12]) == "qt  " {
+			return ContainerMOV
+		}
+		return ContainerMP4
+	}
+	if quickTimeAtoms[boxType] && boxSize >= 8 {
+		return ContainerMOV
+	}
+	return ContainerUnknown
+}
+
+// sniffEBML tells Matroska and WebM apart by the DocType element, which
+// follows the EBML header magic within the first few dozen bytes.
+func sniffEBML(header []byte) Container {
+	docTypeID := []byte{0x42, 0x82}
+	i := bytes.Index(header, docTypeID)
+	if i < 0 || i+3 > len(header) {
+		return ContainerUnknown
+	}
+	// The DocType is short, so its size is always encoded in a single byte.
+	size := int(header[i+2] & 0x7f)
+	start := i + 3
+	if header[i+2]&0x80 == 0 || start+size > len(header) {
+		return ContainerUnknown
+	}
+	switch string(header[start : start+size]) {
+	case "webm":
+		return ContainerWebM
+	case "matroska":
+		return ContainerMatroska
+	}
+	return ContainerUnknown
+}
+
+// MediaType returns the MIME type of files in the container.
+func (c Container) MediaType() string {
+	switch c {
+	case ContainerMP4:
+		return "video/mp4"
+	case ContainerMOV:
+		return "video/quicktime"
+	case ContainerMatroska:
+		return "video/x-matroska"
+	case ContainerWebM:
+		return "video/webm"
+	}
+	return "application/octet-stream"
+}
+
+// probeFormat is the demuxer ffprobe reports for files in the container.
+func (c Container) probeFormat() string {
+	switch c {
+	case ContainerMP4, ContainerMOV:
+		return "mp4"
+	case ContainerMatroska:
+		return "matroska"
+	case ContainerWebM:
+		return "webm"
+	}
+	return ""
+}
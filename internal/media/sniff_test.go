@@ -0,0 +1,55 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// box returns an ISO base media box header with the given type and, for
+// ftyp boxes, major brand.
+func box(size uint32, boxType, brand string) []byte {
+	header := binary.BigEndian.AppendUint32(nil, size)
+	header = append(header, boxType...)
+	header = append(header, brand...)
+	return append(header, make([]byte, 8)...)
+}
+
+// ebml returns an EBML header declaring the DocType.
+func ebml(docType string) []byte {
+	header := append([]byte{}, ebmlMagic...)
+	header = append(header, 0x9f, 0x42, 0x86, 0x81, 0x01)
+	header = append(header, 0x42, 0x82, 0x80|byte(len(docType)))
+	return append(header, docType...)
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   Container
+	}{
+		{"mp4", box(24, "ftyp", "isom"), ContainerMP4},
+		{"mp4 brand", box(32, "ftyp", "mp42"), ContainerMP4},
+		{"quicktime brand", box(20, "ftyp", "qt  "), ContainerMOV},
+		{"quicktime moov first", box(1024, "moov", ""), ContainerMOV},
+		{"quicktime wide atom", box(8, "wide", ""), ContainerMOV},
+		{"webm", ebml("webm"), ContainerWebM},
+		{"matroska", ebml("matroska"), ContainerMatroska},
+		{"other ebml doctype", ebml("other"), ContainerUnknown},
+		{"ebml without doctype", ebmlMagic, ContainerUnknown},
+		{"truncated doctype", ebml("matroska")[:14], ContainerUnknown},
+		{"ftyp box too small", box(8, "ftyp", "isom"), ContainerUnknown},
+		{"atom too small", box(4, "moov", ""), ContainerUnknown},
+		{"short file", []byte("ftyp"), ContainerUnknown},
+		{"empty", nil, ContainerUnknown},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), ContainerUnknown},
+		{"text", []byte("not a video at all"), ContainerUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sniff(tt.header); got != tt.want {
+				t.Errorf("Sniff = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
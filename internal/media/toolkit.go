@@ -0,0 +1,47 @@
+package media
+
+import (
+	"context"
+	"time"
+)
+
+// Toolkit is the set of media operations the server needs. FFmpeg is the
+// real implementation; Fake stands in for it where the binaries aren't
+// available.
+type Toolkit interface {
+	Probe(ctx context.Context, path string) (ProbeResult, error)
+	Keyframes(ctx context.Context, path string) ([]time.Duration, error)
+	Remux(ctx context.Context, inputPath, outputPath string) error
+	Transcode(ctx context.Context, inputPath, outputPath string) error
+	TranscodeWithWatermark(ctx context.Context, inputPath, outputPath string, watermark Watermark) error
+	DetectScenes(ctx context.Context, inputPath string, threshold float64) ([]SceneChange, error)
+	Trim(ctx context.Context, inputPath, outputPath string, start, end time.Duration, streamCopy bool) error
+	// ExtractFrame writes the frame at the given offset as a JPEG image.
+	ExtractFrame(ctx context.Context, inputPath, outputPath string, at time.Duration) error
+	NormalizeLoudness(ctx context.Context, inputPath, outputPath string, target Loudness, transcode bool) error
+	ExtractAudio(ctx context.Context, inputPath, outputPath string, codec AudioCodec, target *Loudness) error
+	Preview(ctx context.Context, inputPath, outputPath string, format PreviewFormat, duration time.Duration, options PreviewOptions) error
+}
+
+// FFmpeg runs ffmpeg and ffprobe through an Executor.
+type FFmpeg struct {
+	executor    *Executor
+	ffmpegPath  string
+	ffprobePath string
+}
+
+// NewFFmpeg returns a toolkit running the given binaries, which are looked
+// up on PATH when empty.
+func NewFFmpeg(executor *Executor, ffmpegPath, ffprobePath string) *FFmpeg {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	if ffprobePath == "" {
+		ffprobePath = "ffprobe"
+	}
+	return &FFmpeg{
+		executor:    executor,
+		ffmpegPath:  ffmpegPath,
+		ffprobePath: ffprobePath,
+	}
+}
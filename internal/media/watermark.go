@@ -0,0 +1,93 @@
+package media
+
+import (
+	"context"
+	"fmt"
+)
+
+// WatermarkPosition is the corner, or the center, a watermark is placed in.
+type WatermarkPosition string
+
+const (
+	WatermarkTopLeft     WatermarkPosition = "top-left"
+	WatermarkTopRight    WatermarkPosition = "top-right"
+	WatermarkBottomLeft  WatermarkPosition = "bottom-left"
+	WatermarkBottomRight WatermarkPosition = "bottom-right"
+	WatermarkCenter      WatermarkPosition = "center"
+)
+
+// watermarkMargin is the distance from the edges, relative to the shorter
+// side of the video.
+const watermarkMargin = "min(W,H)*0.03"
+
+// overlayPosition returns the x and y expressions of the overlay filter.
+func (p WatermarkPosition) overlayPosition() (string, string, bool) {
+	left, top := watermarkMargin, watermarkMargin
+	right, bottom := "W-w-"+watermarkMargin, "H-h-"+watermarkMargin
+	switch p {
+	case WatermarkTopLeft:
+		return left, top, true
+	case WatermarkTopRight:
+		return right, top, true
+	case WatermarkBottomLeft:
+		return left, bottom, true
+	case WatermarkBottomRight:
+		return right, bottom, true
+	case WatermarkCenter:
+		return "(W-w)/2", "(H-h)/2", true
+	}
+	return "", "", false
+}
+
+func (p WatermarkPosition) Valid() bool {
+	_, _, ok := p.overlayPosition()
+	return ok
+}
+
+// Watermark is an image burned onto a video.
+type Watermark struct {
+	ImagePath string
+	Position  WatermarkPosition
+	// Opacity of the image, from 0 (invisible) to 1.
+	Opacity float64
+	// Scale is the width of the image relative to the width of the video.
+	Scale float64
+}
+
+func (w Watermark) Validate() error {
+	if !w.Position.Valid() {
+		return fmt.Errorf("unknown position %q", w.Position)
+	}
+	if w.Opacity <= 0 || w.Opacity > 1 {
+		return fmt.Errorf("opacity must be above 0 and at most 1")
+	}
+	if w.Scale <= 0 || w.Scale > 1 {
+		return fmt.Errorf("scale must be above 0 and at most 1")
+	}
+	return nil
+}
+
+// TranscodeWithWatermark re-encodes the input like Transcode does, with the
+// watermark image overlaid on every frame.
+func (f *FFmpeg) TranscodeWithWatermark(ctx context.Context, inputPath, outputPath string, watermark Watermark) error {
+	x, y, ok := watermark.Position.overlayPosition()
+	if !ok {
+		return fmt.Errorf("unknown watermark position %q", watermark.Position)
+	}
+	filter := fmt.Sprintf(
+		"[1:v]format=rgba,colorchannelmixer=aa=%g[logo];"+
+			"[logo][0:v]scale2ref=w=main_w*%g:h=ow/a[logo][video];"+
+			"[video][logo]overlay=x=%s:y=%s,format=yuv420p[out]",
+		watermark.Opacity, watermark.Scale, x, y,
+	)
+	return f.runFFmpeg(ctx,
+		"-i", inputPath,
+		"-i", watermark.ImagePath,
+		"-filter_complex", filter,
+		"-map", "[out]", "-map", "0:a:0?",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "faststart",
+		"-f", "mp4", outputPath,
+	)
+}
@@ -0,0 +1,42 @@
+package media
+
+import "testing"
+
+func TestWatermarkValidate(t *testing.T) {
+	valid := Watermark{Position: WatermarkBottomRight, Opacity: 0.8, Scale: 0.15}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate = %v", err)
+	}
+	for _, position := range []WatermarkPosition{WatermarkTopLeft, WatermarkTopRight, WatermarkBottomLeft, WatermarkCenter} {
+		watermark := valid
+		watermark.Position = position
+		if err := watermark.Validate(); err != nil {
+			t.Errorf("Validate with %s = %v", position, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Watermark)
+	}{
+		{"unknown position", func(w *Watermark) { w.Position = "middle" }},
+		{"no position", func(w *Watermark) { w.Position = "" }},
+		{"invisible", func(w *Watermark) { w.Opacity = 0 }},
+		{"opacity above 1", func(w *Watermark) { w.Opacity = 1.5 }},
+		{"negative opacity", func(w *Watermark) { w.Opacity = -0.5 }},
+		{"no scale", func(w *Watermark) { w.Scale = 0 }},
+		{"wider than the video", func(w *Watermark) { w.Scale = 1.2 }},
+	}
+	for _, tt := range tests {
+		watermark := valid
+		tt.change(&watermark)
+		if err := watermark.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", tt.name, watermark)
+		}
+	}
+
+	edge := Watermark{Position: WatermarkCenter, Opacity: 1, Scale: 1}
+	if err := edge.Validate(); err != nil {
+		t.Errorf("Validate with opacity and scale 1 = %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "http://localhost:8091/api/oidc/callback"
+
+func newTestProvider(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("tubely")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	issuer.SetIdentity(oidctest.Identity{Subject: "user-1", Email: "user@example.com", EmailVerified: true})
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:      issuer.URL,
+		ClientID:    "tubely",
+		RedirectURL: redirectURL,
+	})
+	return issuer, provider
+}
+
+// authorize starts a login and returns the code the issuer sent back.
+func authorize(t *testing.T, issuer *oidctest.Issuer, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state = %q, want %q", got, state)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// The example from RFC 7636, appendix B.
+	got := oidc.CodeChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallengeS256 = %q, want %q", got, want)
+	}
+}
+
+func TestLoginFlow(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	code := authorize(t, issuer, provider, "state", "nonce", "verifier")
+	rawIDToken, err := provider.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, "nonce")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "user@example.com" || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "verifier"); err == nil {
+		t.Error("Exchange redeemed the same code twice")
+	}
+}
+
+func TestExchangeRequiresCodeVerifier(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+
+	code := authorize(t, issuer, provider, "state", "nonce", "verifier")
+	if _, err := provider.Exchange(context.Background(), code, "another verifier"); err == nil {
+		t.Error("Exchange succeeded with the wrong code verifier")
+	}
+}
+
+func TestVerifyIDTokenChecksNonce(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	code := authorize(t, issuer, provider, "state", "nonce", "verifier")
+	rawIDToken, err := provider.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, rawIDToken, "another nonce"); err == nil {
+		t.Error("VerifyIDToken accepted a token for another nonce")
+	}
+}
+
+func TestVerifyIDTokenChecksClaims(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   issuer.URL,
+			"aud":   "tubely",
+			"sub":   "user-1",
+			"nonce": "nonce",
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Minute).Unix(),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"another issuer", func(c jwt.MapClaims) { c["iss"] = "https://issuer.example.com" }},
+		{"another audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+			rawIDToken, err := issuer.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := provider.VerifyIDToken(context.Background(), rawIDToken, "nonce"); err == nil {
+				t.Error("VerifyIDToken accepted the token")
+			}
+		})
+	}
+
+	rawIDToken, err := issuer.SignIDToken(valid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), rawIDToken, "nonce"); err != nil {
+		t.Errorf("VerifyIDToken rejected a valid token: %v", err)
+	}
+}
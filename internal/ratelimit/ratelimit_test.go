@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fakeClock is a MemoryStore clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestStore() (*MemoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func take(t *testing.T, store *MemoryStore, key string, limit Limit) Result {
+	t.Helper()
+	result, err := store.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestTakeRefills(t *testing.T) {
+	store, clock := newTestStore()
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	for i := range 3 {
+		result := take(t, store, "key", limit)
+		if !result.Allowed || result.Remaining != 2-i || result.Limit != 3 {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, result, 2-i)
+		}
+	}
+	result := take(t, store, "key", limit)
+	if result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+		t.Fatalf("request over the limit = %+v, want a retry after 1s", result)
+	}
+
+	// Tokens come back one per second, not all at once at the end of the
+	// period.
+	clock.Advance(500 * time.Millisecond)
+	if result := take(t, store, "key", limit); result.Allowed || result.RetryAfter != 500*time.Millisecond {
+		t.Errorf("request after 0.5s = %+v, want a retry after 0.5s", result)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if result := take(t, store, "key", limit); !result.Allowed || result.Remaining != 0 || result.RetryAfter != 0 {
+		t.Errorf("request after 1s = %+v, want allowed", result)
+	}
+
+	// A bucket never holds more than a burst.
+	clock.Advance(time.Hour)
+	if result := take(t, store, "key", limit); !result.Allowed || result.Remaining != 2 || result.Reset != time.Second {
+		t.Errorf("request after an hour = %+v, want a full bucket", result)
+	}
+
+	if result := take(t, store, "other", limit); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("other key = %+v, want its own bucket", result)
+	}
+}
+
+func TestTakeWithoutLimit(t *testing.T) {
+	store, _ := newTestStore()
+	for _, limit := range []Limit{{}, {Requests: 0, Period: time.Minute}, {Requests: 10}} {
+		for range 20 {
+			if result := take(t, store, "key", limit); !result.Allowed {
+				t.Fatalf("Take(%+v) = %+v, want no limit", limit, result)
+			}
+		}
+	}
+	if len(store.buckets) != 0 {
+		t.Errorf("kept %d buckets for unlimited requests", len(store.buckets))
+	}
+}
+
+func TestSweep(t *testing.T) {
+	store, clock := newTestStore()
+	fast := Limit{Requests: 1, Period: time.Second}
+	slow := Limit{Requests: 1, Period: time.Hour}
+
+	take(t, store, "fast", fast)
+	take(t, store, "slow", slow)
+	clock.Advance(sweepInterval + time.Second)
+	take(t, store, "new", fast)
+
+	if _, ok := store.buckets["fast"]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := store.buckets["slow"]; !ok {
+		t.Error("bucket that is still refilling was dropped")
+	}
+	if result := take(t, store, "slow", slow); result.Allowed {
+		t.Errorf("sweep reset a bucket that is still refilling: %+v", result)
+	}
+
+	// Sweeps run at most once per interval.
+	clock.Advance(time.Second + time.Millisecond)
+	take(t, store, "other", fast)
+	if _, ok := store.buckets["new"]; !ok {
+		t.Error("buckets were swept again within the interval")
+	}
+}
+
+func TestLimitJSON(t *testing.T) {
+	var limit Limit
+	if err := json.Unmarshal([]byte(`{"requests": 5, "period": "1m"}`), &limit); err != nil {
+		t.Fatal(err)
+	}
+	if limit != (Limit{Requests: 5, Period: time.Minute}) {
+		t.Errorf("limit = %+v", limit)
+	}
+	if got := limit.Policy(); got != "5;w=60" {
+		t.Errorf("Policy = %q, want 5;w=60", got)
+	}
+	if err := json.Unmarshal([]byte(`{"requests": 5, "period": "soon"}`), &limit); err == nil {
+		t.Error("invalid period was accepted")
+	}
+}
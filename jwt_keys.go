@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// signingKeyRefreshInterval controls how often each instance reloads the key
+// set from the database, which is how keys rotated by another instance are
+// picked up.
+const signingKeyRefreshInterval = 5 * time.Minute
+
+type jwtKeyConfig struct {
+	algorithm        auth.SigningAlgorithm
+	rotationInterval time.Duration
+}
+
+// retiredKeyRetention keeps retired keys around long enough to verify every
+// token they signed.
+const retiredKeyRetention = accessTokenTTL + time.Hour
+
+// loadSigningKeys reloads the keyring from the database, generating a new
+// signing key first if there is none, the configured algorithm changed, or
+// the active key is older than the rotation interval.
+func (cfg *apiConfig) loadSigningKeys() error {
+	stored, err := cfg.db.GetSigningKeys()
+	if err != nil {
+		return fmt.Errorf("couldn't load signing keys: %w", err)
+	}
+
+	// Keys are sorted newest first, and the newest key is always the one
+	// used for signing so that concurrent rotations converge.
+	if len(stored) == 0 ||
+		stored[0].Algorithm != string(cfg.jwtKeyConfig.algorithm) ||
+		(cfg.jwtKeyConfig.rotationInterval > 0 && time.Since(stored[0].CreatedAt) > cfg.jwtKeyConfig.rotationInterval) {
+		return cfg.rotateSigningKey()
+	}
+
+	keys := make([]auth.SigningKey, 0, len(stored))
+	for _, key := range stored {
+		parsed, err := auth.ParsePrivateKey(key.ID, auth.SigningAlgorithm(key.Algorithm), key.PrivateKey, key.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("couldn't parse signing key %s: %w", key.ID, err)
+		}
+		keys = append(keys, parsed)
+	}
+
+	return cfg.jwtKeys.Replace(keys, stored[0].ID)
+}
+
+func (cfg *apiConfig) rotateSigningKey() error {
+	key, err := auth.GenerateSigningKey(cfg.jwtKeyConfig.algorithm)
+	if err != nil {
+		return fmt.Errorf("couldn't generate signing key: %w", err)
+	}
+	encoded, err := auth.MarshalPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("couldn't encode signing key: %w", err)
+	}
+
+	err = cfg.db.CreateSigningKey(database.SigningKey{
+		ID:         key.ID,
+		Algorithm:  string(key.Algorithm),
+		PrivateKey: encoded,
+		CreatedAt:  key.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't save signing key: %w", err)
+	}
+	if err := cfg.db.RetireSigningKeys(key.ID); err != nil {
+		return fmt.Errorf("couldn't retire old signing keys: %w", err)
+	}
+	if err := cfg.db.DeleteSigningKeysRetiredBefore(time.Now().UTC().Add(-retiredKeyRetention)); err != nil {
+		return fmt.Errorf("couldn't delete expired signing keys: %w", err)
+	}
+
+	log.Printf("Rotated JWT signing key, new key ID: %s (%s)", key.ID, key.Algorithm)
+	return cfg.loadSigningKeys()
+}
+
+// runSigningKeyRotation periodically reloads and, when due, rotates the
+// signing keys until ctx is cancelled.
+func (cfg *apiConfig) runSigningKeyRotation(ctx context.Context) {
+	ticker := time.NewTicker(signingKeyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cfg.loadSigningKeys(); err != nil {
+				log.Printf("Couldn't refresh JWT signing keys: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// loginThrottlePolicy describes how failed logins for one scope are slowed
+// down. After freeAttempts failures every further attempt has to wait
+// baseDelay, doubling per failure up to maxDelay; at lockoutThreshold the key
+// is locked for lockoutDuration.
+type loginThrottlePolicy struct {
+	freeAttempts     int
+	baseDelay        time.Duration
+	maxDelay         time.Duration
+	lockoutThreshold int
+	lockoutDuration  time.Duration
+}
+
+// loginFailureWindow is how long failures are remembered after the last one.
+const loginFailureWindow = time.Hour * 24
+
+var loginThrottlePolicies = map[database.LoginFailureScope]loginThrottlePolicy{
+	database.LoginFailureScopeAccount: {
+		freeAttempts:     3,
+		baseDelay:        time.Second,
+		maxDelay:         time.Minute,
+		lockoutThreshold: 10,
+		lockoutDuration:  time.Minute * 15,
+	},
+	database.LoginFailureScopeIP: {
+		freeAttempts:     20,
+		baseDelay:        time.Second,
+		maxDelay:         time.Minute * 5,
+		lockoutThreshold: 100,
+		lockoutDuration:  time.Hour,
+	},
+}
+
+func (p loginThrottlePolicy) delay(failures int) time.Duration {
+	if failures <= p.freeAttempts {
+		return 0
+	}
+	delay := p.baseDelay
+	for i := p.freeAttempts + 1; i < failures && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.maxDelay)
+}
+
+// retryAt returns when the next attempt is allowed for the record.
+func (p loginThrottlePolicy) retryAt(failure database.LoginFailure) time.Time {
+	next := failure.LastFailureAt.Add(p.delay(failure.Failures))
+	if failure.LockedUntil != nil && failure.LockedUntil.After(next) {
+		next = *failure.LockedUntil
+	}
+	return next
+}
+
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginThrottleKeys(email, ip string) map[database.LoginFailureScope]string {
+	return map[database.LoginFailureScope]string{
+		database.LoginFailureScopeAccount: loginAccountKey(email),
+		database.LoginFailureScopeIP:      ip,
+	}
+}
+
+// checkLoginThrottle returns how long the caller has to wait before another
+// login attempt for this email and IP is allowed, or zero.
+func (cfg *apiConfig) checkLoginThrottle(email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now().UTC()
+	for scope, key := range loginThrottleKeys(email, ip) {
+		failure, err := cfg.db.GetLoginFailure(scope, key)
+		if err != nil {
+			return 0, err
+		}
+		if failure.Failures == 0 || now.Sub(failure.LastFailureAt) > loginFailureWindow {
+			continue
+		}
+		if remaining := loginThrottlePolicies[scope].retryAt(failure).Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed attempt against the email and IP,
+// locking either of them once its threshold is reached.
+func (cfg *apiConfig) recordLoginFailure(email, ip string, userID *uuid.UUID) error {
+	for scope, key := range loginThrottleKeys(email, ip) {
+		policy := loginThrottlePolicies[scope]
+		failure, err := cfg.db.RecordLoginFailure(scope, key, loginFailureWindow)
+		if err != nil {
+			return err
+		}
+		if failure.Failures < policy.lockoutThreshold {
+			continue
+		}
+		if failure.LockedUntil != nil && failure.LockedUntil.After(time.Now().UTC()) {
+			continue
+		}
+
+		lockedUntil := time.Now().UTC().Add(policy.lockoutDuration)
+		if err := cfg.db.LockLogin(scope, key, lockedUntil); err != nil {
+			return err
+		}
+
+		var eventUserID *uuid.UUID
+		if scope == database.LoginFailureScopeAccount {
+			eventUserID = userID
+		}
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			EventType: database.AuditEventLoginLockout,
+			UserID:    eventUserID,
+			IP:        ip,
+			Details:   fmt.Sprintf("%s %q locked until %s after %d failed logins", scope, key, lockedUntil.Format(time.RFC3339), failure.Failures),
+		})
+		if err != nil {
+			log.Printf("Couldn't record lockout audit event: %v", err)
+		}
+	}
+	return nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func respondWithRetryAfter(w http.ResponseWriter, wait time.Duration, msg string) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
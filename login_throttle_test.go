@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func login(t *testing.T, cfg *apiConfig, email, password, ip string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := newJSONRequest(t, http.MethodPost, "/api/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	cfg.handlerLogin(rec, req)
+	return rec
+}
+
+// failLogins records failures without waiting out the delays between them.
+func failLogins(t *testing.T, cfg *apiConfig, email, ip string, n int) {
+	t.Helper()
+	for range n {
+		if err := cfg.recordLoginFailure(email, ip, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkThrottled checks that a login with the right password is refused for
+// about wait.
+func checkThrottled(t *testing.T, cfg *apiConfig, email, ip string, wait time.Duration) {
+	t.Helper()
+	rec := login(t, cfg, email, "password", ip)
+	decodeResponse(t, rec, http.StatusTooManyRequests, nil)
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil || time.Duration(retryAfter)*time.Second > wait || time.Duration(retryAfter)*time.Second < wait-5*time.Second {
+		t.Errorf("Retry-After = %q, want about %v", rec.Header().Get("Retry-After"), wait)
+	}
+}
+
+func TestLoginThrottleDelay(t *testing.T) {
+	policy := loginThrottlePolicies[database.LoginFailureScopeAccount]
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginAccountLockout(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	policy := loginThrottlePolicies[database.LoginFailureScopeAccount]
+
+	decodeResponse(t, login(t, cfg, user.Email, "wrong", "192.0.2.1"), http.StatusUnauthorized, nil)
+	failLogins(t, cfg, user.Email, "192.0.2.1", policy.freeAttempts-1)
+	decodeResponse(t, login(t, cfg, user.Email, "password", "192.0.2.1"), http.StatusOK, nil)
+
+	// Failures are counted per account, from any address and however the
+	// email is written.
+	for i := range policy.lockoutThreshold {
+		failLogins(t, cfg, " USER@example.com ", fmt.Sprintf("198.51.100.%d", i), 1)
+	}
+	checkThrottled(t, cfg, user.Email, "203.0.113.1", policy.lockoutDuration)
+
+	events, err := cfg.db.GetAuditEvents(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EventType != database.AuditEventLoginLockout || events[0].UserID != nil {
+		t.Errorf("audit events = %+v, want one lockout", events)
+	}
+}
+
+func TestLoginIPLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	policy := loginThrottlePolicies[database.LoginFailureScopeIP]
+
+	// Guessing one password for many accounts is slowed down by address.
+	for i := range policy.freeAttempts + 1 {
+		failLogins(t, cfg, fmt.Sprintf("guess%d@example.com", i), "192.0.2.1", 1)
+	}
+	checkThrottled(t, cfg, user.Email, "192.0.2.1", policy.baseDelay)
+	decodeResponse(t, login(t, cfg, user.Email, "password", "192.0.2.2"), http.StatusOK, nil)
+
+	for i := policy.freeAttempts + 1; i < policy.lockoutThreshold; i++ {
+		failLogins(t, cfg, fmt.Sprintf("guess%d@example.com", i), "192.0.2.1", 1)
+	}
+	checkThrottled(t, cfg, user.Email, "192.0.2.1", policy.lockoutDuration)
+}
+
+func TestLoginThrottlesUnknownEmails(t *testing.T) {
+	cfg := newTestConfig(t)
+	policy := loginThrottlePolicies[database.LoginFailureScopeAccount]
+
+	for i := range policy.freeAttempts + 1 {
+		ip := fmt.Sprintf("192.0.2.%d", i)
+		decodeResponse(t, login(t, cfg, "nobody@example.com", "guess", ip), http.StatusUnauthorized, nil)
+	}
+	// Otherwise the throttle would tell which emails have accounts.
+	checkThrottled(t, cfg, "nobody@example.com", "198.51.100.1", policy.baseDelay)
+}
+
+func TestLoginLockoutSurvivesRestart(t *testing.T) {
+	cfg := newTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	policy := loginThrottlePolicies[database.LoginFailureScopeAccount]
+	failLogins(t, cfg, user.Email, "192.0.2.1", policy.lockoutThreshold)
+
+	db, err := database.NewClient(filepath.Join(filepath.Dir(cfg.assetsRoot), "tubely.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := *cfg
+	restarted.db = db
+	checkThrottled(t, &restarted, user.Email, "203.0.113.1", policy.lockoutDuration)
+}
+
+func TestAdminUnlockLogin(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.adminAPIKey = "admin-key"
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	failLogins(t, cfg, user.Email, "192.0.2.1", loginThrottlePolicies[database.LoginFailureScopeIP].lockoutThreshold)
+	unlock := func(apiKey string, params map[string]string) *httptest.ResponseRecorder {
+		req := newJSONRequest(t, http.MethodPost, "/admin/login_lockouts/unlock", "", params)
+		if apiKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+apiKey)
+		}
+		rec := httptest.NewRecorder()
+		cfg.handlerAdminUnlockLogin(rec, req)
+		return rec
+	}
+
+	decodeResponse(t, unlock("", map[string]string{"email": user.Email}), http.StatusUnauthorized, nil)
+	decodeResponse(t, unlock("wrong", map[string]string{"email": user.Email}), http.StatusUnauthorized, nil)
+	decodeResponse(t, unlock("admin-key", map[string]string{}), http.StatusBadRequest, nil)
+
+	// Unlocking the account leaves the address locked.
+	decodeResponse(t, unlock("admin-key", map[string]string{"email": "User@Example.com"}), http.StatusNoContent, nil)
+	decodeResponse(t, login(t, cfg, user.Email, "password", "198.51.100.1"), http.StatusOK, nil)
+	checkThrottled(t, cfg, user.Email, "192.0.2.1", loginThrottlePolicies[database.LoginFailureScopeIP].lockoutDuration)
+
+	decodeResponse(t, unlock("admin-key", map[string]string{"ip": "192.0.2.1"}), http.StatusNoContent, nil)
+	decodeResponse(t, login(t, cfg, user.Email, "password", "192.0.2.1"), http.StatusOK, nil)
+
+	events, err := cfg.db.GetAuditEvents(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocks := 0
+	for _, event := range events {
+		if event.EventType == database.AuditEventLoginUnlock {
+			unlocks++
+		}
+	}
+	if unlocks != 2 {
+		t.Errorf("recorded %d unlocks, want 2", unlocks)
+	}
+}
@@ -2,13 +2,25 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/fetch"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -16,7 +28,8 @@ import (
 
 type apiConfig struct {
 	db               database.Client
-	jwtSecret        string
+	jwtKeys          *auth.Keyring
+	jwtKeyConfig     jwtKeyConfig
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -25,6 +38,24 @@ type apiConfig struct {
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+	oidcProvider     *oidc.Provider
+	adminAPIKey      string
+	appURL           string
+	mailer           mailer.Mailer
+	rateLimiter      ratelimit.Store
+	rateLimits       map[rateLimitClass]ratelimit.Limit
+	assetKeySecret   []byte
+	quotas           quotaPolicies
+	media            media.Toolkit
+	mediaExecutor    *media.Executor
+	previews         media.PreviewOptions
+	loudness         *media.Loudness
+	audioRenditions  []media.AudioCodec
+	versionRetention versionRetention
+	chapters         chapterOptions
+	importer         *fetch.Fetcher
+	batchUploadsDir  string
+	batchQueued      chan struct{}
 }
 
 func main() {
@@ -45,6 +76,45 @@ func main() {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
 
+	jwtAlgorithm := auth.SigningAlgorithm(os.Getenv("JWT_SIGNING_ALG"))
+	if jwtAlgorithm == "" {
+		jwtAlgorithm = auth.AlgorithmRS256
+	}
+
+	jwtRotationInterval := time.Hour * 24 * 30
+	if interval := os.Getenv("JWT_ROTATION_INTERVAL"); interval != "" {
+		jwtRotationInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid JWT_ROTATION_INTERVAL: %v", err)
+		}
+	}
+
+	jwtIssuer := os.Getenv("JWT_ISSUER")
+	if jwtIssuer == "" {
+		jwtIssuer = "tubely"
+	}
+
+	jwtAudience := []string{"tubely"}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		jwtAudience = strings.Split(audience, ",")
+	}
+
+	jwtKeys := auth.NewKeyring(jwtIssuer, jwtAudience)
+	// Tokens signed with JWT_SECRET before the keyring are only accepted
+	// while migrating, up to JWT_LEGACY_UNTIL.
+	if legacyUntil := os.Getenv("JWT_LEGACY_UNTIL"); legacyUntil != "" {
+		until, err := time.Parse(time.RFC3339, legacyUntil)
+		if err != nil {
+			log.Fatalf("Invalid JWT_LEGACY_UNTIL, must be an RFC 3339 time: %v", err)
+		}
+		jwtKeys.SetLegacySecret(jwtSecret, until)
+	}
+	jwtKeys.SetTokensValidAfter(db.GetUserTokensValidAfter)
+	keyConfig := jwtKeyConfig{
+		algorithm:        jwtAlgorithm,
+		rotationInterval: jwtRotationInterval,
+	}
+
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
@@ -75,11 +145,193 @@ func main() {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	assetKeySecret := os.Getenv("ASSET_KEY_SECRET")
+	if assetKeySecret == "" {
+		log.Print("ASSET_KEY_SECRET is not set, deriving asset keys from JWT_SECRET")
+		assetKeySecret = jwtSecret
+	}
+
+	quotas := defaultQuotaPolicies()
+	if rawQuotas := os.Getenv("UPLOAD_QUOTAS"); rawQuotas != "" {
+		quotas, err = parseQuotaPolicies(rawQuotas)
+		if err != nil {
+			log.Fatalf("Invalid UPLOAD_QUOTAS: %v", err)
+		}
+	}
+
+	rateLimits := defaultRateLimits()
+	if rawLimits := os.Getenv("RATE_LIMITS"); rawLimits != "" {
+		rateLimits, err = parseRateLimits(rawLimits)
+		if err != nil {
+			log.Fatalf("Invalid RATE_LIMITS: %v", err)
+		}
+	}
+
+	// Media tools are CPU heavy, so by default only one runs per core and the
+	// rest wait in line.
+	mediaConcurrency := runtime.NumCPU()
+	if concurrency := os.Getenv("MEDIA_CONCURRENCY"); concurrency != "" {
+		mediaConcurrency, err = strconv.Atoi(concurrency)
+		if err != nil || mediaConcurrency < 1 {
+			log.Fatalf("Invalid MEDIA_CONCURRENCY: %q", concurrency)
+		}
+	}
+	mediaTimeout := 30 * time.Minute
+	if timeout := os.Getenv("MEDIA_JOB_TIMEOUT"); timeout != "" {
+		mediaTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid MEDIA_JOB_TIMEOUT: %v", err)
+		}
+	}
+
+	mediaExecutor := media.NewExecutor(mediaConcurrency, mediaTimeout)
+
+	previews := defaultPreviewOptions()
+	if length := os.Getenv("PREVIEW_LENGTH"); length != "" {
+		previews.Length, err = time.ParseDuration(length)
+		if err != nil {
+			log.Fatalf("Invalid PREVIEW_LENGTH: %v", err)
+		}
+	}
+	if strategy := os.Getenv("PREVIEW_STRATEGY"); strategy != "" {
+		previews.Strategy, err = media.ParsePreviewStrategy(strategy)
+		if err != nil {
+			log.Fatalf("Invalid PREVIEW_STRATEGY: %v", err)
+		}
+	}
+	if segments := os.Getenv("PREVIEW_SEGMENTS"); segments != "" {
+		previews.SegmentCount, err = strconv.Atoi(segments)
+		if err != nil || previews.SegmentCount < 1 {
+			log.Fatalf("Invalid PREVIEW_SEGMENTS: %q", segments)
+		}
+	}
+	if width := os.Getenv("PREVIEW_WIDTH"); width != "" {
+		previews.Width, err = strconv.Atoi(width)
+		if err != nil || previews.Width < 2 || previews.Width%2 != 0 {
+			log.Fatalf("Invalid PREVIEW_WIDTH, must be a positive even number: %q", width)
+		}
+	}
+
+	// Loudness normalization is off unless a target is set.
+	var loudness *media.Loudness
+	if rawTarget := os.Getenv("LOUDNESS_TARGET"); rawTarget != "" {
+		target := media.EBUR128
+		if rawTarget != "ebu-r128" {
+			target.Integrated, err = strconv.ParseFloat(rawTarget, 64)
+			if err != nil || target.Integrated > 0 {
+				log.Fatalf("Invalid LOUDNESS_TARGET, must be ebu-r128 or LUFS: %q", rawTarget)
+			}
+		}
+		loudness = &target
+	}
+	audioRenditions := defaultAudioRenditions
+	if codecs, ok := os.LookupEnv("AUDIO_RENDITIONS"); ok {
+		audioRenditions = []media.AudioCodec{}
+		for _, name := range strings.FieldsFunc(codecs, func(r rune) bool { return r == ',' || r == ' ' }) {
+			codec, err := media.ParseAudioCodec(name)
+			if err != nil {
+				log.Fatalf("Invalid AUDIO_RENDITIONS: %v", err)
+			}
+			audioRenditions = append(audioRenditions, codec)
+		}
+	}
+
+	retention := defaultVersionRetention()
+	if keep := os.Getenv("VIDEO_VERSIONS_KEEP"); keep != "" {
+		retention.Keep, err = strconv.Atoi(keep)
+		if err != nil || retention.Keep < 0 {
+			log.Fatalf("Invalid VIDEO_VERSIONS_KEEP: %q", keep)
+		}
+	}
+	if maxAge := os.Getenv("VIDEO_VERSIONS_MAX_AGE"); maxAge != "" {
+		retention.MaxAge, err = time.ParseDuration(maxAge)
+		if err != nil || retention.MaxAge < 0 {
+			log.Fatalf("Invalid VIDEO_VERSIONS_MAX_AGE: %q", maxAge)
+		}
+	}
+
+	chapters := defaultChapterOptions()
+	if threshold := os.Getenv("CHAPTER_SCENE_THRESHOLD"); threshold != "" {
+		chapters.SceneThreshold, err = strconv.ParseFloat(threshold, 64)
+		if err != nil || chapters.SceneThreshold < 0 || chapters.SceneThreshold >= 1 {
+			log.Fatalf("Invalid CHAPTER_SCENE_THRESHOLD, must be at least 0 and below 1: %q", threshold)
+		}
+	}
+	if minLength := os.Getenv("CHAPTER_MIN_LENGTH"); minLength != "" {
+		chapters.MinLength, err = time.ParseDuration(minLength)
+		if err != nil || chapters.MinLength < 0 {
+			log.Fatalf("Invalid CHAPTER_MIN_LENGTH: %q", minLength)
+		}
+	}
+
+	importOptions := fetch.Options{MaxRedirects: 5, MaxAttempts: 3}
+	if allowlist := os.Getenv("IMPORT_ALLOWLIST"); allowlist != "" {
+		importOptions.Allow, err = parseImportAllowlist(allowlist)
+		if err != nil {
+			log.Fatalf("Invalid IMPORT_ALLOWLIST: %v", err)
+		}
+	}
+	if redirects := os.Getenv("IMPORT_MAX_REDIRECTS"); redirects != "" {
+		importOptions.MaxRedirects, err = strconv.Atoi(redirects)
+		if err != nil || importOptions.MaxRedirects < 0 {
+			log.Fatalf("Invalid IMPORT_MAX_REDIRECTS: %q", redirects)
+		}
+	}
+
+	batchWorkers := 2
+	if workers := os.Getenv("BATCH_WORKERS"); workers != "" {
+		batchWorkers, err = strconv.Atoi(workers)
+		if err != nil || batchWorkers < 1 {
+			log.Fatalf("Invalid BATCH_WORKERS: %q", workers)
+		}
+	}
+	// Files of batch uploads wait here until they are processed, so the
+	// directory has to outlive restarts like the database.
+	batchUploadsDir := os.Getenv("BATCH_UPLOADS_DIR")
+	if batchUploadsDir == "" {
+		batchUploadsDir = filepath.Join(filepath.Dir(pathToDB), "batch_uploads")
+	}
+
+	assetCache := defaultAssetCachePolicy()
+	for assetType := range assetCache {
+		if cacheControl := os.Getenv("ASSET_CACHE_" + strings.ToUpper(assetType)); cacheControl != "" {
+			assetCache[assetType] = cacheControl
+		}
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+
+	var mail mailer.Mailer
+	switch mailerType := os.Getenv("MAILER"); mailerType {
+	case "", "log":
+		mail = &mailer.LogMailer{Path: os.Getenv("MAIL_LOG_PATH")}
+	case "smtp":
+		smtpAddr := os.Getenv("SMTP_ADDR")
+		if smtpAddr == "" {
+			log.Fatal("SMTP_ADDR environment variable is not set")
+		}
+		mailFrom := os.Getenv("MAIL_FROM")
+		if mailFrom == "" {
+			log.Fatal("MAIL_FROM environment variable is not set")
+		}
+		mail = mailer.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	default:
+		log.Fatalf("Unknown MAILER %q, expected log or smtp", mailerType)
+	}
+
 	s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
 	if err != nil {
 		log.Fatalf("Couldn't create s3 config: %v", err)
@@ -89,7 +341,8 @@ func main() {
 
 	cfg := apiConfig{
 		db:               db,
-		jwtSecret:        jwtSecret,
+		jwtKeys:          jwtKeys,
+		jwtKeyConfig:     keyConfig,
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
@@ -98,34 +351,160 @@ func main() {
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
 		s3Client:         s3Client,
+		adminAPIKey:      os.Getenv("ADMIN_API_KEY"),
+		appURL:           strings.TrimSuffix(appURL, "/"),
+		mailer:           mail,
+		assetKeySecret:   []byte(assetKeySecret),
+		quotas:           quotas,
+		rateLimiter:      ratelimit.NewMemoryStore(),
+		rateLimits:       rateLimits,
+		mediaExecutor:    mediaExecutor,
+		media:            media.NewFFmpeg(mediaExecutor, os.Getenv("FFMPEG_PATH"), os.Getenv("FFPROBE_PATH")),
+		previews:         previews,
+		loudness:         loudness,
+		audioRenditions:  audioRenditions,
+		versionRetention: retention,
+		chapters:         chapters,
+		importer:         fetch.New(importOptions),
+		batchUploadsDir:  batchUploadsDir,
+		batchQueued:      make(chan struct{}, 1),
 	}
 
+	// OpenID Connect login is optional and only enabled when an issuer is set.
+	if oidcIssuer := os.Getenv("OIDC_ISSUER"); oidcIssuer != "" {
+		oidcClientID := os.Getenv("OIDC_CLIENT_ID")
+		if oidcClientID == "" {
+			log.Fatal("OIDC_CLIENT_ID environment variable is not set")
+		}
+		oidcRedirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if oidcRedirectURL == "" {
+			log.Fatal("OIDC_REDIRECT_URL environment variable is not set")
+		}
+		var oidcScopes []string
+		if scopes := os.Getenv("OIDC_SCOPES"); scopes != "" {
+			oidcScopes = strings.Fields(scopes)
+		}
+		cfg.oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       oidcIssuer,
+			ClientID:     oidcClientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  oidcRedirectURL,
+			Scopes:       oidcScopes,
+		})
+	}
+
+	// "tubely reclassify [-dry-run]" updates stored videos to the current
+	// aspect ratio classification instead of starting the server.
+	if len(os.Args) > 1 && os.Args[1] == "reclassify" {
+		flags := flag.NewFlagSet("reclassify", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only report what would change")
+		flags.Parse(os.Args[2:])
+		if err := cfg.reclassifyVideos(context.Background(), os.Stdout, *dryRun); err != nil {
+			log.Fatalf("Couldn't reclassify videos: %v", err)
+		}
+		return
+	}
+
+	err = cfg.loadSigningKeys()
+	if err != nil {
+		log.Fatalf("Couldn't load JWT signing keys: %v", err)
+	}
+	go cfg.runSigningKeyRotation(context.Background())
+
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = os.MkdirAll(batchUploadsDir, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create batch uploads directory: %v", err)
+	}
+	// Items a previous run was processing are processed again from their
+	// stored files.
+	if requeued, err := db.RequeueProcessingBatchItems(); err != nil {
+		log.Fatalf("Couldn't requeue unfinished batch uploads: %v", err)
+	} else if requeued > 0 {
+		log.Printf("Requeued %d unfinished batch upload items", requeued)
+	}
+	for range batchWorkers {
+		go cfg.runBatchWorker(context.Background())
+	}
+	go cfg.runPendingUploadCleanup(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	assetsHandler := http.StripPrefix("/assets", assetCacheMiddleware(assetsRoot, assetCache, http.FileServer(http.Dir(assetsRoot))))
+	mux.Handle("/assets/", assetsHandler)
+
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+
+	mux.HandleFunc("POST /api/login", cfg.rateLimit(rateLimitAuth, cfg.handlerLogin))
+	mux.HandleFunc("POST /api/login/totp", cfg.rateLimit(rateLimitAuth, cfg.handlerLoginTOTP))
+	mux.HandleFunc("POST /api/refresh", cfg.rateLimit(rateLimitAuth, cfg.handlerRefresh))
+	mux.HandleFunc("POST /api/revoke", cfg.rateLimit(rateLimitAuth, cfg.handlerRevoke))
+	if cfg.oidcProvider != nil {
+		mux.HandleFunc("GET /api/oidc/login", cfg.rateLimit(rateLimitAuth, cfg.handlerOIDCLogin))
+		mux.HandleFunc("GET /api/oidc/callback", cfg.rateLimit(rateLimitAuth, cfg.handlerOIDCCallback))
+	}
 
-	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
-	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("POST /api/users", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersCreate))
+	mux.HandleFunc("POST /api/users/verify_email", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersVerifyEmail))
+	mux.HandleFunc("POST /api/users/verify_email/resend", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersResendVerification))
+	mux.HandleFunc("PUT /api/users/password", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersChangePassword))
+	mux.HandleFunc("DELETE /api/users/me", cfg.rateLimit(rateLimitAuth, cfg.handlerUsersDelete))
+	mux.HandleFunc("GET /api/users/me/export", cfg.rateLimit(rateLimitMetadata, cfg.handlerUsersExport))
+	mux.HandleFunc("GET /api/users/me/usage", cfg.rateLimit(rateLimitMetadata, cfg.handlerUsersUsage))
+	mux.HandleFunc("GET /api/users/me/watermark", cfg.rateLimit(rateLimitMetadata, cfg.handlerWatermarkGet))
+	mux.HandleFunc("PUT /api/users/me/watermark", cfg.rateLimit(rateLimitUpload, cfg.handlerWatermarkSet))
+	mux.HandleFunc("DELETE /api/users/me/watermark", cfg.rateLimit(rateLimitMetadata, cfg.handlerWatermarkDelete))
+	mux.HandleFunc("POST /api/users/totp", cfg.rateLimit(rateLimitAuth, cfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/users/totp/confirm", cfg.rateLimit(rateLimitAuth, cfg.handlerTOTPConfirm))
+	mux.HandleFunc("POST /api/users/totp/recovery_codes", cfg.rateLimit(rateLimitAuth, cfg.handlerTOTPRecoveryCodesRegenerate))
+	mux.HandleFunc("DELETE /api/users/totp", cfg.rateLimit(rateLimitAuth, cfg.handlerTOTPDisable))
+	mux.HandleFunc("POST /api/password_reset", cfg.rateLimit(rateLimitAuth, cfg.handlerPasswordResetRequest))
+	mux.HandleFunc("POST /api/password_reset/confirm", cfg.rateLimit(rateLimitAuth, cfg.handlerPasswordResetConfirm))
 
-	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("POST /api/videos", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoMetaCreate))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.rateLimit(rateLimitUpload, cfg.handlerUploadThumbnail))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.rateLimit(rateLimitUpload, cfg.handlerUploadVideo))
+	mux.HandleFunc("POST /api/videos/{videoID}/trim", cfg.rateLimit(rateLimitUpload, cfg.handlerVideoTrim))
+	mux.HandleFunc("GET /api/videos", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoGet))
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream_url", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoStreamURL))
+	mux.HandleFunc("GET /api/videos/{videoID}/versions", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoVersionsRetrieve))
+	mux.HandleFunc("POST /api/batches", cfg.rateLimit(rateLimitUpload, cfg.handlerBatchUploadCreate))
+	mux.HandleFunc("GET /api/batches/{batchID}", cfg.rateLimit(rateLimitMetadata, cfg.handlerBatchUploadGet))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_url", cfg.rateLimit(rateLimitUpload, cfg.handlerDirectUploadCreate))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_complete", cfg.rateLimit(rateLimitUpload, cfg.handlerDirectUploadComplete))
+	mux.HandleFunc("POST /api/videos/{videoID}/import", cfg.rateLimit(rateLimitUpload, cfg.handlerVideoImport))
+	mux.HandleFunc("POST /api/videos/{videoID}/versions/{version}/rollback", cfg.rateLimit(rateLimitUpload, cfg.handlerVideoVersionRollback))
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoChaptersRetrieve))
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoChaptersUpdate))
+	mux.HandleFunc("GET /api/videos/{videoID}/chapters/suggestions", cfg.rateLimit(rateLimitMetadata, cfg.handlerChapterSuggestionsRetrieve))
+	mux.HandleFunc("POST /api/videos/{videoID}/chapters/suggestions/accept", cfg.rateLimit(rateLimitMetadata, cfg.handlerChapterSuggestionsAccept))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.rateLimit(rateLimitMetadata, cfg.handlerVideoMetaDelete))
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/organizations", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationCreate))
+	mux.HandleFunc("GET /api/organizations", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationsRetrieve))
+	mux.HandleFunc("GET /api/organizations/{orgID}/members", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationMembersRetrieve))
+	mux.HandleFunc("PUT /api/organizations/{orgID}/members/{userID}", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationMemberUpdate))
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/members/{userID}", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationMemberRemove))
+	mux.HandleFunc("POST /api/organizations/{orgID}/invitations", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationInvite))
+	mux.HandleFunc("GET /api/organizations/{orgID}/watermark", cfg.rateLimit(rateLimitMetadata, cfg.handlerWatermarkGet))
+	mux.HandleFunc("PUT /api/organizations/{orgID}/watermark", cfg.rateLimit(rateLimitUpload, cfg.handlerWatermarkSet))
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/watermark", cfg.rateLimit(rateLimitMetadata, cfg.handlerWatermarkDelete))
+	mux.HandleFunc("POST /api/invitations/{token}/accept", cfg.rateLimit(rateLimitMetadata, cfg.handlerOrganizationInvitationAccept))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /admin/login_lockouts/unlock", cfg.handlerAdminUnlockLogin)
+	mux.HandleFunc("GET /admin/audit_events", cfg.handlerAdminAuditEvents)
+	mux.HandleFunc("POST /admin/users/{userID}/totp/reset", cfg.handlerAdminTOTPReset)
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.handlerAdminSetUserRole)
+	mux.HandleFunc("GET /admin/media/stats", cfg.handlerAdminMediaStats)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
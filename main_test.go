@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mailer"
+)
+
+// newTestConfig returns a config backed by a fresh database in a temporary
+// directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("Couldn't create database: %v", err)
+	}
+	cfg := &apiConfig{
+		db:           db,
+		jwtKeys:      auth.NewKeyring("tubely", []string{"tubely"}),
+		jwtKeyConfig: jwtKeyConfig{algorithm: auth.AlgorithmEdDSA},
+		platform:     "dev",
+		assetsRoot:   filepath.Join(dir, "assets"),
+		appURL:       "http://localhost:8091",
+		mailer:       &mailer.LogMailer{Path: filepath.Join(dir, "mail.log")},
+		quotas:       defaultQuotaPolicies(),
+	}
+	cfg.jwtKeys.SetTokensValidAfter(db.GetUserTokensValidAfter)
+	if err := cfg.loadSigningKeys(); err != nil {
+		t.Fatalf("Couldn't create signing key: %v", err)
+	}
+	return cfg
+}
+
+// createTestUser creates a user with a verified email and returns it with
+// an access token.
+func createTestUser(t *testing.T, cfg *apiConfig, email string) (database.User, string) {
+	t.Helper()
+	hashedPassword, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hashedPassword})
+	if err != nil {
+		t.Fatalf("Couldn't create user: %v", err)
+	}
+	if err := cfg.db.MarkUserEmailVerified(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtKeys, accessTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user, token
+}
+
+// newJSONRequest builds a request with a JSON body and, unless token is
+// empty, a bearer token.
+func newJSONRequest(t *testing.T, method, target, token string, body any) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// decodeResponse checks the status code of a recorded response and decodes
+// its JSON body into v, if v is not nil.
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Couldn't decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+}
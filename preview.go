@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// defaultPreviewOptions make a three second preview from three parts of
+// the video.
+func defaultPreviewOptions() media.PreviewOptions {
+	return media.PreviewOptions{
+		Strategy:     media.PreviewSpread,
+		Length:       3 * time.Second,
+		SegmentCount: 3,
+		Width:        320,
+		FPS:          12,
+	}
+}
+
+// generatePreviews renders the WebP and MP4 previews of the video at
+// sourcePath and stores them next to the thumbnails. Previews are
+// optional, so failures are logged and the preview is left out, as are
+// previews that would exceed the owner's storage quota.
+func (cfg *apiConfig) generatePreviews(ctx context.Context, ownerID uuid.UUID, sourcePath string, duration float64) (previewURL, previewVideoURL *string) {
+	if cfg.previews.Length <= 0 {
+		return nil, nil
+	}
+	sourceDuration := time.Duration(duration * float64(time.Second))
+	previewURL = cfg.generatePreview(ctx, ownerID, sourcePath, media.PreviewWebP, sourceDuration)
+	previewVideoURL = cfg.generatePreview(ctx, ownerID, sourcePath, media.PreviewMP4, sourceDuration)
+	return previewURL, previewVideoURL
+}
+
+func (cfg *apiConfig) generatePreview(ctx context.Context, ownerID uuid.UUID, sourcePath string, format media.PreviewFormat, duration time.Duration) *string {
+	tempFile, err := os.CreateTemp(cfg.assetsRoot, ".preview-*")
+	if err != nil {
+		log.Printf("Couldn't create %s preview: %v", format, err)
+		return nil
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	if err := cfg.media.Preview(ctx, sourcePath, tempFile.Name(), format, duration, cfg.previews); err != nil {
+		log.Printf("Couldn't create %s preview: %v", format, err)
+		return nil
+	}
+	hasher, err := hashFile(tempFile.Name())
+	if err != nil {
+		log.Printf("Couldn't create %s preview: %v", format, err)
+		return nil
+	}
+	if err := cfg.checkStorageQuota(ownerID, hasher.size); err != nil {
+		log.Printf("Couldn't store %s preview: %v", format, err)
+		return nil
+	}
+	assetPath, err := cfg.storeLocalAsset(ownerID, tempFile.Name(), hasher, format.MediaType())
+	if err != nil {
+		log.Printf("Couldn't store %s preview: %v", format, err)
+		return nil
+	}
+	previewURL := cfg.getAssetURL(assetPath)
+	return &previewURL
+}
+
+// releasePreviews drops the references held by a video's previews. Errors
+// are logged, a leaked preview only costs a little disk space.
+func (cfg *apiConfig) releasePreviews(ownerID uuid.UUID, previewURLs ...*string) {
+	for _, previewURL := range previewURLs {
+		if previewURL == nil {
+			continue
+		}
+		if err := cfg.releaseLocalAsset(ownerID, *previewURL); err != nil {
+			log.Printf("Couldn't release preview %s: %v", *previewURL, err)
+		}
+	}
+}
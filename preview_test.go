@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestGeneratePreviewsRespectQuota(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.media = &media.Fake{}
+	cfg.previews = defaultPreviewOptions()
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := createTestUser(t, cfg, "uploader@example.com")
+
+	const quota = 1000
+	cfg.quotas = quotaPolicies{defaultUserRole: {MaxStorageBytes: quota}}
+	if err := cfg.db.AddUserStorage(user.ID, quota-1); err != nil {
+		t.Fatal(err)
+	}
+
+	previewURL, previewVideoURL := cfg.generatePreviews(context.Background(), user.ID, "source.mp4", 60)
+	if previewURL != nil || previewVideoURL != nil {
+		t.Errorf("previews = %v, %v; want none over quota", previewURL, previewVideoURL)
+	}
+	if used, err := cfg.db.GetUserStorage(user.ID); err != nil || used != quota-1 {
+		t.Errorf("storage = %d, %v; want %d", used, err, quota-1)
+	}
+
+	cfg.quotas = defaultQuotaPolicies()
+	previewURL, previewVideoURL = cfg.generatePreviews(context.Background(), user.ID, "source.mp4", 60)
+	if previewURL == nil || previewVideoURL == nil {
+		t.Errorf("previews = %v, %v; want both within quota", previewURL, previewVideoURL)
+	}
+}
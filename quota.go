@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+const defaultUserRole = "user"
+
+// maxUploadSize applies to roles without an upload limit of their own.
+const maxUploadSize = 1 << 30
+
+// quotaPolicy limits what users with one role may store. Zero means
+// unlimited.
+type quotaPolicy struct {
+	MaxStorageBytes    int64   `json:"max_storage_bytes"`
+	MaxUploadBytes     int64   `json:"max_upload_bytes"`
+	MaxDurationSeconds float64 `json:"max_duration_seconds"`
+	// MaxResolution caps the shorter side of the video, so 1080 allows
+	// 1920x1080 landscape as well as 1080x1920 portrait videos.
+	MaxResolution int `json:"max_resolution"`
+}
+
+// quotaPolicies maps user roles to their limits. Roles without an entry use
+// the policy for defaultUserRole.
+type quotaPolicies map[string]quotaPolicy
+
+func defaultQuotaPolicies() quotaPolicies {
+	return quotaPolicies{
+		defaultUserRole: {
+			MaxStorageBytes:    5 << 30,
+			MaxUploadBytes:     1 << 30,
+			MaxDurationSeconds: 60 * 60,
+			MaxResolution:      1080,
+		},
+	}
+}
+
+// parseQuotaPolicies reads policies from JSON such as
+// {"user": {"max_storage_bytes": 5368709120}, "pro": {}}. The default user
+// policy is kept unless the JSON overrides it.
+func parseQuotaPolicies(raw string) (quotaPolicies, error) {
+	policies := quotaPolicies{}
+	if err := json.Unmarshal([]byte(raw), &policies); err != nil {
+		return nil, err
+	}
+	if _, ok := policies[defaultUserRole]; !ok {
+		policies[defaultUserRole] = defaultQuotaPolicies()[defaultUserRole]
+	}
+	return policies, nil
+}
+
+func (p quotaPolicies) forRole(role string) quotaPolicy {
+	if policy, ok := p[role]; ok {
+		return policy
+	}
+	return p[defaultUserRole]
+}
+
+// quotaError explains why an upload was refused. Handlers respond to it with
+// 413 Request Entity Too Large.
+type quotaError struct {
+	reason string
+}
+
+func (e *quotaError) Error() string {
+	return e.reason
+}
+
+// checkUpload checks an upload of size bytes by a user who already stores
+// used bytes.
+func (p quotaPolicy) checkUpload(used, size int64) error {
+	if p.MaxUploadBytes > 0 && size > p.MaxUploadBytes {
+		return &quotaError{fmt.Sprintf("Upload is larger than the %d byte limit", p.MaxUploadBytes)}
+	}
+	return p.checkStorage(used, size)
+}
+
+// checkStorage checks that size more bytes fit in the storage quota of a
+// user who already stores used bytes.
+func (p quotaPolicy) checkStorage(used, size int64) error {
+	if p.MaxStorageBytes > 0 && used+size > p.MaxStorageBytes {
+		return &quotaError{fmt.Sprintf("Storage quota exceeded: %d of %d bytes used", used, p.MaxStorageBytes)}
+	}
+	return nil
+}
+
+// uploadLimit is the most bytes to accept for a single upload.
+func (p quotaPolicy) uploadLimit() int64 {
+	if p.MaxUploadBytes > 0 {
+		return p.MaxUploadBytes
+	}
+	return maxUploadSize
+}
+
+func (p quotaPolicy) checkVideo(probe media.ProbeResult) error {
+	if p.MaxDurationSeconds > 0 && probe.Duration > p.MaxDurationSeconds {
+		return &quotaError{fmt.Sprintf("Video is longer than the %g second limit", p.MaxDurationSeconds)}
+	}
+	if video, ok := probe.VideoStream(); ok && p.MaxResolution > 0 {
+		if min(video.Width, video.Height) > p.MaxResolution {
+			return &quotaError{fmt.Sprintf("Video resolution exceeds the %dp limit", p.MaxResolution)}
+		}
+	}
+	return nil
+}
+
+// isQuotaError reports whether err is a quotaError.
+func isQuotaError(err error) bool {
+	var quotaErr *quotaError
+	return errors.As(err, &quotaErr)
+}
+
+// checkStorageQuota checks that a file of size bytes the server derived from
+// an upload, such as a rendition or preview, fits in the owner's quota.
+func (cfg *apiConfig) checkStorageQuota(ownerID uuid.UUID, size int64) error {
+	policy, used, err := cfg.userQuota(ownerID)
+	if err != nil {
+		return err
+	}
+	return policy.checkStorage(used, size)
+}
+
+// userQuota returns the user's policy and how many bytes they store.
+func (cfg *apiConfig) userQuota(userID uuid.UUID) (quotaPolicy, int64, error) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return quotaPolicy{}, 0, err
+	}
+	if user == nil {
+		return quotaPolicy{}, 0, fmt.Errorf("user %s not found", userID)
+	}
+	used, err := cfg.db.GetUserStorage(userID)
+	if err != nil {
+		return quotaPolicy{}, 0, err
+	}
+	return cfg.quotas.forRole(user.Role), used, nil
+}
+
+func (cfg *apiConfig) handlerUsersUsage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Role             string      `json:"role"`
+		StorageBytesUsed int64       `json:"storage_bytes_used"`
+		Limits           quotaPolicy `json:"limits"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user", err)
+		return
+	}
+	used, err := cfg.db.GetUserStorage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Role:             user.Role,
+		StorageBytesUsed: used,
+		Limits:           cfg.quotas.forRole(user.Role),
+	})
+}
+
+func (cfg *apiConfig) handlerAdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	if !cfg.requireAdmin(w, r) {
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if _, ok := cfg.quotas[params.Role]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown role", nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.db.SetUserRole(userID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't set role", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
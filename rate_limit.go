@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+// rateLimitClass groups endpoints that share a limit. Each class has its own
+// buckets, so heavy browsing doesn't use up the login allowance.
+type rateLimitClass string
+
+const (
+	rateLimitAuth     rateLimitClass = "auth"
+	rateLimitMetadata rateLimitClass = "metadata"
+	rateLimitUpload   rateLimitClass = "upload"
+)
+
+func defaultRateLimits() map[rateLimitClass]ratelimit.Limit {
+	return map[rateLimitClass]ratelimit.Limit{
+		rateLimitAuth:     {Requests: 10, Period: time.Minute},
+		rateLimitMetadata: {Requests: 120, Period: time.Minute},
+		rateLimitUpload:   {Requests: 20, Period: time.Hour},
+	}
+}
+
+// parseRateLimits reads overrides such as
+// {"auth": {"requests": 5, "period": "1m"}} on top of the defaults.
+func parseRateLimits(raw string) (map[rateLimitClass]ratelimit.Limit, error) {
+	overrides := map[rateLimitClass]ratelimit.Limit{}
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, err
+	}
+	limits := defaultRateLimits()
+	for class, limit := range overrides {
+		if _, ok := limits[class]; !ok {
+			return nil, fmt.Errorf("unknown rate limit class %q", class)
+		}
+		limits[class] = limit
+	}
+	return limits, nil
+}
+
+// rateLimitKey identifies the caller: by user for requests with a valid
+// access token, otherwise by IP address.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, cfg.jwtKeys); err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// rateLimit wraps a handler with the limit of its class and reports the
+// bucket state in the RateLimit headers. If the store fails, requests are
+// let through rather than taking the API down with it.
+func (cfg *apiConfig) rateLimit(class rateLimitClass, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, ok := cfg.rateLimits[class]
+		if !ok || cfg.rateLimiter == nil {
+			next(w, r)
+			return
+		}
+
+		key := string(class) + ":" + cfg.rateLimitKey(r)
+		result, err := cfg.rateLimiter.Take(r.Context(), key, limit)
+		if err != nil {
+			log.Printf("Couldn't check rate limit for %s: %v", key, err)
+			next(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", limit.Policy())
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(int((result.Reset+time.Second-1)/time.Second)))
+		if !result.Allowed {
+			respondWithRetryAfter(w, result.RetryAfter, "Too many requests, slow down")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ratelimit"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store is down")
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.rateLimiter = ratelimit.NewMemoryStore()
+	cfg.rateLimits = map[rateLimitClass]ratelimit.Limit{
+		rateLimitAuth:     {Requests: 2, Period: time.Minute},
+		rateLimitMetadata: {Requests: 2, Period: time.Minute},
+	}
+	_, aliceToken := createTestUser(t, cfg, "alice@example.com")
+	_, bobToken := createTestUser(t, cfg, "bob@example.com")
+
+	handlers := map[rateLimitClass]http.HandlerFunc{}
+	for _, class := range []rateLimitClass{rateLimitAuth, rateLimitMetadata, rateLimitUpload} {
+		handlers[class] = cfg.rateLimit(class, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	request := func(class rateLimitClass, ip, token string) *httptest.ResponseRecorder {
+		req := newJSONRequest(t, http.MethodGet, "/api/videos", token, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handlers[class](rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		rec := request(rateLimitMetadata, "192.0.2.1", "")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("request %d = %d, want 204", i+1, rec.Code)
+		}
+		header := rec.Header()
+		if header.Get("RateLimit-Policy") != "2;w=60" || header.Get("RateLimit-Limit") != "2" ||
+			header.Get("RateLimit-Remaining") != strconv.Itoa(1-i) || header.Get("RateLimit-Reset") != strconv.Itoa(30*(i+1)) {
+			t.Errorf("request %d headers = %v", i+1, header)
+		}
+	}
+	rec := request(rateLimitMetadata, "192.0.2.1", "")
+	decodeResponse(t, rec, http.StatusTooManyRequests, nil)
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Classes and IP addresses have their own buckets.
+	if rec := request(rateLimitAuth, "192.0.2.1", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("other class = %d, want 204", rec.Code)
+	}
+	if rec := request(rateLimitMetadata, "192.0.2.2", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("other IP = %d, want 204", rec.Code)
+	}
+	if rec := request(rateLimitUpload, "192.0.2.1", ""); rec.Code != http.StatusNoContent || rec.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("class without a limit = %d with headers %v", rec.Code, rec.Header())
+	}
+
+	// Signed in users are limited by account, wherever they connect from,
+	// so users behind one address don't share a bucket.
+	request(rateLimitMetadata, "192.0.2.1", aliceToken)
+	request(rateLimitMetadata, "198.51.100.1", aliceToken)
+	if rec := request(rateLimitMetadata, "198.51.100.2", aliceToken); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("user over the limit from another IP = %d, want 429", rec.Code)
+	}
+	if rec := request(rateLimitMetadata, "192.0.2.1", bobToken); rec.Code != http.StatusNoContent {
+		t.Errorf("other user on a limited IP = %d, want 204", rec.Code)
+	}
+	// An invalid token falls back to the IP address.
+	if rec := request(rateLimitMetadata, "192.0.2.1", "invalid"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("invalid token on a limited IP = %d, want 429", rec.Code)
+	}
+
+	cfg.rateLimiter = failingStore{}
+	if rec := request(rateLimitMetadata, "192.0.2.1", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("request with a failing store = %d, want it let through", rec.Code)
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(`{"auth": {"requests": 5, "period": "30s"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits[rateLimitAuth] != (ratelimit.Limit{Requests: 5, Period: 30 * time.Second}) {
+		t.Errorf("auth limit = %+v", limits[rateLimitAuth])
+	}
+	if limits[rateLimitUpload] != defaultRateLimits()[rateLimitUpload] {
+		t.Errorf("upload limit = %+v, want the default", limits[rateLimitUpload])
+	}
+	if _, err := parseRateLimits(`{"downloads": {"requests": 5, "period": "30s"}}`); err == nil {
+		t.Error("unknown class was accepted")
+	}
+}
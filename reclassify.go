@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// reclassifyVideos measures every stored video file again, records its
+// dimensions and moves it to the folder of its aspect class. With dryRun
+// it only reports what would change. Run it while the server is stopped,
+// an upload deduplicated against a file being moved would keep its old URL.
+func (cfg *apiConfig) reclassifyVideos(ctx context.Context, out io.Writer, dryRun bool) error {
+	videos, err := cfg.db.GetVideosWithFiles()
+	if err != nil {
+		return fmt.Errorf("couldn't get videos: %w", err)
+	}
+
+	checked, moved, failed := 0, 0, 0
+	for i, video := range videos {
+		videoURL := *video.VideoURL
+		if i > 0 && *videos[i-1].VideoURL == videoURL {
+			continue
+		}
+		key := cfg.s3KeyFromURL(videoURL)
+		if key == "" {
+			fmt.Fprintf(out, "skip %s: not in the bucket\n", videoURL)
+			continue
+		}
+		checked++
+
+		dimensions, err := cfg.measureVideoObject(ctx, key)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "fail %s: %v\n", key, err)
+			continue
+		}
+		newKey := path.Join(aspectKeyPrefix(dimensions.AspectClass), path.Base(key))
+		fmt.Fprintf(out, "%s %dx%d %s", key, dimensions.Width, dimensions.Height, dimensions.AspectClass)
+		if newKey != key {
+			moved++
+			fmt.Fprintf(out, " -> %s", newKey)
+		}
+		fmt.Fprintln(out)
+		if dryRun {
+			continue
+		}
+
+		if newKey != key {
+			newURL := strings.TrimSuffix(videoURL, key) + newKey
+			if err := cfg.moveVideoObject(ctx, key, newKey, videoURL, newURL); err != nil {
+				return err
+			}
+			videoURL = newURL
+		}
+		if err := cfg.db.SetVideoFileDimensions(videoURL, dimensions); err != nil {
+			return fmt.Errorf("couldn't update videos of %s: %w", key, err)
+		}
+	}
+
+	verb := "moved"
+	if dryRun {
+		verb = "would move"
+	}
+	fmt.Fprintf(out, "checked %d files, %s %d, %d failed\n", checked, verb, moved, failed)
+	return nil
+}
+
+// measureVideoObject downloads the object and probes its dimensions.
+func (cfg *apiConfig) measureVideoObject(ctx context.Context, key string) (database.VideoDimensions, error) {
+	videoPath, err := cfg.downloadS3Object(ctx, key)
+	if err != nil {
+		return database.VideoDimensions{}, err
+	}
+	defer os.Remove(videoPath)
+
+	probe, err := cfg.media.Probe(ctx, videoPath)
+	if err != nil {
+		return database.VideoDimensions{}, err
+	}
+	return videoDimensions(probe)
+}
+
+// moveVideoObject copies the object to its new key, repoints the database
+// and then deletes the old object.
+func (cfg *apiConfig) moveVideoObject(ctx context.Context, oldKey, newKey, oldURL, newURL string) error {
+	copySource := path.Join(cfg.s3Bucket, oldKey)
+	if _, err := cfg.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &cfg.s3Bucket,
+		Key:        &newKey,
+		CopySource: &copySource,
+	}); err != nil {
+		return fmt.Errorf("couldn't copy %s to %s: %w", oldKey, newKey, err)
+	}
+	if err := cfg.db.MoveVideoFile(oldKey, newKey, oldURL, newURL); err != nil {
+		return fmt.Errorf("couldn't move %s to %s: %w", oldKey, newKey, err)
+	}
+	if _, err := cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &oldKey,
+	}); err != nil {
+		log.Printf("Couldn't delete %s after moving it to %s: %v", oldKey, newKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+func TestReclassifyVideos(t *testing.T) {
+	cfg, bucket, fake := newMediaTestConfig(t)
+	user, token := createTestUser(t, cfg, "uploader@example.com")
+	content := testVideo("square")
+	video := uploadTestVideo(t, cfg, user, token, content)
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := cfg.s3KeyFromURL(*video.VideoURL)
+	if !strings.HasPrefix(oldKey, "landscape/") {
+		t.Fatalf("video stored at %s, want it in landscape/", oldKey)
+	}
+
+	// The video turns out to be square, e.g. because it was measured
+	// before rotation was taken into account.
+	fake.Result = media.FakeVideo(1080, 1080, 10)
+	var out bytes.Buffer
+	if err := cfg.reclassifyVideos(context.Background(), &out, true); err != nil {
+		t.Fatal(err)
+	}
+	newKey := strings.Replace(oldKey, "landscape/", "1x1/", 1)
+	if !strings.Contains(out.String(), oldKey+" 1080x1080 1:1 -> "+newKey) || !strings.Contains(out.String(), "would move 1") {
+		t.Errorf("dry run output = %q", out.String())
+	}
+	if _, ok := bucket.object(newKey); ok {
+		t.Error("dry run moved the object")
+	}
+	if unchanged, err := cfg.db.GetVideo(video.ID); err != nil || *unchanged.VideoURL != *video.VideoURL || unchanged.AspectClass != "16:9" {
+		t.Errorf("dry run changed the video to %v %s, %v", *unchanged.VideoURL, unchanged.AspectClass, err)
+	}
+
+	out.Reset()
+	if err := cfg.reclassifyVideos(context.Background(), &out, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "checked 1 files, moved 1, 0 failed") {
+		t.Errorf("output = %q", out.String())
+	}
+	moved, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.s3KeyFromURL(*moved.VideoURL) != newKey || moved.Width != 1080 || moved.Height != 1080 || moved.AspectClass != "1:1" {
+		t.Errorf("video is %s %dx%d %s, want %s 1080x1080 1:1", *moved.VideoURL, moved.Width, moved.Height, moved.AspectClass, newKey)
+	}
+	if stored, ok := bucket.object(newKey); !ok || !bytes.Equal(stored, content) {
+		t.Error("object wasn't copied to its new key")
+	}
+	if _, ok := bucket.object(oldKey); ok {
+		t.Error("object is still at its old key")
+	}
+	if size, err := cfg.db.GetAssetSize(database.AssetStorageS3, newKey); err != nil || size != int64(len(content)) {
+		t.Errorf("asset at the new key = %d, %v; want %d", size, err, len(content))
+	}
+	checkStoredVideo(t, cfg, bucket, moved, content)
+}
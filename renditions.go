@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// defaultAudioRenditions are the audio-only files made for every video
+// with sound.
+var defaultAudioRenditions = []media.AudioCodec{media.AudioAAC, media.AudioOpus}
+
+// generateRenditions derives the loudness normalized video, if enabled,
+// and the audio-only files from the upload at sourcePath and stores them in
+// the bucket. Like previews they are optional, so failures are logged and
+// the rendition is left out, as are renditions that would exceed the
+// owner's storage quota.
+func (cfg *apiConfig) generateRenditions(ctx context.Context, ownerID uuid.UUID, sourcePath string, probe media.ProbeResult) database.VideoRenditions {
+	renditions := database.VideoRenditions{}
+	if _, hasAudio := probe.AudioStream(); !hasAudio {
+		return renditions
+	}
+
+	if cfg.loudness != nil {
+		renditions.NormalizedVideoURL = cfg.generateRendition(ctx, ownerID, "normalized", media.ContainerMP4.MediaType(), func(outputPath string) error {
+			return cfg.media.NormalizeLoudness(ctx, sourcePath, outputPath, *cfg.loudness, media.NeedsTranscode(probe))
+		})
+	}
+	for _, codec := range cfg.audioRenditions {
+		audioURL := cfg.generateRendition(ctx, ownerID, "audio", codec.MediaType(), func(outputPath string) error {
+			return cfg.media.ExtractAudio(ctx, sourcePath, outputPath, codec, cfg.loudness)
+		})
+		switch codec {
+		case media.AudioAAC:
+			renditions.AudioAACURL = audioURL
+		case media.AudioOpus:
+			renditions.AudioOpusURL = audioURL
+		}
+	}
+	return renditions
+}
+
+func (cfg *apiConfig) generateRendition(ctx context.Context, ownerID uuid.UUID, keyPrefix, mediaType string, render func(outputPath string) error) *string {
+	tempFile, err := os.CreateTemp("", "tubely-rendition-*")
+	if err != nil {
+		log.Printf("Couldn't create %s rendition: %v", mediaType, err)
+		return nil
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	if err := render(tempFile.Name()); err != nil {
+		log.Printf("Couldn't create %s rendition: %v", mediaType, err)
+		return nil
+	}
+	objectURL, err := cfg.storeS3File(ctx, ownerID, tempFile.Name(), keyPrefix, mediaType)
+	if err != nil {
+		log.Printf("Couldn't store %s rendition: %v", mediaType, err)
+		return nil
+	}
+	return &objectURL
+}
+
+// storeS3File stores a file produced on the server in the bucket, sharing
+// the object with identical files, charges the owner and returns its URL.
+// It fails with a quotaError if the file doesn't fit in the owner's quota.
+func (cfg *apiConfig) storeS3File(ctx context.Context, ownerID uuid.UUID, filePath, keyPrefix, mediaType string) (string, error) {
+	hasher, err := hashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := cfg.checkStorageQuota(ownerID, hasher.size); err != nil {
+		return "", err
+	}
+	digest := cfg.contentDigest(hasher)
+	asset, found, err := cfg.db.AcquireAsset(database.AssetStorageS3, digest)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		asset, err = cfg.putS3Object(ctx, filePath, digest, keyPrefix, mediaType)
+		if err != nil {
+			return "", err
+		}
+	}
+	cfg.chargeStorage(ownerID, asset.Size)
+	return fmt.Sprintf("%s/%s", cfg.s3CfDistribution, asset.Key), nil
+}
+
+// videoRendition returns the URL of the named rendition, which is nil if
+// the video doesn't have it.
+func videoRendition(renditions database.VideoRenditions, name string) (*string, bool) {
+	switch name {
+	case "normalized":
+		return renditions.NormalizedVideoURL, true
+	case "audio_aac":
+		return renditions.AudioAACURL, true
+	case "audio_opus":
+		return renditions.AudioOpusURL, true
+	}
+	return nil, false
+}
+
+// releaseRenditions drops the references held by a video's renditions,
+// logging failures.
+func (cfg *apiConfig) releaseRenditions(ctx context.Context, ownerID uuid.UUID, renditions database.VideoRenditions) {
+	for _, objectURL := range []*string{renditions.NormalizedVideoURL, renditions.AudioAACURL, renditions.AudioOpusURL} {
+		if objectURL == nil {
+			continue
+		}
+		if err := cfg.releaseS3Object(ctx, ownerID, *objectURL); err != nil {
+			log.Printf("Couldn't release rendition %s: %v", *objectURL, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoRole returns the role the user holds in the organization that owns
+// the video. It is empty for personal videos and for non-members. Access to
+// organization videos follows only this role, so uploaders lose it along
+// with their membership.
+func (cfg *apiConfig) videoRole(video database.Video, userID uuid.UUID) (database.OrgRole, error) {
+	if video.OrgID == nil {
+		return "", nil
+	}
+	return cfg.db.GetOrganizationRole(*video.OrgID, userID)
+}
+
+func (cfg *apiConfig) canViewVideo(video database.Video, userID uuid.UUID) (bool, error) {
+	if video.OrgID == nil {
+		return video.UserID == userID, nil
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		return false, err
+	}
+	return role != "", nil
+}
+
+func (cfg *apiConfig) canEditVideo(video database.Video, userID uuid.UUID) (bool, error) {
+	if video.OrgID == nil {
+		return video.UserID == userID, nil
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		return false, err
+	}
+	return role.CanEditVideos(), nil
+}
+
+// canDeleteVideo lets managers delete any organization video and members
+// who can edit delete the ones they uploaded.
+func (cfg *apiConfig) canDeleteVideo(video database.Video, userID uuid.UUID) (bool, error) {
+	if video.OrgID == nil {
+		return video.UserID == userID, nil
+	}
+	role, err := cfg.videoRole(video, userID)
+	if err != nil {
+		return false, err
+	}
+	return role.CanManage() || (role.CanEditVideos() && video.UserID == userID), nil
+}
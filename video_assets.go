@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// contentHasher computes the SHA-256 of everything read through it, so an
+// upload can be hashed while it is streamed to disk.
+type contentHasher struct {
+	hash hash.Hash
+	size int64
+}
+
+func newContentHasher() *contentHasher {
+	return &contentHasher{hash: sha256.New()}
+}
+
+func (h *contentHasher) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	return h.hash.Write(p)
+}
+
+func (h *contentHasher) reader(r io.Reader) io.Reader {
+	return io.TeeReader(r, h)
+}
+
+// hashFile hashes a file that was produced on the server rather than
+// uploaded.
+func hashFile(filePath string) (*contentHasher, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := newContentHasher()
+	if _, err := io.Copy(io.Discard, hasher.reader(file)); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+// contentDigest turns the content hash into the name the asset is stored
+// under. It is keyed with a server secret so that knowing a file is not
+// enough to find out whether, and where, somebody else stored it.
+func (cfg apiConfig) contentDigest(h *contentHasher) string {
+	mac := hmac.New(sha256.New, cfg.assetKeySecret)
+	mac.Write(h.hash.Sum(nil))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// chargeStorage adjusts the owner's storage usage. Usage is only used for
+// quotas, so failing to update it doesn't fail the request.
+func (cfg apiConfig) chargeStorage(ownerID uuid.UUID, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if err := cfg.db.AddUserStorage(ownerID, delta); err != nil {
+		log.Printf("Couldn't update storage usage of user %s: %v", ownerID, err)
+	}
+}
+
+const (
+	// assetLockWait is how long storing a file waits for another request
+	// that stores or deletes the same content.
+	assetLockWait = time.Minute
+	// assetLockTimeout is how long an asset may stay locked before whoever
+	// locked it is assumed to have crashed.
+	assetLockTimeout = time.Hour
+)
+
+var errAssetBusy = errors.New("the same file is being stored or deleted")
+
+// lockAsset locks params.Key for storing a file there, waiting while the
+// same content is stored or deleted elsewhere. If the content turns out to
+// be stored already, the existing asset gains a reference and found is
+// true instead.
+func (cfg apiConfig) lockAsset(ctx context.Context, params database.CreateAssetParams) (database.Asset, bool, error) {
+	deadline := time.Now().Add(assetLockWait)
+	for {
+		asset, found, err := cfg.db.AcquireAsset(params.Storage, params.Digest)
+		if err != nil || found {
+			return asset, found, err
+		}
+		locked, err := cfg.db.LockAsset(params, time.Now().Add(-assetLockTimeout))
+		if err != nil || locked {
+			return database.Asset{}, false, err
+		}
+		if time.Now().After(deadline) {
+			return database.Asset{}, false, errAssetBusy
+		}
+		select {
+		case <-ctx.Done():
+			return database.Asset{}, false, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// unlockAsset drops the lock on an asset whose file couldn't be stored.
+func (cfg apiConfig) unlockAsset(storage database.AssetStorage, key string) {
+	if err := cfg.db.UnlockAsset(storage, key); err != nil {
+		log.Printf("Couldn't unlock asset %s: %v", key, err)
+	}
+}
+
+// storeLocalAsset moves the uploaded file at tempPath into the assets
+// directory and returns its asset path. If the same content is already
+// stored, the existing file gains a reference and the upload is discarded.
+// Either way the owner is charged for the full size.
+func (cfg apiConfig) storeLocalAsset(ownerID uuid.UUID, tempPath string, h *contentHasher, mediaType string) (string, error) {
+	digest := cfg.contentDigest(h)
+	params := database.CreateAssetParams{
+		Storage:   database.AssetStorageLocal,
+		Key:       getAssetPath(digest, mediaType),
+		Digest:    digest,
+		MediaType: mediaType,
+		Size:      h.size,
+	}
+	existing, found, err := cfg.lockAsset(context.Background(), params)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		cfg.chargeStorage(ownerID, existing.Size)
+		return existing.Key, nil
+	}
+
+	if err := os.Rename(tempPath, cfg.getAssetDiskPath(params.Key)); err != nil {
+		cfg.unlockAsset(params.Storage, params.Key)
+		return "", err
+	}
+	asset, err := cfg.db.AddAssetReference(params)
+	if err != nil {
+		return "", err
+	}
+	cfg.chargeStorage(ownerID, asset.Size)
+	return asset.Key, nil
+}
+
+// acquireLocalAsset adds a reference to a file already in the assets
+// directory and charges the owner for it.
+func (cfg apiConfig) acquireLocalAsset(ownerID uuid.UUID, assetURL string) error {
+	assetPath := cfg.localAssetPath(assetURL)
+	asset, found, err := cfg.db.AcquireAssetKey(database.AssetStorageLocal, assetPath)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("asset %q isn't stored", assetPath)
+	}
+	cfg.chargeStorage(ownerID, asset.Size)
+	return nil
+}
+
+// localAssetPath returns the asset path of a URL served from the assets
+// directory, or "" if the URL points elsewhere.
+func (cfg apiConfig) localAssetPath(assetURL string) string {
+	prefix := cfg.getAssetURL("")
+	if !strings.HasPrefix(assetURL, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(assetURL, prefix)
+}
+
+// s3KeyFromURL returns the bucket key of a URL pointing at the CloudFront
+// distribution or the bucket itself, or "" if the URL points elsewhere.
+func (cfg apiConfig) s3KeyFromURL(objectURL string) string {
+	for _, prefix := range []string{cfg.s3CfDistribution + "/", cfg.getObjectURL("")} {
+		if strings.HasPrefix(objectURL, prefix) {
+			return strings.TrimPrefix(objectURL, prefix)
+		}
+	}
+	return ""
+}
+
+// releaseLocalAsset drops the reference held by assetURL, refunds the owner
+// and deletes the file once no video uses it any more.
+func (cfg apiConfig) releaseLocalAsset(ownerID uuid.UUID, assetURL string) error {
+	assetPath := cfg.localAssetPath(assetURL)
+	if assetPath == "" || strings.Contains(assetPath, "..") {
+		return nil
+	}
+	size, unreferenced, err := cfg.db.ReleaseAsset(database.AssetStorageLocal, assetPath)
+	if err != nil {
+		return err
+	}
+	cfg.chargeStorage(ownerID, -size)
+	if !unreferenced {
+		return nil
+	}
+	// The asset stays locked if the file can't be deleted, until the lock
+	// times out and the same content may be stored again.
+	err = os.Remove(cfg.getAssetDiskPath(assetPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return cfg.db.UnlockAsset(database.AssetStorageLocal, assetPath)
+}
+
+// releaseS3Object drops the reference held by objectURL, refunds the owner
+// and deletes the object once no video uses it any more.
+func (cfg apiConfig) releaseS3Object(ctx context.Context, ownerID uuid.UUID, objectURL string) error {
+	key := cfg.s3KeyFromURL(objectURL)
+	if key == "" {
+		return nil
+	}
+	size, unreferenced, err := cfg.db.ReleaseAsset(database.AssetStorageS3, key)
+	if err != nil {
+		return err
+	}
+	cfg.chargeStorage(ownerID, -size)
+	if !unreferenced {
+		return nil
+	}
+	_, err = cfg.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.db.UnlockAsset(database.AssetStorageS3, key)
+}
+
+// downloadS3Object copies the object into a temp file, which the caller
+// must remove.
+func (cfg apiConfig) downloadS3Object(ctx context.Context, key string) (string, error) {
+	object, err := cfg.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &cfg.s3Bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer object.Body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-download-*"+path.Ext(key))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tempFile, object.Body)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+// videoRecords are what is stored about a video besides the video itself.
+// They are deleted with the video, so they must be read before to release
+// their files.
+type videoRecords struct {
+	versions    []database.VideoVersion
+	suggestions []database.ChapterSuggestion
+	chapters    []database.VideoChapter
+}
+
+func (cfg apiConfig) getVideoRecords(videoID uuid.UUID) (videoRecords, error) {
+	var records videoRecords
+	var err error
+	records.versions, err = cfg.db.GetVideoVersions(videoID)
+	if err != nil {
+		return videoRecords{}, err
+	}
+	records.suggestions, err = cfg.db.GetChapterSuggestions(videoID)
+	if err != nil {
+		return videoRecords{}, err
+	}
+	records.chapters, err = cfg.db.GetVideoChapters(videoID)
+	if err != nil {
+		return videoRecords{}, err
+	}
+	return records, nil
+}
+
+// releaseVideoAssets drops the video's references to its thumbnail, the
+// files of its versions, its previews, renditions and chapter thumbnails,
+// deleting whichever are no longer shared with other videos.
+func (cfg apiConfig) releaseVideoAssets(ctx context.Context, video database.Video, records videoRecords) error {
+	if video.ThumbnailURL != nil {
+		if err := cfg.releaseLocalAsset(video.UserID, *video.ThumbnailURL); err != nil {
+			return fmt.Errorf("couldn't release thumbnail: %w", err)
+		}
+	}
+	for _, version := range records.versions {
+		cfg.releaseVersionFiles(ctx, video.UserID, version)
+	}
+	cfg.releasePreviews(video.UserID, video.PreviewURL, video.PreviewVideoURL)
+	cfg.releaseRenditions(ctx, video.UserID, video.VideoRenditions)
+	cfg.releaseSuggestionThumbnails(video.UserID, records.suggestions)
+	for _, chapter := range records.chapters {
+		cfg.releaseChapterThumbnail(video.UserID, chapter.ThumbnailURL)
+	}
+	return nil
+}
+
+// videoStorageSize returns what the video's owner is charged for the files
+// releaseVideoAssets would release.
+func (cfg apiConfig) videoStorageSize(video database.Video, records videoRecords) (int64, error) {
+	localURLs := []*string{video.ThumbnailURL, video.PreviewURL, video.PreviewVideoURL}
+	for _, suggestion := range records.suggestions {
+		localURLs = append(localURLs, suggestion.ThumbnailURL)
+	}
+	for _, chapter := range records.chapters {
+		localURLs = append(localURLs, chapter.ThumbnailURL)
+	}
+	objectURLs := []*string{video.NormalizedVideoURL, video.AudioAACURL, video.AudioOpusURL}
+	for _, version := range records.versions {
+		objectURLs = append(objectURLs, &version.VideoURL, version.OriginalVideoURL)
+	}
+
+	var total int64
+	for _, assetURL := range localURLs {
+		if assetURL == nil {
+			continue
+		}
+		size, err := cfg.db.GetAssetSize(database.AssetStorageLocal, cfg.localAssetPath(*assetURL))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	for _, objectURL := range objectURLs {
+		if objectURL == nil {
+			continue
+		}
+		size, err := cfg.db.GetAssetSize(database.AssetStorageS3, cfg.s3KeyFromURL(*objectURL))
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// storeTestAsset stores content as a local asset owned by the user.
+func storeTestAsset(t *testing.T, cfg *apiConfig, user database.User, content string) string {
+	t.Helper()
+	tempPath := filepath.Join(cfg.assetsRoot, ".upload-"+user.ID.String())
+	if err := os.WriteFile(tempPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hasher := newContentHasher()
+	hasher.Write([]byte(content))
+	assetPath, err := cfg.storeLocalAsset(user.ID, tempPath, hasher, "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assetPath
+}
+
+func newAssetTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	cfg := newTestConfig(t)
+	cfg.assetKeySecret = []byte("secret")
+	if err := os.MkdirAll(cfg.assetsRoot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestLocalAssetsAreShared(t *testing.T) {
+	cfg := newAssetTestConfig(t)
+	alice, _ := createTestUser(t, cfg, "alice@example.com")
+	bob, _ := createTestUser(t, cfg, "bob@example.com")
+
+	first := storeTestAsset(t, cfg, alice, "thumbnail")
+	second := storeTestAsset(t, cfg, bob, "thumbnail")
+	if first != second {
+		t.Fatalf("identical files stored as %s and %s", first, second)
+	}
+	if other := storeTestAsset(t, cfg, bob, "other thumbnail"); other == first {
+		t.Fatal("different files share an asset")
+	}
+	for _, user := range []database.User{alice, bob} {
+		if used, err := cfg.db.GetUserStorage(user.ID); err != nil || used < int64(len("thumbnail")) {
+			t.Errorf("storage of %s = %d, %v; want the full size charged", user.Email, used, err)
+		}
+	}
+
+	diskPath := cfg.getAssetDiskPath(first)
+	if err := cfg.releaseLocalAsset(alice.ID, cfg.getAssetURL(first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(diskPath); err != nil {
+		t.Fatalf("file still referenced by bob was deleted: %v", err)
+	}
+	if used, err := cfg.db.GetUserStorage(alice.ID); err != nil || used != 0 {
+		t.Errorf("storage of alice = %d, %v; want 0", used, err)
+	}
+
+	if err := cfg.releaseLocalAsset(bob.ID, cfg.getAssetURL(first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(diskPath); !os.IsNotExist(err) {
+		t.Errorf("unreferenced file is still stored: %v", err)
+	}
+	if size, err := cfg.db.GetAssetSize(database.AssetStorageLocal, first); err != nil || size != 0 {
+		t.Errorf("asset record = %d, %v; want it dropped", size, err)
+	}
+}
+
+func TestStoringWaitsForDeletionOfTheSameFile(t *testing.T) {
+	cfg := newAssetTestConfig(t)
+	user, _ := createTestUser(t, cfg, "user@example.com")
+	assetPath := storeTestAsset(t, cfg, user, "thumbnail")
+
+	// Dropping the last reference locks the asset until its file is gone.
+	_, unreferenced, err := cfg.db.ReleaseAsset(database.AssetStorageLocal, assetPath)
+	if err != nil || !unreferenced {
+		t.Fatalf("ReleaseAsset = %v, %v; want unreferenced", unreferenced, err)
+	}
+
+	stored := make(chan string)
+	go func() {
+		stored <- storeTestAsset(t, cfg, user, "thumbnail")
+	}()
+	select {
+	case <-stored:
+		t.Fatal("file was stored while the same file was being deleted")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := os.Remove(cfg.getAssetDiskPath(assetPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.db.UnlockAsset(database.AssetStorageLocal, assetPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-stored; got != assetPath {
+		t.Fatalf("stored at %s, want %s", got, assetPath)
+	}
+	if _, err := os.Stat(cfg.getAssetDiskPath(assetPath)); err != nil {
+		t.Errorf("stored file is missing: %v", err)
+	}
+}
+
+func TestAssetLocks(t *testing.T) {
+	cfg := newAssetTestConfig(t)
+	params := database.CreateAssetParams{
+		Storage:   database.AssetStorageS3,
+		Key:       "landscape/video.mp4",
+		Digest:    "digest",
+		MediaType: "video/mp4",
+		Size:      100,
+	}
+
+	staleBefore := time.Now().Add(-assetLockTimeout)
+	if locked, err := cfg.db.LockAsset(params, staleBefore); err != nil || !locked {
+		t.Fatalf("LockAsset = %v, %v; want locked", locked, err)
+	}
+	if locked, err := cfg.db.LockAsset(params, staleBefore); err != nil || locked {
+		t.Errorf("LockAsset of a locked asset = %v, %v", locked, err)
+	}
+	if _, found, err := cfg.db.AcquireAsset(params.Storage, params.Digest); err != nil || found {
+		t.Errorf("AcquireAsset of a locked asset = %v, %v", found, err)
+	}
+	if _, unreferenced, err := cfg.db.ReleaseAsset(params.Storage, params.Key); err != nil || unreferenced {
+		t.Errorf("ReleaseAsset of a locked asset = %v, %v; want it left alone", unreferenced, err)
+	}
+	// Whoever holds a lock past the timeout is gone, so it can be taken.
+	if locked, err := cfg.db.LockAsset(params, time.Now().Add(time.Minute)); err != nil || !locked {
+		t.Errorf("LockAsset of a stale lock = %v, %v; want locked", locked, err)
+	}
+
+	asset, err := cfg.db.AddAssetReference(params)
+	if err != nil || asset.RefCount != 1 {
+		t.Fatalf("AddAssetReference = %d references, %v; want 1", asset.RefCount, err)
+	}
+	if locked, err := cfg.db.LockAsset(params, time.Now().Add(time.Minute)); err != nil || locked {
+		t.Errorf("LockAsset of a referenced asset = %v, %v", locked, err)
+	}
+	if err := cfg.db.UnlockAsset(params.Storage, params.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := cfg.db.AcquireAsset(params.Storage, params.Digest); err != nil || !found {
+		t.Errorf("AcquireAsset = %v, %v; UnlockAsset dropped a referenced asset", found, err)
+	}
+}
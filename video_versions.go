@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// versionRetention limits the versions kept per video. Zero values don't
+// limit anything.
+type versionRetention struct {
+	// Keep is how many versions are kept, counting the current one.
+	Keep   int
+	MaxAge time.Duration
+}
+
+func defaultVersionRetention() versionRetention {
+	return versionRetention{Keep: 5}
+}
+
+// prunable reports whether the version falls outside the policy. index is
+// its position in the video's versions, newest first.
+func (p versionRetention) prunable(version database.VideoVersion, index int, now time.Time) bool {
+	if p.Keep > 0 && index >= p.Keep {
+		return true
+	}
+	return p.MaxAge > 0 && now.Sub(version.CreatedAt) > p.MaxAge
+}
+
+// createVideoVersion records the video's current files as a new version,
+// which takes over their references.
+func (cfg *apiConfig) createVideoVersion(video database.Video, uploaderID uuid.UUID, probe media.ProbeResult) (database.VideoVersion, error) {
+	params := database.CreateVideoVersionParams{
+		VideoID:          video.ID,
+		VideoURL:         *video.VideoURL,
+		OriginalVideoURL: video.OriginalVideoURL,
+		Duration:         probe.Duration,
+		VideoDimensions:  video.VideoDimensions,
+		UploadedBy:       uploaderID,
+	}
+	if stream, ok := probe.VideoStream(); ok {
+		params.VideoCodec = stream.CodecName
+	}
+	if stream, ok := probe.AudioStream(); ok {
+		params.AudioCodec = stream.CodecName
+	}
+	return cfg.db.CreateVideoVersion(params)
+}
+
+// pruneVideoVersions deletes the versions of the video the retention
+// policy no longer keeps, except the current one. Errors are logged, the
+// versions are pruned again after the next upload.
+func (cfg *apiConfig) pruneVideoVersions(ctx context.Context, video database.Video) {
+	versions, err := cfg.db.GetVideoVersions(video.ID)
+	if err != nil {
+		log.Printf("Couldn't get versions of video %s: %v", video.ID, err)
+		return
+	}
+	now := time.Now().UTC()
+	for i, version := range versions {
+		if version.Version == video.Version || !cfg.versionRetention.prunable(version, i, now) {
+			continue
+		}
+		if err := cfg.db.DeleteVideoVersion(video.ID, version.Version); err != nil {
+			log.Printf("Couldn't delete version %d of video %s: %v", version.Version, video.ID, err)
+			continue
+		}
+		cfg.releaseVersionFiles(ctx, video.UserID, version)
+	}
+}
+
+// releaseVersionFiles drops the references held by a version, logging
+// failures.
+func (cfg *apiConfig) releaseVersionFiles(ctx context.Context, ownerID uuid.UUID, version database.VideoVersion) {
+	for _, objectURL := range []*string{&version.VideoURL, version.OriginalVideoURL} {
+		if objectURL == nil {
+			continue
+		}
+		if err := cfg.releaseS3Object(ctx, ownerID, *objectURL); err != nil {
+			log.Printf("Couldn't release version %d of video %s: %v", version.Version, version.VideoID, err)
+		}
+	}
+}